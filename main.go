@@ -1,14 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/base64"
 	//"encoding/hex"
 	"encoding/json"
 	"fmt"
 	//	"github.com/davecgh/go-spew/spew"
-	"github.com/gabstv/go-monero/walletrpc"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 	qrcode "github.com/skip2/go-qrcode"
@@ -23,13 +21,22 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"shadowchat/payments"
+	"shadowchat/payments/btc"
+	"shadowchat/payments/eth"
+	"shadowchat/payments/ln"
+	"shadowchat/payments/sol"
+	"shadowchat/payments/tron"
+	"shadowchat/payments/xmr"
+	"shadowchat/prices"
 	"shadowchat/utils"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 	"unicode/utf8"
@@ -45,7 +52,6 @@ var USDMinimum float64 = 5
 var MediaMin float64 = 0.025 // Currently unused
 var MessageMaxChar int = 250
 var NameMaxChar int = 25
-var starting_port int = 28088
 
 var host_url string = "https://ferret.cash/"
 
@@ -53,6 +59,10 @@ var addressSliceSolana []utils.AddressSolana
 
 var checked string = ""
 var killDono = 35.00 * time.Minute // hours it takes for a dono to be unfulfilled before it is no longer checked.
+var killSeenDono = 6 * time.Hour   // donos already seen in the mempool get longer to reach their confirmations
+var expiryGrace = 1 * time.Minute  // donos whose payment request expires are checked this much longer
+var quoteLock = 15 * time.Minute   // how long a dono's exchange rate holds before a payment is valued at the current one
+var seenCheckingRate = 5 * time.Second
 var indexTemplate *template.Template
 var overflowTemplate *template.Template
 var tosTemplate *template.Template
@@ -76,12 +86,20 @@ var logoutTemplate *template.Template
 var incorrectPasswordTemplate *template.Template
 var baseCheckingRate = 25
 
-var eth_transactions []utils.Transfer
+// Which state of a dono shows the alert, picked per streamer.
+const (
+	alertOnConfirmed = "confirmed" // once the payment has the streamer's confirmations
+	alertOnSeen      = "seen"      // as soon as the payment is seen, possibly still in the mempool
+)
 
-var minSolana, minMonero, minEthereum, minPaint, minHex, minPolygon, minBusd, minShib, minUsdc, minTusd, minWbtc, minPnk float64 // Global variables to hold minimum values required to equal the global value.
-var minDonoValue float64 = 5.0
+var xmrProvider *xmr.Provider
+var moneroWallets *xmr.Supervisor
+var secretKey []byte
+var ethProvider *eth.Provider
+var lnProvider *ln.Provider
+var evmChains []eth.Chain // EVM chains besides Ethereum mainnet, from evm_chains
 
-var solWallets = map[int]utils.SolWallet{}
+var minDonoValue float64 = 5.0
 
 var inviteCodeMap = map[string]utils.InviteCode{}
 
@@ -90,8 +108,6 @@ var PublicRegistrationsEnabled = false
 var ServerMinMediaDono = 5
 var ServerMediaEnabled = true
 
-var xmrWallets = [][]int{}
-
 var globalUsers = map[int]utils.User{}
 var pendingGlobalUsers = map[int]utils.PendingUser{}
 
@@ -105,7 +121,7 @@ var a utils.AlertPageData
 var pb utils.ProgressbarData
 var obsData utils.OBSDataStruct
 
-var prices utils.CryptoPrice
+var priceOracle *prices.Oracle
 
 var pbMessage = "Stream Tomorrow"
 
@@ -127,9 +143,11 @@ var tableTemplate = template.Must(template.New("table").Parse(`
                     <td>{{.Name}}</td>
                     <td>{{.Message}}</td>
                     <td>{{.MediaURL}}</td>
-                    <td>${{.USDAmount}}</td>
+                    <td data-usd="{{.USDAmount}}" data-fiat="{{.FiatAmount}}" data-fiat-currency="{{.Fiat}}">{{.FiatValue}}</td>
                     <td>{{.AmountSent}}</td>
                     <td>{{.CurrencyType}}</td>
+                    <td>{{.TxHash}}</td>
+                    <td>{{.State}}{{if .State.Refundable}} <button onclick="refundDono('{{.ID}}')">Mark refunded</button>{{end}}</td>
                 </tr>
 	{{end}}
 `))
@@ -194,7 +212,8 @@ func donationsHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch the latest data from your database or other data source
 
 	// Retrieve data from the donos table
-	rows, err := db.Query("SELECT * FROM donos WHERE fulfilled = 1 AND amount_sent != '0.0' ORDER BY created_at DESC")
+	received, args := donoStatesIn(utils.ReceivedDonoStates)
+	rows, err := db.Query("SELECT "+donoColumns+" FROM donos WHERE "+received+" AND user_id = ? ORDER BY created_at DESC", append(args, user.UserID)...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -204,36 +223,12 @@ func donationsHandler(w http.ResponseWriter, r *http.Request) {
 	// Create a slice to hold the data
 	var donos []utils.Dono
 	for rows.Next() {
-		var dono utils.Dono
-		var name, message, address, currencyType, encryptedIP, amountToSend, amountSent, mediaURL sql.NullString
-		var usdAmount sql.NullFloat64
-		var userID sql.NullInt64
-		var anonDono, fulfilled sql.NullBool
-		err := rows.Scan(&dono.ID, &userID, &address, &name, &message, &amountToSend, &amountSent, &currencyType, &anonDono, &fulfilled, &encryptedIP, &dono.CreatedAt, &dono.UpdatedAt, &usdAmount, &mediaURL)
+		dono, err := scanDono(rows)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		dono.UserID = int(userID.Int64)
-		dono.Address = address.String
-		dono.Name = name.String
-		dono.Message = message.String
-		dono.AmountToSend = amountToSend.String
-		dono.AmountSent = amountSent.String
-		dono.CurrencyType = currencyType.String
-		dono.AnonDono = anonDono.Bool
-		dono.Fulfilled = fulfilled.Bool
-		dono.EncryptedIP = encryptedIP.String
-		dono.USDAmount = usdAmount.Float64
-		dono.MediaURL = mediaURL.String
-		if dono.UserID == user.UserID {
-			if s, err := strconv.ParseFloat(dono.AmountSent, 64); err == nil {
-				if s > 0 {
-					donos = append(donos, dono)
-				}
-			}
-		}
+		donos = append(donos, dono)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -292,6 +287,16 @@ func main() {
 		panic(err)
 	}
 
+	// Key that seals secrets stored in users.db, such as wallet passwords
+	secretKey, err = utils.LoadSecretKey("./secret_key")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registerPaymentProviders()
+	setupPriceOracle()
+	go stopWalletsOnExit()
+	go moneroWallets.WatchDaemons()
 	go startWallets()
 
 	time.Sleep(5 * time.Second)
@@ -300,9 +305,9 @@ func main() {
 	setupRoutes()
 
 	time.Sleep(2 * time.Second)
-	// Schedule a function to run fetchExchangeRates every three minutes
 	go fetchExchangeRates()
 	go checkDonos()
+	go checkSeenDonos()
 	go checkPendingAccounts()
 	go checkBillingAccounts()
 
@@ -359,8 +364,8 @@ func updateCryptosHandler(w http.ResponseWriter, r *http.Request) {
 
 	if userID == user.UserID {
 		user.CryptosEnabled = mapToCryptosEnabled(updateRequest.SelectedCryptos)
-		if user.CryptosEnabled.XMR && !user.WalletUploaded {
-			user.CryptosEnabled.XMR = false
+		if user.CryptosEnabled["XMR"] && !user.WalletUploaded {
+			user.CryptosEnabled["XMR"] = false
 		}
 		log.Println(user.CryptosEnabled)
 		err = updateUser(user)
@@ -374,17 +379,10 @@ func updateCryptosHandler(w http.ResponseWriter, r *http.Request) {
 
 func mapToCryptosEnabled(selectedCryptos map[string]bool) utils.CryptosEnabled {
 	cryptosEnabled := utils.CryptosEnabled{}
-	cryptosEnabled.XMR = selectedCryptos["monero"]
-	cryptosEnabled.SOL = selectedCryptos["solana"]
-	cryptosEnabled.ETH = selectedCryptos["ethereum"]
-	cryptosEnabled.PAINT = selectedCryptos["paint"]
-	cryptosEnabled.HEX = selectedCryptos["hex"]
-	cryptosEnabled.MATIC = selectedCryptos["matic"]
-	cryptosEnabled.BUSD = selectedCryptos["busd"]
-	cryptosEnabled.SHIB = selectedCryptos["shiba_inu"]
-	cryptosEnabled.PNK = selectedCryptos["pnk"]
-
-	// Return the populated CryptosEnabled struct
+	for _, c := range utils.Currencies {
+		cryptosEnabled[c.Code] = selectedCryptos[c.Key]
+	}
+
 	return cryptosEnabled
 }
 func setupRoutes() {
@@ -392,10 +390,6 @@ func setupRoutes() {
 		http.ServeFile(w, r, "web/style.css")
 	})
 
-	http.HandleFunc("/xmr.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/xmr.svg")
-	})
-
 	http.HandleFunc("/bignumber.js", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "web/js/bignumber.js")
 	})
@@ -416,49 +410,17 @@ func setupRoutes() {
 		http.ServeFile(w, r, "web/loader.svg")
 	})
 
-	http.HandleFunc("/eth.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/eth.svg")
-	})
-
-	http.HandleFunc("/sol.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/sol.svg")
-	})
-
-	http.HandleFunc("/busd.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/busd.svg")
-	})
-
-	http.HandleFunc("/hex.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/hex.svg")
-	})
-
-	http.HandleFunc("/matic.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/matic.svg")
-	})
-
-	http.HandleFunc("/paint.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/paint.svg")
-	})
-
-	http.HandleFunc("/pnk.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/pnk.svg")
-	})
-
-	http.HandleFunc("/shiba_inu.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/shiba_inu.svg")
-	})
-
-	http.HandleFunc("/tether.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/tether.svg")
-	})
-
-	http.HandleFunc("/usdc.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/usdc.svg")
-	})
-
-	http.HandleFunc("/wbtc.svg", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "web/wbtc.svg")
-	})
+	icons := map[string]bool{}
+	for _, c := range utils.Currencies {
+		icon := c.Icon
+		if icons[icon] {
+			continue // tokens on several chains share their icon
+		}
+		icons[icon] = true
+		http.HandleFunc("/"+icon, func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "web/"+icon)
+		})
+	}
 
 	http.Handle("/media/", http.StripPrefix("/media/", http.FileServer(http.Dir("web/obs/media/"))))
 	http.HandleFunc("/users/", handleUsers)
@@ -474,6 +436,7 @@ func setupRoutes() {
 		{"/alert", alertOBSHandler},
 		{"/viewdonos", viewDonosHandler},
 		{"/replaydono", replayDonoHandler},
+		{"/refunddono", refundDonoHandler},
 		{"/progressbar", progressbarOBSHandler},
 		{"/login", loginHandler},
 		{"/incorrect_login", incorrectLoginHandler},
@@ -487,6 +450,7 @@ func setupRoutes() {
 		{"/overflow", overflowHandler},
 		{"/billing", accountBillingHandler},
 		{"/changeusermonero", changeUserMoneroHandler},
+		{"/createmonerowallet", createMoneroWalletHandler},
 		{"/usermanager", allUsersHandler},
 		{"/refresh", refreshHandler},
 		{"/testdonation", testDonoHandler},
@@ -558,6 +522,64 @@ func replayDonoHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// refundDonoHandler marks one of the streamer's paid donos as refunded by
+// hand, for the record; the refund itself is up to the streamer.
+func refundDonoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, valid := getLoggedInUser(w, r)
+	if !valid {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var refund struct {
+		ID     string `json:"donoID"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&refund); err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	donoID, err := strconv.Atoi(refund.ID)
+	if err != nil {
+		http.Error(w, "Invalid dono ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query("SELECT "+donoColumns+" FROM donos WHERE dono_id = ? AND user_id = ?", donoID, user.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		http.Error(w, "Dono not found", http.StatusNotFound)
+		return
+	}
+	dono, err := scanDono(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+
+	if !dono.State.Refundable() {
+		http.Error(w, "Only paid donos can be marked as refunded", http.StatusBadRequest)
+		return
+	}
+	reason := "refunded by " + user.Username
+	if note := truncateStrings(condenseSpaces(refund.Reason), MessageMaxChar); note != "" {
+		reason += ": " + note
+	}
+	if err := setDonoState(&dono, utils.DonoRefundedManually, reason); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func testDonoHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		return
@@ -583,7 +605,7 @@ func startWallets() {
 	printUserColumns()
 	users, err := getAllUsers()
 	if err != nil {
-		log.Fatalf("startWallet() error:", err)
+		log.Fatalf("startWallet() error: %v", err)
 	}
 
 	for _, user := range users {
@@ -592,18 +614,14 @@ func startWallets() {
 			log.Println("User valid", user.UserID, "User eth_address:", globalUsers[user.UserID].EthAddress)
 			if user.WalletUploaded {
 				log.Println("Monero wallet uploaded")
-				xmrWallets = append(xmrWallets, []int{user.UserID, starting_port})
-				go startMoneroWallet(starting_port, user.UserID, user)
-				starting_port++
+				startMoneroWallet(user)
 
 			} else {
 				if checkWalletExists(user.UserID) {
 					log.Println("Monero wallet uploaded")
-					xmrWallets = append(xmrWallets, []int{user.UserID, starting_port})
-					go startMoneroWallet(starting_port, user.UserID, user)
+					startMoneroWallet(user)
 					user.WalletUploaded = true
 					updateUser(user)
-					starting_port++
 				} else {
 					log.Println("Monero wallet not uploaded")
 				}
@@ -614,16 +632,131 @@ func startWallets() {
 		}
 	}
 
-	fmt.Println("startWallet() starting monitoring of solana addresses.")
-	for _, user := range users {
-		solWallets[user.UserID] = utils.SolWallet{
-			Address: user.SolAddress,
-			Amount:  0.00,
+	payments.StartAll(users)
+}
+
+// registerPaymentProviders registers every supported chain. The order
+// decides which provider wins if two claim the same currency code.
+func registerPaymentProviders() {
+	// monero_daemons lists monerod addresses, one per line, best first
+	walletConfig := xmr.DefaultSupervisorConfig()
+	daemons, err := xmr.ReadDaemonList("./monero_daemons")
+	if err == nil {
+		walletConfig.Daemons = daemons
+	} else if !os.IsNotExist(err) {
+		log.Println("Error reading monero_daemons, using the default daemon:", err)
+	}
+	moneroWallets = xmr.NewSupervisor(walletConfig)
+	xmrProvider = xmr.New(moneroWallets)
+	payments.Register(xmrProvider)
+
+	// solana_rpc lists Solana JSON-RPC endpoints, one per line, best first
+	solConfig := sol.DefaultConfig()
+	solRPCs, err := sol.ReadRPCList("./solana_rpc")
+	if err == nil {
+		solConfig.RPCURLs = solRPCs
+	} else if !os.IsNotExist(err) {
+		log.Println("Error reading solana_rpc, using", solConfig.RPCURLs, err)
+	}
+	// solana_ws holds a websocket endpoint to be notified of transfers through
+	// instead of polling for them
+	solWS, err := os.ReadFile("./solana_ws")
+	if err == nil && strings.TrimSpace(string(solWS)) != "" {
+		wsURL, err := sol.WebsocketURL(strings.TrimSpace(string(solWS)))
+		if err == nil {
+			solConfig.WSURL = wsURL
+		} else {
+			log.Println("Error reading solana_ws, polling only:", err)
+		}
+	}
+	payments.Register(sol.New(solConfig, dbCursors{}))
+
+	// tron_api holds a TronGrid compatible API URL and, optionally, its key
+	tronAPI, err := tron.ReadAPI("./tron_api")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading tron_api, using", tron.DefaultAPI, err)
+		}
+		tronAPI = tron.NewClient(tron.DefaultAPI, "")
+	}
+	payments.Register(tron.New(tron.DefaultConfig(), tronAPI, dbCursors{}, dbDonos{}))
+
+	// bitcoin_backend holds the backend kind and URL, e.g. "esplora https://mempool.space/api"
+	btcConfig := btc.DefaultConfig()
+	btcBackend, err := btc.ReadBackend("./bitcoin_backend")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading bitcoin_backend, using", btc.DefaultEsplora, err)
+		}
+		btcBackend = btc.NewEsplora(btc.DefaultEsplora)
+	}
+	// a node of your own can be asked about every address as often as
+	// donos are checked
+	if _, ok := btcBackend.(*btc.Bitcoind); ok {
+		btcConfig.LookupEvery = 0
+	}
+	// bitcoin_confirmations overrides how many blocks a BTC payment needs
+	btcConfirmations, err := os.ReadFile("./bitcoin_confirmations")
+	if err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(btcConfirmations))); err == nil && n >= 1 {
+			btcConfig.Confirmations = n
 		}
 	}
+	payments.Register(btc.New(btcConfig, btcBackend, dbCursors{}, dbDonos{}, dbDonos{}))
+
+	// Lightning invoices come from each streamer's own node, with the
+	// macaroon or rune sealed in users.db
+	lnConfig := ln.DefaultConfig()
+	lnConfig.OpenSecret = func(stored string) (string, error) {
+		return utils.OpenSecret(secretKey, stored)
+	}
+	// lightning_private_nodes lets streamers' nodes be on private addresses
+	// and plain http, for servers whose streamers are trusted
+	if _, err := os.Stat("./lightning_private_nodes"); err == nil {
+		lnConfig.AllowPrivateNodes = true
+	}
+	lnProvider = ln.New(lnConfig, dbCursors{})
+	payments.Register(lnProvider)
 
-	utils.SetSolWallets(solWallets)
-	go utils.StartMonitoringSolana()
+	// eth_rpc holds the URL of the Ethereum JSON-RPC endpoint, e.g. your own node
+	ethConfig := eth.DefaultConfig()
+	ethRPC, err := os.ReadFile("./eth_rpc")
+	if err == nil && strings.TrimSpace(string(ethRPC)) != "" {
+		ethConfig.RPCURL = strings.TrimSpace(string(ethRPC))
+	} else {
+		log.Println("No eth_rpc file, using", ethConfig.RPCURL)
+	}
+	// eth_confirmations overrides how many blocks an ETH/ERC-20 payment needs
+	ethConfirmations, err := os.ReadFile("./eth_confirmations")
+	if err == nil {
+		n, err := strconv.ParseUint(strings.TrimSpace(string(ethConfirmations)), 10, 64)
+		if err == nil && n > 0 {
+			ethConfig.Confirmations = n
+		}
+	}
+	ethProvider = eth.New(ethConfig, dbCursors{}, dbDonos{})
+	payments.Register(ethProvider)
+
+	// evm_chains lists other EVM chains, such as L2s, with their RPC URL and
+	// tokens; each is followed by a provider of its own
+	chains, err := eth.ReadChains("./evm_chains")
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Error reading evm_chains, only using Ethereum:", err)
+	}
+	for _, chain := range chains {
+		added := true
+		for _, c := range chain.Currencies() {
+			if err := utils.AddCurrency(c); err != nil {
+				log.Println("Not adding chain", chain.Key, err)
+				added = false
+			}
+		}
+		if !added {
+			continue
+		}
+		evmChains = append(evmChains, chain)
+		payments.Register(eth.New(chain.Config(), dbCursors{}, dbDonos{}))
+	}
 }
 
 func checkValidSubscription(DateEnabled time.Time) bool {
@@ -667,16 +800,25 @@ func allUsersHandler(w http.ResponseWriter, r *http.Request) {
 
 	if user.Username == "admin" {
 
+		users := make(map[int]utils.User, len(globalUsers))
+		for id, u := range globalUsers {
+			users[id] = setWalletState(u)
+		}
+
 		// Define the data to be passed to the HTML template
 		data := struct {
 			Title            string
 			RegistrationOpen bool
 			Users            map[int]utils.User
+			Wallets          map[int]xmr.WalletStatus
+			Daemons          []xmr.DaemonStatus
 			InviteCodes      map[string]utils.InviteCode
 		}{
 			Title:            "Users Dashboard",
 			RegistrationOpen: PublicRegistrationsEnabled,
-			Users:            globalUsers,
+			Users:            users,
+			Wallets:          moneroWallets.Statuses(),
+			Daemons:          moneroWallets.Daemons(),
 			InviteCodes:      inviteCodeMap,
 		}
 
@@ -768,68 +910,118 @@ func getAllCodes() map[string]utils.InviteCode {
 
 }
 
-func getAllUsers() ([]utils.User, error) {
-	var users []utils.User
-	rows, err := db.Query("SELECT * FROM users")
+// userColumns lists the users columns in the order scanUser reads them.
+const userColumns = "id, username, HashedPassword, eth_address, sol_address, hex_address, xmr_wallet_password, min_donation_threshold, min_media_threshold, media_enabled, created_at, modified_at, links, dono_gif, dono_sound, alert_url, date_enabled, wallet_uploaded, cryptos_enabled, default_crypto, xmr_address_mode, xmr_confirmations, alert_on, btc_xpub, btc_gap_limit, ln_kind, ln_url, ln_credential, ln_cert, evm_addresses, tron_address, fiat_currency"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads one row selected with userColumns and fills in defaults
+// for columns added after the user was created.
+func scanUser(row rowScanner) (utils.User, error) {
+	var user utils.User
+	var links, donoGIF, donoSound, alertURL, defaultCrypto, cryptosEnabled, xmrAddressMode, alertOn, btcXpub, lnKind, lnURL, lnCredential, lnCert, evmAddresses, tronAddress, fiat sql.NullString
+	var xmrConfirmations, btcGapLimit sql.NullInt64
+
+	err := row.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress,
+		&user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono,
+		&user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &links, &donoGIF, &donoSound,
+		&alertURL, &user.DateEnabled, &user.WalletUploaded, &cryptosEnabled, &defaultCrypto, &xmrAddressMode, &xmrConfirmations, &alertOn, &btcXpub, &btcGapLimit,
+		&lnKind, &lnURL, &lnCredential, &lnCert, &evmAddresses, &tronAddress, &fiat)
 	if err != nil {
-		return nil, err
+		return utils.User{}, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var user utils.User
-		var links, donoGIF, donoSound, alertURL, defaultCrypto, cryptosEnabled sql.NullString
+	user.Links = links.String
+	if !links.Valid {
+		user.Links = ""
+	}
 
-		err = rows.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress,
-			&user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono,
-			&user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &links, &donoGIF, &donoSound,
-			&alertURL, &user.DateEnabled, &user.WalletUploaded, &cryptosEnabled, &defaultCrypto)
+	user.DonoGIF = donoGIF.String
+	if !donoGIF.Valid {
+		user.DonoGIF = "default.gif"
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	user.DonoSound = donoSound.String
+	if !donoSound.Valid {
+		user.DonoSound = "default.mp3"
+	}
 
-		user.Links = links.String
-		if !links.Valid {
-			user.Links = ""
-		}
+	user.DefaultCrypto = defaultCrypto.String
+	if !defaultCrypto.Valid {
+		user.DefaultCrypto = ""
+	}
 
-		user.DonoGIF = donoGIF.String
-		if !donoGIF.Valid {
-			user.DonoGIF = "default.gif"
-		}
+	user.AlertURL = alertURL.String
+	if !alertURL.Valid {
+		user.AlertURL = utils.GenerateUniqueURL()
+	}
 
-		user.DonoSound = donoSound.String
-		if !donoSound.Valid {
-			user.DonoSound = "default.mp3"
-		}
+	user.CryptosEnabled = cryptosJsonStringToStruct(cryptosEnabled.String)
+	if !cryptosEnabled.Valid {
+		log.Println("user cryptos enabled not fixed")
+		user.CryptosEnabled = utils.DefaultCryptosEnabled()
+	}
 
-		user.DefaultCrypto = defaultCrypto.String
-		if !defaultCrypto.Valid {
-			user.DefaultCrypto = ""
-		}
+	user.XMRAddressMode = xmrAddressMode.String
+	if user.XMRAddressMode == "" {
+		user.XMRAddressMode = xmr.ModeIntegrated
+	}
 
-		user.AlertURL = alertURL.String
-		if !alertURL.Valid {
-			user.AlertURL = utils.GenerateUniqueURL()
-		}
+	user.XMRConfirmations = int(xmrConfirmations.Int64)
+	if !xmrConfirmations.Valid {
+		user.XMRConfirmations = xmr.DefaultConfirmations
+	}
 
-		ce := utils.CryptosEnabled{
-			XMR:   true,
-			SOL:   true,
-			ETH:   false,
-			PAINT: false,
-			HEX:   true,
-			MATIC: false,
-			BUSD:  true,
-			SHIB:  false,
-			PNK:   true,
+	user.AlertOn = alertOn.String
+	if user.AlertOn == "" {
+		user.AlertOn = alertOnConfirmed
+	}
+
+	user.BTCXpub = btcXpub.String
+	user.BTCGapLimit = int(btcGapLimit.Int64)
+	if !btcGapLimit.Valid {
+		user.BTCGapLimit = btc.DefaultGapLimit
+	}
+
+	user.LNKind = lnKind.String
+	if user.LNKind == "" {
+		user.LNKind = ln.KindLND
+	}
+	user.LNURL = lnURL.String
+	user.LNCredential = lnCredential.String
+	user.LNCert = lnCert.String
+
+	user.TronAddress = tronAddress.String
+
+	user.Fiat = fiat.String
+	if user.Fiat == "" {
+		user.Fiat = utils.BaseFiat
+	}
+
+	user.EVMAddresses = map[uint64]string{}
+	if evmAddresses.String != "" {
+		if err := json.Unmarshal([]byte(evmAddresses.String), &user.EVMAddresses); err != nil {
+			log.Println("Bad evm_addresses of user", user.UserID, err)
 		}
+	}
+
+	return user, nil
+}
+
+func getAllUsers() ([]utils.User, error) {
+	var users []utils.User
+	rows, err := db.Query("SELECT " + userColumns + " FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		user.CryptosEnabled = cryptosJsonStringToStruct(cryptosEnabled.String)
-		if !cryptosEnabled.Valid {
-			log.Println("user cryptos enabled not fixed")
-			user.CryptosEnabled = ce
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
 		}
 
 		users = append(users, user)
@@ -898,7 +1090,7 @@ func getActiveETHUsers(db *sql.DB) ([]*utils.User, error) {
 	var users []*utils.User
 
 	// Define the query to select the active ETH users
-	query := `SELECT * FROM users WHERE eth_address != ''`
+	query := "SELECT " + userColumns + " FROM users WHERE eth_address != ''"
 
 	// Execute the query
 	rows, err := db.Query(query)
@@ -907,8 +1099,7 @@ func getActiveETHUsers(db *sql.DB) ([]*utils.User, error) {
 	}
 
 	for rows.Next() {
-		var user utils.User
-		err = rows.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress, &user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono, &user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &user.Links, &user.DonoGIF, &user.DonoSound, &user.AlertURL, &user.WalletUploaded, &user.DateEnabled)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -925,7 +1116,7 @@ func getActiveXMRUsers(db *sql.DB) ([]*utils.User, error) {
 	var users []*utils.User
 
 	// Define the query to select the active XMR users
-	query := `SELECT * FROM users WHERE wallet_uploaded = ?`
+	query := "SELECT " + userColumns + " FROM users WHERE wallet_uploaded = ?"
 
 	// Execute the query
 	rows, err := db.Query(query, true)
@@ -934,8 +1125,7 @@ func getActiveXMRUsers(db *sql.DB) ([]*utils.User, error) {
 	}
 
 	for rows.Next() {
-		var user utils.User
-		err = rows.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress, &user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono, &user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &user.Links, &user.DonoGIF, &user.DonoSound, &user.AlertURL, &user.WalletUploaded, &user.DateEnabled)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -949,22 +1139,6 @@ func getActiveXMRUsers(db *sql.DB) ([]*utils.User, error) {
 	return users, nil
 }
 
-func getUserCryptosEnabled(user utils.User) (utils.User, error) {
-
-	user.CryptosEnabled.XMR = false
-	user.CryptosEnabled.SOL = false
-	user.CryptosEnabled.ETH = false
-	user.CryptosEnabled.PAINT = true
-	user.CryptosEnabled.HEX = false
-	user.CryptosEnabled.MATIC = true
-	user.CryptosEnabled.BUSD = false
-	user.CryptosEnabled.SHIB = true
-	user.CryptosEnabled.PNK = false
-
-	return user, nil
-
-}
-
 // get links for a user
 func getUserLinks(user utils.User) ([]utils.Link, error) {
 	if user.Links == "" {
@@ -1016,21 +1190,38 @@ func createTestDono(user_id int, name string, curr string, message string, amoun
 	log.Println("TESTING DONO IN FIVE SECONDS")
 	time.Sleep(5 * time.Second)
 	log.Println("TESTING DONO NOW")
-	err := createNewQueueEntry(db, user_id, "TestAddress", name, message, amount, curr, usdAmount, media_url_)
+	fiat := globalUsers[user_id].Fiat
+	fiatAmount, ok := usdToFiat(usdAmount, fiat)
+	if !ok {
+		fiat, fiatAmount = utils.BaseFiat, usdAmount
+	}
+	err := createNewQueueEntry(db, user_id, "TestAddress", name, message, amount, curr, usdAmount, fiatAmount, fiat, media_url_)
 	if err != nil {
 		panic(err)
 	}
-	addDonoToDonoBar(amount, curr, user_id)
+	addDonoToDonoBar(fiatAmount, user_id)
 }
 
 func replayDono(donation utils.Donation, userID int) {
-	valid, media_url_ := checkDonoForMediaUSDThreshold(donation.DonationMedia, convertToFloat64(donation.USDValue))
+	usdValue := convertToFloat64(donation.USDValue)
+	valid, media_url_ := checkDonoForMediaUSDThreshold(donation.DonationMedia, usdValue)
 
 	if valid == false {
 		media_url_ = ""
 	}
 
-	err := createNewQueueEntry(db, userID, "ReplayAddress", donation.DonationName, donation.DonationMessage, donation.AmountSent, donation.Crypto, convertToFloat64(donation.USDValue), media_url_)
+	// donations replayed from before fiat values were stored, and the test
+	// dono, only have a USD value
+	fiat, fiatValue := donation.Fiat, usdValue
+	if donation.FiatValue != "" {
+		fiatValue = convertToFloat64(donation.FiatValue)
+	} else if converted, ok := usdToFiat(usdValue, globalUsers[userID].Fiat); ok {
+		fiat, fiatValue = globalUsers[userID].Fiat, converted
+	} else {
+		fiat = utils.BaseFiat
+	}
+
+	err := createNewQueueEntry(db, userID, "ReplayAddress", donation.DonationName, donation.DonationMessage, donation.AmountSent, donation.Crypto, usdValue, fiatValue, fiat, media_url_)
 	if err != nil {
 		panic(err)
 	}
@@ -1071,10 +1262,9 @@ func viewDonosHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie = cookie
-
 	// Retrieve data from the donos table
-	rows, err := db.Query("SELECT * FROM donos WHERE fulfilled = 1 AND amount_sent != 0 ORDER BY created_at DESC")
+	received, args := donoStatesIn(utils.ReceivedDonoStates)
+	rows, err := db.Query("SELECT "+donoColumns+" FROM donos WHERE "+received+" AND user_id = ? ORDER BY created_at DESC", append(args, user.UserID)...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -1084,36 +1274,12 @@ func viewDonosHandler(w http.ResponseWriter, r *http.Request) {
 	// Create a slice to hold the data
 	var donos []utils.Dono
 	for rows.Next() {
-		var dono utils.Dono
-		var name, amountToSend, amountSent, message, address, currencyType, encryptedIP, mediaURL sql.NullString
-		var usdAmount sql.NullFloat64
-		var userID sql.NullInt64
-		var anonDono, fulfilled sql.NullBool
-		err := rows.Scan(&dono.ID, &userID, &address, &name, &message, &amountToSend, &amountSent, &currencyType, &anonDono, &fulfilled, &encryptedIP, &dono.CreatedAt, &dono.UpdatedAt, &usdAmount, &mediaURL)
+		dono, err := scanDono(rows)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		dono.UserID = int(userID.Int64)
-		dono.Address = address.String
-		dono.Name = name.String
-		dono.Message = message.String
-		dono.AmountToSend = amountToSend.String
-		dono.AmountSent = amountSent.String
-		dono.CurrencyType = currencyType.String
-		dono.AnonDono = anonDono.Bool
-		dono.Fulfilled = fulfilled.Bool
-		dono.EncryptedIP = encryptedIP.String
-		dono.USDAmount = usdAmount.Float64
-		dono.MediaURL = mediaURL.String
-		if dono.UserID == user.UserID {
-			if s, err := strconv.ParseFloat(dono.AmountSent, 64); err == nil {
-				if s > 0 {
-					donos = append(donos, dono)
-				}
-			}
-		}
+		donos = append(donos, dono)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -1149,179 +1315,109 @@ func viewDonosHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func setUserMinDonos(user utils.User) utils.User {
-	var err error
-	user.MinSol, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Solana)), 64)
-	user.MinEth, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Ethereum)), 64)
-	user.MinXmr, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Monero)), 64)
-	user.MinPaint, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Paint)), 64)
-	user.MinHex, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Hexcoin)), 64)
-	user.MinMatic, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Polygon)), 64)
-	user.MinBusd, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.BinanceUSD)), 64)
-	user.MinShib, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.ShibaInu)), 64)
-	user.MinUsdc, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono))), 64)
-	user.MinTusd, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono))), 64)
-	user.MinWbtc, _ = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.WBTC)), 64)
-	user.MinPnk, err = strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)/prices.Kleros)), 64)
-	if err != nil {
-		log.Println("setUserMinDonos() err:", err)
-	}
-
-	return user
-}
-
-func setMinDonos() {
-	for i := range globalUsers {
-		globalUsers[i] = setUserMinDonos(globalUsers[i])
+	minDonos := make(map[string]float64)
+	fiatPrice, ok := fiatRate(user.Fiat)
+	if !ok {
+		user.MinDonos = minDonos // nothing can be quoted without the fiat rate
+		return user
 	}
-}
-
-func fetchExchangeRates() {
-	for {
-		// Fetch the exchange rate data from the API
-		var err error
-		prices, err = utils.GetCryptoPrices()
+	for _, c := range utils.Currencies {
+		price, ok := priceOracle.Price(c.Code)
+		if !ok {
+			continue
+		}
+		min, err := strconv.ParseFloat(fmt.Sprintf("%.5f", (float64(user.MinDono)*fiatPrice/price)), 64)
 		if err != nil {
-			log.Println(err)
-		} else {
-			setMinDonos()
+			log.Println("setUserMinDonos() err:", err)
 		}
-
-		time.Sleep(80 * time.Second)
+		minDonos[c.Code] = min
 	}
+	user.MinDonos = minDonos
 
+	return user
 }
 
-func createNewEthDono(name string, message string, mediaURL string, amountNeeded float64, cryptoCode string, encrypted_ip string) utils.SuperChat {
-	new_dono := utils.CreatePendingDono(name, message, mediaURL, amountNeeded, cryptoCode, encrypted_ip)
-	pending_donos = utils.AppendPendingDono(pending_donos, new_dono)
-
-	return new_dono
+// fiatRate returns the USD price of one unit of a fiat currency, or false if
+// its rate is unknown or stale. An empty currency is USD.
+func fiatRate(fiat string) (float64, bool) {
+	if fiat == "" {
+		fiat = utils.BaseFiat
+	}
+	return priceOracle.Price(fiat)
 }
 
-func startMoneroWallet(portInt, userID int, user utils.User) {
-	portID := getPortID(xmrWallets, userID)
-	found := true
-
-	if portID == -100 {
-		found = false
+// usdToFiat converts a USD amount to a fiat currency, rounded to cents.
+func usdToFiat(usd float64, fiat string) (float64, bool) {
+	rate, ok := fiatRate(fiat)
+	if !ok {
+		return 0, false
 	}
+	return utils.USDToFiat(usd, rate), true
+}
 
-	portStr := strconv.Itoa(portID)
-
-	if found {
-		fmt.Println("Port ID for user", userID, "is", portID)
-	} else {
-		fmt.Println("Port ID not found for user", userID)
-		portStr = strconv.Itoa(portInt)
+func setMinDonos() {
+	for i := range globalUsers {
+		globalUsers[i] = setUserMinDonos(globalUsers[i])
 	}
+}
 
-	cmd := exec.Command("./start_xmr_wallet.sh", portStr, strconv.Itoa(userID))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
+// setupPriceOracle reads the price sources from ./price_sources, or uses the
+// public price APIs if there is no such file.
+func setupPriceOracle() {
+	sources, err := prices.ReadSources("./price_sources")
 	if err != nil {
-		log.Println("Error running command:", err)
-		walletRunning := CheckMoneroPort(userID)
-		if walletRunning {
-			user.WalletUploaded = true
-			user.WalletRunning = true
-			user.WalletPending = false
-		} else {
-			user.WalletUploaded = false
-			user.WalletRunning = false
-			user.WalletPending = false
+		if !os.IsNotExist(err) {
+			log.Fatal(err)
 		}
-		updateUser(user)
-		return
+		sources = prices.DefaultSources()
 	}
-
-	_ = walletrpc.New(walletrpc.Config{
-		Address: "http://127.0.0.1:" + strconv.Itoa(portInt) + "/json_rpc",
-	})
-
-	fmt.Println("Done starting monero wallet for", portStr, userID)
-	user.WalletRunning = true
-	user.WalletUploaded = true
-	user.WalletPending = true
-	updateUser(user)
+	priceOracle = prices.New(prices.DefaultConfig(), sources...)
 }
 
-func CheckMoneroPort(userID int) bool {
-	payload := strings.NewReader(`{"jsonrpc":"2.0","id":"0","method":"make_integrated_address"}`)
-	portID := getPortID(xmrWallets, userID)
-
-	found := true
-	if portID == -100 {
-		return false
-	}
-
-	if found {
-		fmt.Println("Port ID for user", userID, "is", portID)
-	} else {
-		fmt.Println("Port ID not found for user", userID)
-	}
-
-	rpcURL_ := "http://127.0.0.1:" + strconv.Itoa(portID) + "/json_rpc"
+// fetchExchangeRates keeps the prices and the minimum donos up to date.
+func fetchExchangeRates() {
+	priceOracle.Run(setMinDonos)
+}
 
-	req, err := http.NewRequest("POST", rpcURL_, payload)
+func startMoneroWallet(user utils.User) {
+	password, err := utils.OpenSecret(secretKey, user.XMRWalletPassword)
 	if err != nil {
-		return false
+		log.Println("Error reading monero wallet password for", user.UserID, err)
+		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
+	err = moneroWallets.Start(user.UserID, password)
 	if err != nil {
-		return false
-	}
-
-	resp := &utils.RPCResponse{}
-	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
-		return false
+		log.Println("Error starting monero wallet for", user.UserID, err)
 	}
+}
 
-	return true
+// stopWalletsOnExit lets every monero-wallet-rpc save and exit before the
+// server does, so none are left holding a port or a wallet file.
+func stopWalletsOnExit() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	log.Println("Stopping monero wallets")
+	moneroWallets.StopAll()
+	os.Exit(0)
 }
 
 func stopMoneroWallet(user utils.User) {
-	portID := getPortID(xmrWallets, user.UserID)
-
-	found := true
-	if portID == -100 {
-		found = false
-	}
-
-	if !found {
-		fmt.Println("Port ID not found for user", user.UserID)
-		return
-	}
-
-	portStr := strconv.Itoa(portID)
-
-	cmd := exec.Command("monero/monero-wallet-rpc", "--rpc-bind-port", portStr, "--command", "stop_wallet")
-
-	// Capture the output of the command
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("Error running command: %v\n", err)
-		return
-	}
+	moneroWallets.Stop(user.UserID)
+}
 
-	// Print the output of the command
-	fmt.Println(string(output))
+// setWalletState copies the supervisor's view of the user's monero wallet
+// onto the user for the templates.
+func setWalletState(user utils.User) utils.User {
+	status := moneroWallets.Status(user.UserID)
+	user.WalletState = string(status.State)
+	user.WalletRunning = status.State == xmr.StateRunning
+	user.WalletPending = user.WalletUploaded && !user.WalletRunning
+	return user
 }
 
 func checkDonos() {
 	for {
-		log.Println("Checking pending wallets for successful starting")
-		for _, u_ := range globalUsers {
-			if u_.WalletUploaded && u_.WalletPending {
-				u_.WalletPending = CheckMoneroPort(u_.UserID)
-				if !u_.WalletPending {
-					updateUser(u_)
-				}
-			}
-		}
-
 		log.Println("Checking donos via checkDonos()")
 		fulfilledDonos := checkUnfulfilledDonos()
 		if len(fulfilledDonos) > 0 {
@@ -1339,13 +1435,276 @@ func checkDonos() {
 			user.BillingData.AmountTotal += dono.USDAmount
 			updateUser(user)
 
-			err := createNewQueueEntry(db, dono.UserID, dono.Address, dono.Name, dono.Message, dono.AmountSent, dono.CurrencyType, dono.USDAmount, dono.MediaURL)
+			err := queueDonoAlert(dono, dono.AmountSent)
 			if err != nil {
 				panic(err)
 			}
 
 		}
-		time.Sleep(time.Duration(25) * time.Second)
+		// a provider that is pushed payments wakes this up early
+		select {
+		case <-payments.Settled():
+		case <-time.After(time.Duration(25) * time.Second):
+		}
+	}
+}
+
+// checkSeenDonos asks providers that watch the mempool about every pending
+// dono every few seconds, outside the backoff of checkUnfulfilledDonos, so
+// streamers alerting on "seen" get the alert while the donor is watching.
+// Fulfilling the dono and the billing totals still wait for checkDonos.
+// Donos from an IP with many pending are asked about less often, and each
+// provider is polled once for all the donos due.
+func checkSeenDonos() {
+	checkedAt := make(map[int]time.Time)
+	for {
+		donos, err := getUnseenDonos()
+		if err != nil {
+			log.Println("Error getting unseen donos:", err)
+		}
+		ips, _ := getUnfulfilledDonoIPs()
+
+		due := make(map[payments.Provider][]utils.Dono)
+		pending := make(map[int]time.Time)
+		for _, dono := range donos {
+			pending[dono.ID] = checkedAt[dono.ID]
+			provider, ok := payments.ForCurrency(dono.CurrencyType)
+			if !ok {
+				continue
+			}
+			if _, ok := provider.(payments.MempoolWatcher); !ok {
+				continue
+			}
+			wait := time.Duration(float64(seenCheckingRate) * returnIPPenalty(ips, dono.EncryptedIP))
+			if time.Since(checkedAt[dono.ID]) < wait {
+				continue
+			}
+			pending[dono.ID] = time.Now()
+			due[provider] = append(due[provider], dono)
+		}
+		checkedAt = pending
+
+		for provider, donos := range due {
+			if err := provider.Poll(donos); err != nil {
+				log.Println(provider.Name(), "poll error:", err)
+			}
+			watcher := provider.(payments.MempoolWatcher)
+			for _, dono := range donos {
+				match, err := watcher.Seen(dono)
+				if err != nil {
+					log.Println(provider.Name(), "seen error:", err)
+					continue
+				}
+				if match.TxHash != "" {
+					if err := claimDonoTx(&dono, match.TxHash, match.LogIndex); err != nil {
+						log.Println("Dono", dono.ID, "seen", match.TxHash, "which paid another dono:", err)
+						continue
+					}
+				}
+				if match.Seen || match.Found {
+					markDonoSeen(dono, utils.FormatAtomic(match.AmountSent, dono.CurrencyType))
+				}
+			}
+		}
+		time.Sleep(seenCheckingRate)
+	}
+}
+
+// getUnseenDonos returns the pending donos nothing has been seen for yet.
+func getUnseenDonos() ([]utils.Dono, error) {
+	rows, err := db.Query("SELECT "+donoColumns+" FROM donos WHERE state = ? AND created_at > ?", utils.DonoAwaitingPayment, time.Now().UTC().Add(-killDono))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var donos []utils.Dono
+	for rows.Next() {
+		dono, err := scanDono(rows)
+		if err != nil {
+			return nil, err
+		}
+		donos = append(donos, dono)
+	}
+	return donos, rows.Err()
+}
+
+// markDonoSeen records that a dono's payment showed up and queues its alert
+// if that is what the streamer alerts on.
+func markDonoSeen(dono utils.Dono, amountSeen string) {
+	seenAt := time.Now().UTC()
+	revalueLateDono(&dono, amountSeen, seenAt)
+	err := setDonoState(&dono, utils.DonoSeen, "payment of "+amountSeen+" "+dono.CurrencyType+" seen")
+	if err != nil {
+		log.Println("Error marking dono", dono.ID, "as seen:", err)
+		return
+	}
+	_, err = db.Exec("UPDATE donos SET seen = true, seen_at = ? WHERE dono_id = ?", seenAt, dono.ID)
+	if err == nil {
+		err = saveDonoValue(dono)
+	}
+	if err != nil {
+		log.Println("Error marking dono", dono.ID, "as seen:", err)
+		return
+	}
+
+	if belowMinimum(dono) {
+		skipDonoAlert(dono)
+		return
+	}
+	if globalUsers[dono.UserID].AlertOn != alertOnSeen {
+		return
+	}
+	err = queueDonoAlert(dono, amountSeen)
+	if err != nil {
+		log.Println("Error queueing alert for dono", dono.ID, err)
+	}
+}
+
+// queueDonoAlert adds the dono's alert to the queue unless it has been
+// queued already, so a dono alerted on when seen isn't alerted on again
+// when it confirms.
+func queueDonoAlert(dono utils.Dono, amount string) error {
+	res, err := db.Exec("UPDATE donos SET alerted = true WHERE dono_id = ? AND (alerted IS NULL OR alerted = false)", dono.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	return createNewQueueEntry(db, dono.UserID, dono.Address, dono.Name, dono.Message, amount, dono.CurrencyType, dono.USDAmount, dono.FiatAmount, dono.Fiat, dono.MediaURL)
+}
+
+// saveDonoValue stores what a dono is worth and the quote it is valued at.
+// The dono checker and the seen checker each save them here when they
+// revalue a late dono, rather than along with everything else.
+func saveDonoValue(dono utils.Dono) error {
+	_, err := db.Exec("UPDATE donos SET usd_amount = ?, fiat_amount = ?, media_url = ?, quote_rate = ?, quoted_at = ?, quote_expires_at = ? WHERE dono_id = ?", dono.USDAmount, dono.FiatAmount, dono.MediaURL, dono.Rate, dono.QuotedAt, dono.QuoteExpiresAt, dono.ID)
+	return err
+}
+
+// reloadDonoValue reads back what a dono is worth, its quote and when it
+// was seen.
+func reloadDonoValue(dono *utils.Dono) error {
+	var usdAmount, fiatAmount, rate sql.NullFloat64
+	var mediaURL sql.NullString
+	var quotedAt, quoteExpiresAt, seenAt sql.NullTime
+	err := db.QueryRow("SELECT usd_amount, fiat_amount, media_url, quote_rate, quoted_at, quote_expires_at, seen_at FROM donos WHERE dono_id = ?", dono.ID).Scan(&usdAmount, &fiatAmount, &mediaURL, &rate, &quotedAt, &quoteExpiresAt, &seenAt)
+	if err != nil {
+		return err
+	}
+	dono.USDAmount = usdAmount.Float64
+	dono.FiatAmount = fiatAmount.Float64
+	dono.MediaURL = mediaURL.String
+	dono.Rate = rate.Float64
+	dono.QuotedAt = quotedAt.Time
+	dono.QuoteExpiresAt = quoteExpiresAt.Time
+	dono.SeenAt = seenAt.Time
+	return nil
+}
+
+// revalueLateDono values a dono paid after its quote expired at the current
+// rate rather than the quoted one, in USD and in the dono's fiat currency,
+// and drops its media if it no longer reaches the media threshold. The new
+// rate is kept as the dono's quote, quoted and expiring when the payment
+// arrived, so it is only revalued once.
+func revalueLateDono(dono *utils.Dono, amount string, paidAt time.Time) {
+	if dono.QuoteExpiresAt.IsZero() || !paidAt.After(dono.QuoteExpiresAt.Add(expiryGrace)) {
+		return
+	}
+	f, _ := strconv.ParseFloat(amount, 64)
+	rate, ok := priceOracle.Price(dono.CurrencyType)
+	if !ok {
+		rate = dono.Rate // no fresh price, the quoted rate is the best there is
+	}
+	usd := math.Round(f*rate*100) / 100
+	fiat, ok := usdToFiat(usd, dono.Fiat)
+	if !ok && dono.USDAmount > 0 {
+		// no fresh fiat rate, keep the one the dono was quoted at
+		fiat = math.Round(usd*dono.FiatAmount/dono.USDAmount*100) / 100
+	}
+	log.Println("Dono", dono.ID, "paid after its quote expired, revalued from", utils.FormatFiat(dono.FiatAmount, dono.Fiat), "to", utils.FormatFiat(fiat, dono.Fiat))
+
+	dono.USDAmount = usd
+	dono.FiatAmount = fiat
+	dono.Rate = rate
+	dono.QuotedAt = paidAt
+	dono.QuoteExpiresAt = paidAt
+	if valid, _ := checkDonoForMediaUSDThreshold(dono.MediaURL, usd); !valid {
+		dono.MediaURL = ""
+	}
+}
+
+// belowMinimum reports whether a dono revalued by revalueLateDono is worth
+// less than the streamer's minimum. Donos paid in time were quoted at the
+// minimum or more.
+func belowMinimum(dono utils.Dono) bool {
+	revalued := !dono.QuotedAt.IsZero() && dono.QuotedAt.Equal(dono.QuoteExpiresAt)
+	return revalued && dono.FiatAmount < float64(globalUsers[dono.UserID].MinDono)
+}
+
+// setDonoState moves a dono to another state and records the change and its
+// reason in dono_events. It fails if the dono can't go from its state to
+// that one, or if its state changed since it was read, so two checkers
+// can't both settle the same dono.
+func setDonoState(dono *utils.Dono, to utils.DonoState, reason string) error {
+	from := dono.State
+	if !from.CanBecome(to) {
+		return fmt.Errorf("dono %d can't go from %s to %s", dono.ID, from, to)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE donos SET state = ? WHERE dono_id = ? AND state = ?", to, dono.ID, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("dono %d is no longer %s", dono.ID, from)
+	}
+	if err := addDonoEvent(tx, int64(dono.ID), from, to, reason); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("Dono", dono.ID, from, "->", to, "-", reason)
+	dono.State = to
+	return nil
+}
+
+// addDonoEvent records a dono's change of state. from is empty for a dono
+// that was just created.
+func addDonoEvent(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, donoID int64, from utils.DonoState, to utils.DonoState, reason string) error {
+	fromState := sql.NullString{String: string(from), Valid: from != ""}
+	_, err := db.Exec("INSERT INTO dono_events (dono_id, from_state, to_state, reason, created_at) VALUES (?, ?, ?, ?, ?)", donoID, fromState, to, reason, time.Now().UTC())
+	return err
+}
+
+// donoStatesIn returns an SQL condition matching donos in any of the states,
+// and its arguments.
+func donoStatesIn(states []utils.DonoState) (string, []interface{}) {
+	args := make([]interface{}, len(states))
+	for i, state := range states {
+		args[i] = state
+	}
+	return "state IN (?" + strings.Repeat(", ?", len(states)-1) + ")", args
+}
+
+// skipDonoAlert marks a dono as alerted without queueing its alert, for a
+// late payment below the streamer's minimum once revalued.
+func skipDonoAlert(dono utils.Dono) {
+	log.Println("Dono", dono.ID, "is underpaid, not alerting")
+	if _, err := db.Exec("UPDATE donos SET alerted = true WHERE dono_id = ?", dono.ID); err != nil {
+		log.Println("Error skipping the alert of dono", dono.ID, err)
 	}
 }
 
@@ -1368,10 +1727,9 @@ func checkBillingAccounts() {
 
 			if user.BillingData.NeedToPay {
 
-				xmrFl, _ := getXMRBalance(user.BillingData.XMRPayID, 1)
-				xmrSent, _ := utils.StandardizeFloatToString(xmrFl)
-				xmrSentStr, _ := utils.StandardizeString(xmrSent)
-				if user.BillingData.XMRAmount == xmrSentStr {
+				xmrSent, err := xmrProvider.Balance(user.BillingData.XMRPayID, 1)
+				xmrNeeded, _ := utils.ParseAtomic(user.BillingData.XMRAmount, "XMR")
+				if err == nil && xmrNeeded != nil && xmrSent.Sign() > 0 && xmrSent.Cmp(xmrNeeded) == 0 {
 					renewUserSubscription(user)
 					continue
 				}
@@ -1379,19 +1737,26 @@ func checkBillingAccounts() {
 				adminETHAdd := getAdminETHAdd()
 
 				if !tMapGenerated { //Generate Map from transaction slice
-					for _, transaction := range eth_transactions {
-						hash := utils.GetTransactionAmount(transaction)
-						standard_hash, _ := utils.StandardizeString(hash)
-						transactionMap[standard_hash] = transaction
+					for _, transaction := range ethProvider.Transactions() {
+						if utils.GetTransactionToken(transaction) != "ETH" {
+							continue
+						}
+						value, ok := utils.GetTransactionAtomic(transaction)
+						if ok {
+							transactionMap[value.String()] = transaction
+						}
 					}
 					tMapGenerated = true
 				}
 
-				valueToCheck, _ := utils.StandardizeString(user.BillingData.ETHAmount)
-				transaction, ok := transactionMap[valueToCheck]
+				ethNeeded, err := utils.ParseAtomic(user.BillingData.ETHAmount, "ETH")
+				if err != nil {
+					continue
+				}
+				transaction, ok := transactionMap[ethNeeded.String()]
 				if ok {
 					tN := utils.GetTransactionToken(transaction)
-					if tN == "ETH" && transaction.To == adminETHAdd {
+					if tN == "ETH" && utils.CompareStringsLowercase(transaction.To, adminETHAdd) {
 						renewUserSubscription(user)
 						continue
 					}
@@ -1414,13 +1779,17 @@ func renewUserSubscription(user utils.User) {
 func checkPendingAccounts() {
 	for {
 
-		for _, transaction := range eth_transactions {
+		for _, transaction := range ethProvider.Transactions() {
 			tN := utils.GetTransactionToken(transaction)
-			if tN == "ETH" && transaction.To == getAdminETHAdd() {
-				valueStr := fmt.Sprintf("%.18f", transaction.Value)
+			if tN == "ETH" && utils.CompareStringsLowercase(transaction.To, getAdminETHAdd()) {
+				value, ok := utils.GetTransactionAtomic(transaction)
+				if !ok {
+					continue
+				}
 
 				for _, user := range pendingGlobalUsers {
-					if user.ETHNeeded == valueStr {
+					ethNeeded, err := utils.ParseAtomic(user.ETHNeeded, "ETH")
+					if err == nil && ethNeeded.Cmp(value) == 0 {
 						err := createNewUserFromPending(user)
 						if err != nil {
 							log.Println("Error marking payment as complete:", err)
@@ -1433,14 +1802,15 @@ func checkPendingAccounts() {
 		}
 
 		for _, user := range pendingGlobalUsers {
-			xmrFl, _ := getXMRBalance(user.XMRPayID, 1)
-			xmrSent, _ := utils.StandardizeFloatToString(xmrFl)
+			xmrSent, err := xmrProvider.Balance(user.XMRPayID, 1)
+			if err != nil || xmrSent.Sign() == 0 {
+				continue
+			}
 
-			log.Println("XMR sent:", xmrSent)
-			xmrSentStr, _ := utils.ConvertStringTo18DecimalPlaces(xmrSent)
-			log.Println("XMR sent str:", xmrSentStr)
+			log.Println("XMR sent:", utils.FormatAtomic(xmrSent, "XMR"))
 			log.Println("XMRNeeded str:", user.XMRNeeded)
-			if user.XMRNeeded == xmrSentStr {
+			xmrNeeded, err := utils.ParseAtomic(user.XMRNeeded, "XMR")
+			if err == nil && xmrNeeded.Cmp(xmrSent) == 0 {
 				err := createNewUserFromPending(user)
 				if err != nil {
 					log.Println("Error marking payment as complete:", err)
@@ -1472,8 +1842,12 @@ func checkAccountBillings() {
 						user.BillingData.NeedToPay = true
 						user.BillingData.AmountNeeded = math.Round(user.BillingData.AmountThisMonth*0.03*100) / 100
 
-						xmrNeeded := getXMRAmountInUSD(user.BillingData.AmountNeeded)
-						ethNeeded := getETHAmountInUSD(user.BillingData.AmountNeeded)
+						xmrNeeded, xmrOK := getXMRAmountInUSD(user.BillingData.AmountNeeded)
+						ethNeeded, ethOK := getETHAmountInUSD(user.BillingData.AmountNeeded)
+						if !xmrOK || !ethOK {
+							log.Println("No fresh XMR and ETH prices, billing user", user.UserID, "next time")
+							continue
+						}
 
 						PayID, PayAddress := getNewAccountXMR()
 						user.BillingData.XMRPayID = PayID
@@ -1495,41 +1869,14 @@ func checkAccountBillings() {
 	}
 }
 
-func getUSDValue(as float64, c string) float64 {
-	usdVal := 0.00
-
-	priceMap := map[string]float64{
-		"XMR":   prices.Monero,
-		"SOL":   prices.Solana,
-		"ETH":   prices.Ethereum,
-		"PAINT": prices.Paint,
-		"HEX":   prices.Hexcoin,
-		"MATIC": prices.Polygon,
-		"BUSD":  prices.BinanceUSD,
-		"SHIB":  prices.ShibaInu,
-		"PNK":   prices.Kleros,
-	}
-
-	if price, ok := priceMap[c]; ok {
-		usdVal = as * price
-	} else {
-		usdVal = 1.00
-		return usdVal
-	}
-	usdValStr := fmt.Sprintf("%.2f", usdVal)      // format usdVal as a string with 2 decimal points
-	usdVal, _ = strconv.ParseFloat(usdValStr, 64) // convert the string back to a float
-
-	return usdVal
-}
-
-func addDonoToDonoBar(as, c string, userID int) {
-	f, err := strconv.ParseFloat(as, 64)
-	usdVal := getUSDValue(f, c)
+// addDonoToDonoBar adds a dono's value in the user's fiat currency, at the
+// rate it was quoted or revalued at, to the user's progress bar.
+func addDonoToDonoBar(fiatVal float64, userID int) {
 	obsData, err := getOBSDataByUserID(userID)
 	pb.Sent = obsData.Sent
 	pb.Needed = obsData.Needed
 	pb.Message = obsData.Message
-	pb.Sent += usdVal
+	pb.Sent += fiatVal
 
 	sent, err := strconv.ParseFloat(fmt.Sprintf("%.2f", pb.Sent), 64)
 	if err != nil {
@@ -1559,7 +1906,7 @@ func formatMediaURL(media_url string) string {
 	return embedLink
 }
 
-func createNewQueueEntry(db *sql.DB, user_id int, address string, name string, message string, amount string, currency string, dono_usd float64, media_url string) error {
+func createNewQueueEntry(db *sql.DB, user_id int, address string, name string, message string, amount string, currency string, dono_usd float64, dono_fiat float64, fiat string, media_url string) error {
 	f, err := strconv.ParseFloat(amount, 64)
 	if err != nil {
 		panic(err)
@@ -1572,8 +1919,8 @@ func createNewQueueEntry(db *sql.DB, user_id int, address string, name string, m
 	embedLink := formatMediaURL(media_url)
 
 	_, err = db.Exec(`
-		INSERT INTO queue (name, message, amount, currency, usd_amount, media_url, user_id) VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, name, message, amount, currency, dono_usd, embedLink, user_id)
+		INSERT INTO queue (name, message, amount, currency, usd_amount, fiat_amount, fiat_currency, media_url, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, name, message, amount, currency, dono_usd, dono_fiat, fiat, embedLink, user_id)
 	if err != nil {
 		return err
 	}
@@ -1616,7 +1963,7 @@ func checkDonoForMediaUSDThreshold(media_url string, dono_usd float64) (bool, st
 	return valid, media_url
 }
 
-func createNewDono(user_id int, dono_address string, dono_name string, dono_message string, amount_to_send string, currencyType string, encrypted_ip string, anon_dono bool, dono_usd float64, media_url string) int64 {
+func createNewDono(user_id int, dono_address string, payment_reference string, expires_at time.Time, dono_name string, dono_message string, atomic_to_send *big.Int, currencyType string, encrypted_ip string, anon_dono bool, dono_usd float64, dono_fiat float64, fiat string, quote_rate float64, quote_expires_at time.Time, media_url string) int64 {
 	// Open a new database connection
 	db, err := sql.Open("sqlite3", "users.db")
 	if err != nil {
@@ -1633,7 +1980,17 @@ func createNewDono(user_id int, dono_address string, dono_name string, dono_mess
 		media_url_ = ""
 	}
 
-	amount_to_send, _ = utils.StandardizeString(amount_to_send)
+	amount_to_send := utils.FormatAtomic(atomic_to_send, currencyType)
+
+	var chainID sql.NullInt64
+	if c, ok := utils.GetCurrency(currencyType); ok && c.ChainID != 0 {
+		chainID = sql.NullInt64{Int64: int64(c.ChainID), Valid: true}
+	}
+
+	var expiresAt sql.NullTime
+	if !expires_at.IsZero() {
+		expiresAt = sql.NullTime{Time: expires_at.UTC(), Valid: true}
+	}
 
 	// Execute the SQL INSERT statement
 	result, err := db.Exec(`
@@ -1646,14 +2003,24 @@ func createNewDono(user_id int, dono_address string, dono_name string, dono_mess
             amount_sent,
             currency_type,
             anon_dono,
-            fulfilled,
+            state,
             encrypted_ip,
             created_at,
             updated_at,
             usd_amount,
-            media_url
-        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-    `, user_id, dono_address, dono_name, dono_message, amount_to_send, "0.0", currencyType, anon_dono, false, encrypted_ip, createdAt, createdAt, dono_usd, media_url_)
+            media_url,
+            atomic_to_send,
+            atomic_sent,
+            payment_reference,
+            expires_at,
+            chain_id,
+            quote_rate,
+            quoted_at,
+            quote_expires_at,
+            fiat_amount,
+            fiat_currency
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, user_id, dono_address, dono_name, dono_message, amount_to_send, "0.0", currencyType, anon_dono, utils.DonoCreated, encrypted_ip, createdAt, createdAt, dono_usd, media_url_, atomic_to_send.String(), "0", payment_reference, expiresAt, chainID, quote_rate, createdAt, quote_expires_at.UTC(), dono_fiat, fiat)
 	if err != nil {
 		log.Println(err)
 		panic(err)
@@ -1666,6 +2033,11 @@ func createNewDono(user_id int, dono_address string, dono_name string, dono_mess
 		panic(err)
 	}
 
+	err = addDonoEvent(db, id, "", utils.DonoCreated, "dono created")
+	if err != nil {
+		log.Println("Error recording the creation of dono", id, err)
+	}
+
 	return id
 }
 
@@ -1687,19 +2059,20 @@ func getUnfulfilledDonoIPs() ([]string, error) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query(`SELECT ip FROM donos WHERE fulfilled = false`)
+	pending, args := donoStatesIn(utils.PendingDonoStates)
+	rows, err := db.Query("SELECT encrypted_ip FROM donos WHERE "+pending, args...)
 	if err != nil {
 		return ips, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var ip string
+		var ip sql.NullString
 		err := rows.Scan(&ip)
 		if err != nil {
 			return ips, err
 		}
-		ips = append(ips, ip)
+		ips = append(ips, ip.String)
 	}
 
 	err = rows.Err()
@@ -1719,50 +2092,88 @@ func updatePendingDonos() {
 	}
 	defer db.Close()
 
-	// Retrieve all unfulfilled donos from the database
-	rows, err := db.Query(`SELECT * FROM donos WHERE fulfilled = false`)
+	// Retrieve all pending donos from the database
+	pending, args := donoStatesIn(utils.PendingDonoStates)
+	rows, err := db.Query("SELECT "+donoColumns+" FROM donos WHERE "+pending, args...)
 	if err != nil {
 		panic(err)
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var dono utils.Dono
-		var tmpAmountToSend sql.NullString
-		var tmpAmountSent sql.NullString
-		var tmpUSDAmount sql.NullFloat64
-		var tmpMediaURL sql.NullString
-
-		err := rows.Scan(&dono.ID, &dono.UserID, &dono.Address, &dono.Name, &dono.Message, &tmpAmountToSend, &tmpAmountSent, &dono.CurrencyType, &dono.AnonDono, &dono.Fulfilled, &dono.EncryptedIP, &dono.CreatedAt, &dono.UpdatedAt, &tmpUSDAmount, &tmpMediaURL)
+		dono, err := scanDono(rows)
 		if err != nil {
 			panic(err)
 		}
 
-		if tmpUSDAmount.Valid {
-			dono.USDAmount = tmpUSDAmount.Float64
-		} else {
-			dono.USDAmount = 0.0
-		}
+		addToDonosMap(dono)
+	}
+}
 
-		if tmpAmountToSend.Valid {
-			dono.AmountToSend = tmpAmountToSend.String
-		} else {
-			dono.AmountToSend = "0.0"
-		}
+// donoColumns lists the donos columns in the order scanDono reads them.
+const donoColumns = "dono_id, user_id, dono_address, dono_name, dono_message, amount_to_send, amount_sent, currency_type, anon_dono, state, encrypted_ip, created_at, updated_at, usd_amount, media_url, atomic_to_send, atomic_sent, seen, block_number, block_hash, tx_hash, log_index, payment_reference, expires_at, chain_id, quote_rate, quoted_at, quote_expires_at, seen_at, fiat_amount, fiat_currency"
 
-		if tmpAmountSent.Valid {
-			dono.AmountSent = tmpAmountSent.String
-		} else {
-			dono.AmountSent = "0.0"
-		}
+// scanDono reads one row selected with donoColumns. Donos created before
+// atomic amounts were stored get them parsed from the display amounts.
+func scanDono(rows *sql.Rows) (utils.Dono, error) {
+	var dono utils.Dono
+	var name, message, address, currencyType, encryptedIP, amountToSend, amountSent, mediaURL, atomicToSend, atomicSent, blockHash, txHash, reference, fiat, state sql.NullString
+	var usdAmount, rate, fiatAmount sql.NullFloat64
+	var userID, blockNumber, logIndex, chainID sql.NullInt64
+	var anonDono, seen sql.NullBool
+	var expiresAt, quotedAt, quoteExpiresAt, seenAt sql.NullTime
+	err := rows.Scan(&dono.ID, &userID, &address, &name, &message, &amountToSend, &amountSent, &currencyType, &anonDono, &state, &encryptedIP, &dono.CreatedAt, &dono.UpdatedAt, &usdAmount, &mediaURL, &atomicToSend, &atomicSent, &seen, &blockNumber, &blockHash, &txHash, &logIndex, &reference, &expiresAt, &chainID, &rate, &quotedAt, &quoteExpiresAt, &seenAt, &fiatAmount, &fiat)
+	if err != nil {
+		return dono, err
+	}
 
-		if tmpMediaURL.Valid {
-			dono.MediaURL = tmpMediaURL.String
-		} else {
-			dono.MediaURL = ""
-		}
+	dono.UserID = int(userID.Int64)
+	dono.Address = address.String
+	dono.Name = name.String
+	dono.Message = message.String
+	dono.AmountToSend = amountToSend.String
+	dono.AmountSent = amountSent.String
+	dono.CurrencyType = currencyType.String
+	dono.AnonDono = anonDono.Bool
+	dono.State = utils.DonoState(state.String)
+	dono.EncryptedIP = encryptedIP.String
+	dono.USDAmount = usdAmount.Float64
+	dono.MediaURL = mediaURL.String
+	dono.Seen = seen.Bool
+	dono.BlockNumber = uint64(blockNumber.Int64)
+	dono.BlockHash = blockHash.String
+	dono.TxHash = txHash.String
+	dono.LogIndex = int(logIndex.Int64)
+	dono.Reference = reference.String
+	dono.ExpiresAt = expiresAt.Time
+	dono.ChainID = uint64(chainID.Int64)
+	dono.Rate = rate.Float64
+	dono.QuotedAt = quotedAt.Time
+	dono.QuoteExpiresAt = quoteExpiresAt.Time
+	dono.SeenAt = seenAt.Time
+	dono.FiatAmount = fiatAmount.Float64
+	dono.Fiat = fiat.String
 
-		addToDonosMap(dono)
+	if dono.AmountToSend == "" {
+		dono.AmountToSend = "0.0"
 	}
+	if dono.AmountSent == "" {
+		dono.AmountSent = "0.0"
+	}
+
+	dono.AtomicToSend = parseDonoAtomic(atomicToSend.String, dono.AmountToSend, dono.CurrencyType)
+	dono.AtomicSent = parseDonoAtomic(atomicSent.String, dono.AmountSent, dono.CurrencyType)
+	return dono, nil
+}
+
+func parseDonoAtomic(atomic string, amount string, currency string) *big.Int {
+	if a, ok := utils.ParseAtomicString(atomic); ok {
+		return a
+	}
+	a, err := utils.ParseAtomic(amount, currency)
+	if err != nil {
+		return new(big.Int)
+	}
+	return a
 }
 
 func addToDonosMap(dono utils.Dono) {
@@ -1784,7 +2195,7 @@ func updateDonoInMap(updatedDono utils.Dono) {
 func printDonoInfo(dono utils.Dono, secondsElapsedSinceLastCheck, secondsNeededToCheck float64) {
 	log.Println("Dono ID:", dono.ID, "Address:", dono.Address, "Name:", dono.Name, "To User:", dono.UserID)
 	log.Println("Message:", dono.Message)
-	fmt.Println(dono.CurrencyType, "Needed:", dono.AmountToSend, "Recieved:", dono.AmountSent)
+	fmt.Println(dono.CurrencyType, "Needed:", dono.AmountToSend, "("+dono.AtomicToSend.String()+")", "Recieved:", dono.AmountSent)
 
 	log.Println("Time since check:", fmt.Sprintf("%.2f", secondsElapsedSinceLastCheck), "Needed:", fmt.Sprintf("%.2f", secondsNeededToCheck))
 
@@ -1812,147 +2223,213 @@ func checkUnfulfilledDonos() []utils.Dono {
 
 	var fulfilledDonos []utils.Dono
 
-	eth_addresses := returnETHAddresses()
-	for _, eth_address := range eth_addresses {
-		log.Println("Getting ETH txs for:", eth_address)
-	}
-
-	tempMap := make(map[string]bool)
-	for _, eth_address := range eth_addresses {
-		log.Println("Getting ETH txs for:", eth_address)
-		transactions, newTX, _ := utils.GetEth(eth_address)
-		if newTX {
-			for _, tx := range transactions {
-				if _, exists := tempMap[tx.Hash]; !exists {
-					eth_transactions = append(eth_transactions, tx)
-					tempMap[tx.Hash] = true
-				}
-			}
-			time.Sleep(2 * time.Second)
-		}
-
+	pendingDonos := make([]utils.Dono, 0, len(donosMap))
+	for _, dono := range donosMap {
+		pendingDonos = append(pendingDonos, dono)
 	}
+	payments.PollAll(pendingDonos)
 
 	for _, dono := range donosMap {
-		// Check if the dono has exceeded the killDono time
-		if !dono.Fulfilled {
-			timeElapsedFromDonoCreation := time.Since(dono.CreatedAt)
-			if timeElapsedFromDonoCreation > killDono || dono.Address == " " || dono.AmountToSend == "0.0" {
-				dono.Fulfilled = true
-				dono.EncryptedIP = ""
-				if dono.Address == " " {
-					log.Println("No dono address, killed (marked as fulfilled) and won't be checked again. \n")
-				} else {
-					log.Println("Dono too old, killed (marked as fulfilled) and won't be checked again. \n")
+		if dono.State.Pending() {
+			reason := ""
+			switch {
+			case dono.Address == " ":
+				reason = "no address to pay to"
+			case dono.AtomicToSend.Sign() == 0:
+				reason = "nothing to pay"
+			}
+			if reason != "" {
+				if err := setDonoState(&dono, utils.DonoCancelled, reason); err != nil {
+					log.Println("Error ending dono", dono.ID, err)
 				}
+				dono.EncryptedIP = ""
 				updateDonoInMap(dono)
 				continue
 			}
 		}
 
-		if dono.CurrencyType != "XMR" && dono.CurrencyType != "SOL" {
-			// Check if amount matches a completed dono amount
-			for _, transaction := range eth_transactions {
-				tN := utils.GetTransactionToken(transaction)
-				if tN == dono.CurrencyType {
-					valueStr := fmt.Sprintf("%.18f", transaction.Value)
-					valueToCheck, _ := utils.StandardizeString(dono.AmountToSend)
-					log.Println("TX checked:", tN)
-					log.Println("Needed:", valueToCheck)
-					log.Println("Got   :", valueStr)
-					if valueStr == valueToCheck {
-						log.Println("Matching TX!")
-						dono.AmountSent = valueStr
-						addDonoToDonoBar(dono.AmountSent, dono.CurrencyType, dono.UserID) // change Amount To Send to USD value of sent
-						dono.Fulfilled = true
-						dono.EncryptedIP = ""
-						dono.UpdatedAt = time.Now().UTC()
-						fulfilledDonos = append(fulfilledDonos, dono)
-						updateDonoInMap(dono)
-						break
-					}
-				}
-			}
+		// An expired dono is checked once more without waiting out its
+		// backoff, so a payment that came in late still settles it instead
+		// of it being expired unseen
+		deadline, expiry := donoDeadline(dono)
+		if time.Now().Before(deadline) {
+			expiry = ""
+		}
+		checkedSinceExpiry := dono.UpdatedAt.After(deadline)
 
-			valueToCheck, _ := utils.ConvertStringTo18DecimalPlaces(dono.AmountToSend)
-			dono.UpdatedAt = time.Now().UTC()
-			fmt.Println(valueToCheck, dono.CurrencyType, "Dono incomplete.")
-			updateDonoInMap(dono)
+		provider, ok := payments.ForCurrency(dono.CurrencyType)
+		if !ok {
+			log.Println("No payment provider for", dono.CurrencyType, "dono", dono.ID)
+			if expiry != "" {
+				expireDono(&dono, expiry)
+				updateDonoInMap(dono)
+			}
 			continue
 		}
 
-		// Check if the dono needs to be skipped based on exponential backoff
+		// Check if the dono needs to be skipped based on exponential backoff,
+		// unless the provider is told about its payments as they happen
 		secondsElapsedSinceLastCheck := time.Since(dono.UpdatedAt).Seconds()
 		dono.UpdatedAt = time.Now().UTC()
 
 		expoAdder := returnIPPenalty(ips, dono.EncryptedIP) + time.Since(dono.CreatedAt).Seconds()/60/60/19
 		secondsNeededToCheck := math.Pow(float64(baseCheckingRate)-0.02, expoAdder)
+		if subscriber, ok := provider.(payments.Subscriber); ok && subscriber.Subscribed(dono.UserID) {
+			secondsNeededToCheck = 0
+		}
 
-		if secondsElapsedSinceLastCheck < secondsNeededToCheck {
-			log.Println("Not enough time has passed, skipping. \n")
+		if (expiry == "" || checkedSinceExpiry) && secondsElapsedSinceLastCheck < secondsNeededToCheck {
+			log.Println("Not enough time has passed, skipping.")
 			continue // If not enough time has passed then ignore
 		}
 
 		log.Println("Enough time has passed, checking.")
+		printDonoInfo(dono, secondsElapsedSinceLastCheck, secondsNeededToCheck)
 
-		if dono.CurrencyType == "XMR" {
-			xmrFl, _ := getXMRBalance(dono.Address, dono.UserID)
-			xmrSent, _ := utils.StandardizeFloatToString(xmrFl)
-			dono.AmountSent = xmrSent
-			xmrNeededStr, _ := utils.StandardizeString(dono.AmountToSend)
-			printDonoInfo(dono, secondsElapsedSinceLastCheck, secondsNeededToCheck)
-			if dono.AmountSent == xmrNeededStr {
-				dono.AmountSent, _ = utils.PruneStringByDecimalPoints(dono.AmountToSend, 5)
-				addDonoToDonoBar(dono.AmountSent, dono.CurrencyType, dono.UserID)
-				dono.Fulfilled = true
-				dono.EncryptedIP = ""
-				fulfilledDonos = append(fulfilledDonos, dono)
-				updateDonoInMap(dono)
-				continue
+		match, err := provider.Match(dono)
+		if err != nil {
+			log.Println(provider.Name(), "match error:", err)
+		} else {
+			recordDonoMatch(&dono, &match)
+		}
+
+		// a payment still confirming, or a provider that can't tell, keeps
+		// the dono alive up to killSeenDono
+		lastChance := time.Since(dono.CreatedAt) > killSeenDono
+		if expiry != "" && !match.Found && (lastChance || err == nil && !match.Seen) {
+			if match.Partial {
+				underpayDono(&dono, match)
+			} else {
+				expireDono(&dono, expiry)
 			}
-			updateDonoInMap(dono)
-		} else if dono.CurrencyType == "SOL" {
-			log.Println("SOLANA DONO AMOUNT NEEDED:", dono.AmountToSend)
-			if utils.CheckTransactionSolana(dono.AmountToSend, dono.Address, 100) {
-				dono.AmountSent, _ = utils.PruneStringByDecimalPoints(dono.AmountToSend, 5)
-				addDonoToDonoBar(dono.AmountSent, dono.CurrencyType, dono.UserID) // change Amount To Send to USD value of sent
-				dono.Fulfilled = true
-				dono.EncryptedIP = ""
+		}
+
+		if match.Found {
+			dono.AtomicSent = match.AmountSent
+			dono.AmountSent = utils.FormatAtomic(dono.AtomicSent, dono.CurrencyType)
+			// the dono may have been seen, and revalued, since it was loaded
+			if err := reloadDonoValue(&dono); err != nil {
+				log.Println("Error reloading the value of dono", dono.ID, err)
+			}
+			paidAt := dono.SeenAt
+			if paidAt.IsZero() {
+				paidAt = time.Now().UTC()
+			}
+			revalueLateDono(&dono, dono.AmountSent, paidAt)
+			if err := saveDonoValue(dono); err != nil {
+				log.Println("Error saving the value of dono", dono.ID, err)
+			}
+			to, reason := utils.DonoConfirmed, "payment of "+dono.AmountSent+" "+dono.CurrencyType+" confirmed"
+			if belowMinimum(dono) {
+				to, reason = utils.DonoUnderpaid, "paid after the quote expired, worth "+dono.FiatValue()+" when it arrived"
+			}
+			if err := setDonoState(&dono, to, reason); err != nil {
+				log.Println("Error settling dono", dono.ID, err)
+			} else {
+				if to == utils.DonoUnderpaid {
+					skipDonoAlert(dono)
+				}
+				addDonoToDonoBar(dono.FiatAmount, dono.UserID)
 				fulfilledDonos = append(fulfilledDonos, dono)
-				updateDonoInMap(dono)
-				continue
 			}
+			dono.EncryptedIP = ""
 		}
+		updateDonoInMap(dono)
 	}
 	updateDonosInDB()
 	removeFulfilledDonos(fulfilledDonos)
 	return fulfilledDonos
 }
 
-func removeFulfilledDonos(donos []utils.Dono) {
-	for _, dono := range donos {
-		if _, ok := donosMap[dono.ID]; ok {
-			delete(donosMap, dono.ID)
-		}
+// donoDeadline returns when a pending dono expires if it isn't paid, and
+// the reason it is given then.
+func donoDeadline(dono utils.Dono) (time.Time, string) {
+	if !dono.ExpiresAt.IsZero() {
+		return dono.ExpiresAt.Add(expiryGrace), "payment request expired at " + dono.ExpiresAt.Format(time.RFC3339)
+	}
+	maxAge := killDono
+	if dono.State == utils.DonoSeen {
+		maxAge = killSeenDono
 	}
+	return dono.CreatedAt.Add(maxAge), "not paid within " + maxAge.String()
 }
 
-func returnETHAddresses() []string {
-	// Use a map to keep track of which addresses have already been added
-	addressMap := make(map[string]bool)
-	addresses := []string{}
+// expireDono gives up on a dono that wasn't paid in time.
+func expireDono(dono *utils.Dono, reason string) {
+	if err := setDonoState(dono, utils.DonoExpired, reason); err != nil {
+		log.Println("Error ending dono", dono.ID, err)
+	}
+	dono.EncryptedIP = ""
+}
 
-	for _, dono := range donosMap {
-		if dono.CurrencyType != "XMR" && dono.CurrencyType != "SOL" {
-			// Check if the address has already been added, and if not, add it to the slice and map
-			if _, ok := addressMap[dono.Address]; !ok {
-				addressMap[dono.Address] = true
-				addresses = append(addresses, dono.Address)
-			}
+// underpayDono ends a dono that was paid less than asked by the time it
+// expired. What came in is kept for the streamer to refund, and like a late
+// dono below the minimum it isn't alerted.
+func underpayDono(dono *utils.Dono, match payments.Match) {
+	amount := utils.FormatAtomic(match.AmountSent, dono.CurrencyType)
+	reason := "paid " + amount + " of " + dono.AmountToSend + " " + dono.CurrencyType + " before it expired"
+	if err := setDonoState(dono, utils.DonoUnderpaid, reason); err != nil {
+		log.Println("Error ending dono", dono.ID, err)
+		return
+	}
+	dono.AtomicSent = match.AmountSent
+	dono.AmountSent = amount
+	dono.EncryptedIP = ""
+	skipDonoAlert(*dono)
+}
+
+// recordDonoMatch keeps the transaction and block a pending dono was matched
+// to. A transaction that already paid another dono is no match at all, and
+// a dono whose block was reorged out goes back to pending.
+func recordDonoMatch(dono *utils.Dono, match *payments.Match) {
+	if match.TxHash != "" {
+		if err := claimDonoTx(dono, match.TxHash, match.LogIndex); err != nil {
+			log.Println("Dono", dono.ID, "matched", match.TxHash, "which paid another dono:", err)
+			*match = payments.Match{}
 		}
 	}
 
-	return addresses
+	if match.BlockHash != "" {
+		dono.BlockNumber = match.BlockNumber
+		dono.BlockHash = match.BlockHash
+	} else if dono.BlockHash != "" {
+		// the block the payment was in has been reorged out
+		log.Println("Dono", dono.ID, "payment in block", dono.BlockNumber, dono.BlockHash, "is gone, back to pending")
+		dono.BlockNumber = 0
+		dono.BlockHash = ""
+		if err := claimDonoTx(dono, "", 0); err != nil {
+			log.Println("Error releasing the transaction of dono", dono.ID, err)
+		}
+	}
+}
+
+// claimDonoTx stores the transaction that paid a dono. The unique index on
+// tx_hash and log_index makes this fail if the transfer already paid
+// another dono. An empty hash releases the dono's transaction.
+func claimDonoTx(dono *utils.Dono, txHash string, logIndex int) error {
+	if txHash == dono.TxHash && logIndex == dono.LogIndex {
+		return nil
+	}
+	var err error
+	if txHash == "" {
+		_, err = db.Exec("UPDATE donos SET tx_hash = NULL, log_index = NULL WHERE dono_id = ?", dono.ID)
+	} else {
+		_, err = db.Exec("UPDATE donos SET tx_hash = ?, log_index = ? WHERE dono_id = ?", txHash, logIndex, dono.ID)
+	}
+	if err != nil {
+		return err
+	}
+	dono.TxHash = txHash
+	dono.LogIndex = logIndex
+	return nil
+}
+
+func removeFulfilledDonos(donos []utils.Dono) {
+	for _, dono := range donos {
+		if _, ok := donosMap[dono.ID]; ok {
+			delete(donosMap, dono.ID)
+		}
+	}
 }
 
 func updateDonosInDB() {
@@ -1965,10 +2442,12 @@ func updateDonosInDB() {
 
 	// Loop through the donosMap and update the database with any changes
 	for _, dono := range donosMap {
-		if dono.Fulfilled && dono.AmountSent != "0.0" {
+		if dono.State.Received() {
 			log.Println("DONO COMPLETED: ", dono.AmountSent, dono.CurrencyType)
 		}
-		_, err = db.Exec("UPDATE donos SET user_id=?, dono_address=?, dono_name=?, dono_message=?, amount_to_send=?, amount_sent=?, currency_type=?, anon_dono=?, fulfilled=?, encrypted_ip=?, created_at=?, updated_at=?, usd_amount=?, media_url=? WHERE dono_id=?", dono.UserID, dono.Address, dono.Name, dono.Message, dono.AmountToSend, dono.AmountSent, dono.CurrencyType, dono.AnonDono, dono.Fulfilled, dono.EncryptedIP, dono.CreatedAt, dono.UpdatedAt, dono.USDAmount, dono.MediaURL, dono.ID)
+		// only the columns the checker owns; the value and quote are saved
+		// by saveDonoValue, as the seen checker revalues them as well
+		_, err = db.Exec("UPDATE donos SET amount_sent=?, atomic_sent=?, encrypted_ip=?, updated_at=?, block_number=?, block_hash=? WHERE dono_id=?", dono.AmountSent, dono.AtomicSent.String(), dono.EncryptedIP, dono.UpdatedAt, dono.BlockNumber, dono.BlockHash, dono.ID)
 		if err != nil {
 			log.Printf("Error updating Dono with ID %d in the database: %v\n", dono.ID, err)
 		} else {
@@ -1977,138 +2456,181 @@ func updateDonosInDB() {
 	}
 }
 
-func getXMRBalance(checkID string, userID int) (float64, error) {
-
-	portID := getPortID(xmrWallets, userID)
+func runDatabaseMigrations(db *sql.DB) error {
+	tables := []string{"queue", "donos"}
+	for _, table := range tables {
+		err := addColumnIfNotExist(db, table, "usd_amount", "FLOAT")
+		if err != nil {
+			return err
+		}
 
-	found := true
-	if portID == -100 {
-		found = false
+		err = addColumnIfNotExist(db, table, "media_url", "TEXT")
+		if err != nil {
+			return err
+		}
 	}
-
-	if found {
-		fmt.Println("Port ID for user", userID, "is", portID)
-	} else {
-		fmt.Println("Port ID not found for user", userID)
+	// amounts in atomic units (piconero, lamports, wei, ...) as base 10 strings
+	for _, column := range []string{"atomic_to_send", "atomic_sent"} {
+		err := addColumnIfNotExist(db, "donos", column, "TEXT")
+		if err != nil {
+			return err
+		}
 	}
-
-	url := "http://localhost:" + strconv.Itoa(portID) + "/json_rpc"
-
-	payload := struct {
-		Jsonrpc string `json:"jsonrpc"`
-		Id      int    `json:"id"`
-		Method  string `json:"method"`
-		Params  struct {
-			PaymentID string `json:"payment_id"`
-		} `json:"params"`
-	}{
-		Jsonrpc: "2.0",
-		Id:      0,
-		Method:  "get_payments",
-		Params: struct {
-			PaymentID string `json:"payment_id"`
-		}{
-			PaymentID: checkID,
-		},
+	// seen: the payment showed up, maybe unconfirmed. alerted: its alert is queued.
+	for _, column := range []string{"seen", "alerted"} {
+		err := addColumnIfNotExist(db, "donos", column, "BOOLEAN")
+		if err != nil {
+			return err
+		}
 	}
-
-	reqBody, err := json.Marshal(payload)
-	if err != nil {
-		return 0.0, err
+	// block the payment was matched in, on chains that can reorg
+	for column, columnType := range map[string]string{"block_number": "INTEGER", "block_hash": "TEXT"} {
+		err := addColumnIfNotExist(db, "donos", column, columnType)
+		if err != nil {
+			return err
+		}
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return 0.0, err
+	// transaction that paid the dono; a transfer can only ever pay one dono
+	for column, columnType := range map[string]string{"tx_hash": "TEXT", "log_index": "INTEGER"} {
+		err := addColumnIfNotExist(db, "donos", column, columnType)
+		if err != nil {
+			return err
+		}
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0.0, err
+	// Solana Pay reference key of the dono, the payment is found by it
+	if err := addColumnIfNotExist(db, "donos", "payment_reference", "TEXT"); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return 0.0, err
+	// when the dono's payment request, such as a Lightning invoice, expires
+	if err := addColumnIfNotExist(db, "donos", "expires_at", "DATETIME"); err != nil {
+		return err
 	}
-
-	fmt.Println(result)
-
-	resultMap, ok := result["result"].(map[string]interface{})
-	if !ok {
-		return 0.0, fmt.Errorf("result key not found in response")
+	// EVM chain the dono is paid on; donos from before are on Ethereum
+	if err := addColumnIfNotExist(db, "donos", "chain_id", "INTEGER"); err != nil {
+		return err
 	}
-
-	payments, ok := resultMap["payments"].([]interface{})
-	if !ok {
-		return 0.0, fmt.Errorf("payments key not found in result map")
+	// the dono's value in the streamer's fiat currency; donos and alerts
+	// from before are in USD
+	for _, table := range []string{"donos", "queue"} {
+		if err := addColumnIfNotExist(db, table, "fiat_amount", "FLOAT"); err != nil {
+			return err
+		}
+		if err := addColumnIfNotExist(db, table, "fiat_currency", "TEXT"); err != nil {
+			return err
+		}
+		if _, err := db.Exec("UPDATE "+table+" SET fiat_amount = usd_amount, fiat_currency = ? WHERE fiat_currency IS NULL", utils.BaseFiat); err != nil {
+			return err
+		}
 	}
-
-	if len(payments) == 0 {
-		return 0.0, fmt.Errorf("no payments found for payment ID %s", checkID)
+	// the USD rate the dono was quoted at, when, and until when it holds
+	donoQuoteColumns := map[string]string{"quote_rate": "FLOAT", "quoted_at": "DATETIME", "quote_expires_at": "DATETIME", "seen_at": "DATETIME"}
+	for column, columnType := range donoQuoteColumns {
+		if err := addColumnIfNotExist(db, "donos", column, columnType); err != nil {
+			return err
+		}
+	}
+	ethCodes := utils.CurrencyCodesForChain(utils.ChainEthereum)
+	args := []interface{}{eth.MainnetID}
+	for _, code := range ethCodes {
+		args = append(args, code)
+	}
+	if _, err := db.Exec("UPDATE donos SET chain_id = ? WHERE chain_id IS NULL AND currency_type IN (?"+strings.Repeat(", ?", len(ethCodes)-1)+")", args...); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS donos_tx ON donos(tx_hash, log_index) WHERE tx_hash IS NOT NULL"); err != nil {
+		return err
+	}
+	if err := migrateDonoStates(db); err != nil {
+		return err
 	}
+	tables = []string{"users"}
+	for _, table := range tables {
+		err := addColumnIfNotExist(db, table, "links", "TEXT")
+		if err != nil {
+			return err
+		}
 
-	amount := payments[0].(map[string]interface{})["amount"].(float64)
+		err = addColumnIfNotExist(db, table, "dono_gif", "TEXT")
+		if err != nil {
+			return err
+		}
 
-	return amount / math.Pow(10, 12), nil
-}
+		err = addColumnIfNotExist(db, table, "default_crypto", "TEXT")
+		if err != nil {
+			return err
+		}
 
-func runDatabaseMigrations(db *sql.DB) error {
-	tables := []string{"queue", "donos"}
-	for _, table := range tables {
-		err := addColumnIfNotExist(db, table, "usd_amount", "FLOAT")
+		err = addColumnIfNotExist(db, table, "dono_sound", "TEXT")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, table, "media_url", "TEXT")
+		err = addColumnIfNotExist(db, table, "alert_url", "TEXT")
 		if err != nil {
 			return err
 		}
-	}
-	tables = []string{"users"}
-	for _, table := range tables {
-		err := addColumnIfNotExist(db, table, "links", "TEXT")
+
+		err = removeColumnIfExist(db, table, "progressbar_url")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, table, "dono_gif", "TEXT")
+		err = addColumnIfNotExist(db, "users", "date_enabled", "DATETIME")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, table, "default_crypto", "TEXT")
+		err = addColumnIfNotExist(db, "users", "wallet_uploaded", "BOOLEAN")
+		if err != nil {
+			return err
+		}
+
+		err = addColumnIfNotExist(db, "users", "xmr_address_mode", "TEXT")
+		if err != nil {
+			return err
+		}
+
+		err = addColumnIfNotExist(db, "users", "xmr_confirmations", "INTEGER")
+		if err != nil {
+			return err
+		}
+
+		err = addColumnIfNotExist(db, "users", "alert_on", "TEXT")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, table, "dono_sound", "TEXT")
+		err = addColumnIfNotExist(db, "users", "btc_xpub", "TEXT")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, table, "alert_url", "TEXT")
+		err = addColumnIfNotExist(db, "users", "btc_gap_limit", "INTEGER")
 		if err != nil {
 			return err
 		}
 
-		err = removeColumnIfExist(db, table, "progressbar_url")
+		// the streamer's Lightning node; the credential is sealed
+		for _, column := range []string{"ln_kind", "ln_url", "ln_credential", "ln_cert"} {
+			err = addColumnIfNotExist(db, "users", column, "TEXT")
+			if err != nil {
+				return err
+			}
+		}
+
+		// JSON object of chain ID -> address on the EVM chains the user accepts
+		err = addColumnIfNotExist(db, "users", "evm_addresses", "TEXT")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, "users", "date_enabled", "DATETIME")
+		err = addColumnIfNotExist(db, "users", "tron_address", "TEXT")
 		if err != nil {
 			return err
 		}
 
-		err = addColumnIfNotExist(db, "users", "wallet_uploaded", "BOOLEAN")
+		// fiat currency minimums and goals are set in, USD if empty
+		err = addColumnIfNotExist(db, "users", "fiat_currency", "TEXT")
 		if err != nil {
 			return err
 		}
@@ -2171,6 +2693,49 @@ func updateColumnAlertURLIfNull(db *sql.DB, tableName, columnName string) error
 	return nil
 }
 
+// migrateDonoStates replaces the fulfilled flag of donos from before they
+// had states. Paid donos are confirmed, ones with no address are
+// cancelled and the other fulfilled ones expired; each gets an event saying
+// where its state came from.
+func migrateDonoStates(db *sql.DB) error {
+	if err := addColumnIfNotExist(db, "donos", "state", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS dono_events_dono ON dono_events(dono_id)"); err != nil {
+		return err
+	}
+	if !checkDatabaseColumnExist(db, "donos", "fulfilled") {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+        UPDATE donos SET state = CASE
+            WHEN fulfilled AND CAST(amount_sent AS REAL) > 0 THEN ?
+            WHEN fulfilled AND (dono_address = ' ' OR atomic_to_send = '0') THEN ?
+            WHEN fulfilled THEN ?
+            WHEN seen THEN ?
+            ELSE ?
+        END
+        WHERE state IS NULL
+    `, utils.DonoConfirmed, utils.DonoCancelled, utils.DonoExpired, utils.DonoSeen, utils.DonoAwaitingPayment)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO dono_events (dono_id, to_state, reason, created_at) SELECT dono_id, state, 'set from the fulfilled flag', ? FROM donos", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("ALTER TABLE donos DROP COLUMN fulfilled"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func removeColumnIfExist(db *sql.DB, tableName, columnName string) error {
 	if checkDatabaseColumnExist(db, tableName, columnName) {
 		_, err := db.Exec(`ALTER TABLE ` + tableName + ` DROP COLUMN ` + columnName)
@@ -2219,7 +2784,7 @@ func createDatabaseIfNotExists(db *sql.DB) error {
             amount_sent TEXT,
             currency_type TEXT,
             anon_dono BOOL,
-            fulfilled BOOL,
+            state TEXT,
             encrypted_ip TEXT,
             created_at DATETIME,
             updated_at DATETIME,
@@ -2230,6 +2795,21 @@ func createDatabaseIfNotExists(db *sql.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS dono_events (
+            event_id INTEGER PRIMARY KEY,
+            dono_id INTEGER,
+            from_state TEXT,
+            to_state TEXT,
+            reason TEXT,
+            created_at DATETIME,
+            FOREIGN KEY(dono_id) REFERENCES donos(dono_id)
+        )
+    `)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`
         CREATE TABLE IF NOT EXISTS billing (
         	billing_id INTEGER PRIMARY KEY,
@@ -2312,6 +2892,18 @@ func createDatabaseIfNotExists(db *sql.DB) error {
 		return err
 	}
 
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS cursors (
+            key TEXT PRIMARY KEY,
+            value TEXT,
+            updated_at DATETIME
+        )
+    `)
+
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`
         CREATE TABLE IF NOT EXISTS pendingusers (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -2338,6 +2930,61 @@ func createDatabaseIfNotExists(db *sql.DB) error {
 	return nil
 }
 
+// dbCursors stores the payment providers' scan positions in the cursors
+// table.
+type dbCursors struct{}
+
+func (dbCursors) Cursor(key string) (string, bool) {
+	var value string
+	err := db.QueryRow("SELECT value FROM cursors WHERE key = ?", key).Scan(&value)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Error reading cursor", key, err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (dbCursors) SetCursor(key string, value string) error {
+	_, err := db.Exec("INSERT INTO cursors (key, value, updated_at) VALUES (?, ?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at", key, value, time.Now().UTC())
+	return err
+}
+
+// dbDonos looks up pending donos and the transfers that paid donos for the
+// providers.
+type dbDonos struct{}
+
+func (dbDonos) PendingAmounts(address string, currency string) ([]*big.Int, error) {
+	pending, args := donoStatesIn(utils.PendingDonoStates)
+	args = append([]interface{}{address, currency}, args...)
+	rows, err := db.Query("SELECT atomic_to_send, amount_to_send FROM donos WHERE lower(dono_address) = lower(?) AND currency_type = ? AND "+pending, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amounts []*big.Int
+	for rows.Next() {
+		var atomic sql.NullString
+		var amount string
+		if err := rows.Scan(&atomic, &amount); err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, parseDonoAtomic(atomic.String, amount, currency))
+	}
+	return amounts, rows.Err()
+}
+
+func (dbDonos) ClaimedBy(txHash string, logIndex int) (int, error) {
+	var donoID int
+	err := db.QueryRow("SELECT dono_id FROM donos WHERE tx_hash = ? AND log_index = ?", txHash, logIndex).Scan(&donoID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return donoID, err
+}
+
 func createNewInviteCode(value string, active bool) error {
 	inviteData := `
         INSERT INTO invites (
@@ -2463,19 +3110,7 @@ func createUser(user utils.User) int {
 	log.Println("running CreateUser")
 	// Insert the user's data into the database
 
-	ce := utils.CryptosEnabled{
-		XMR:   false,
-		SOL:   true,
-		ETH:   true,
-		PAINT: true,
-		HEX:   true,
-		MATIC: true,
-		BUSD:  true,
-		SHIB:  true,
-		PNK:   true,
-	}
-
-	ce_ := cryptosStructToJSONString(ce)
+	ce_ := cryptosStructToJSONString(user.CryptosEnabled)
 
 	_, err := db.Exec(`
         INSERT INTO users (
@@ -2579,7 +3214,7 @@ func createUser(user utils.User) int {
 
 	_, err = getAllUsers()
 	if err != nil {
-		log.Fatalf("createUser() getAllUsers() error:", err)
+		log.Fatalf("createUser() getAllUsers() error: %v", err)
 	}
 
 	return userID
@@ -2596,7 +3231,7 @@ func updateInviteCode(code utils.InviteCode) error {
 	`
 	_, err := db.Exec(statement, code.Value, code.Active, code.Value)
 	if err != nil {
-		log.Println("failed, err: %v", err)
+		log.Printf("failed, err: %v", err)
 	}
 	return err
 }
@@ -2607,12 +3242,16 @@ func updateUser(user utils.User) error {
 	statement := `
 		UPDATE users
 		SET Username=?, HashedPassword=?, eth_address=?, sol_address=?, hex_address=?,
-			xmr_wallet_password=?, min_donation_threshold=?, min_media_threshold=?, media_enabled=?, modified_at=?, links=?, dono_gif=?, dono_sound=?, alert_url=?, date_enabled=?, wallet_uploaded=?, cryptos_enabled=?, default_crypto=?
+			xmr_wallet_password=?, min_donation_threshold=?, min_media_threshold=?, media_enabled=?, modified_at=?, links=?, dono_gif=?, dono_sound=?, alert_url=?, date_enabled=?, wallet_uploaded=?, cryptos_enabled=?, default_crypto=?,
+			xmr_address_mode=?, xmr_confirmations=?, alert_on=?, btc_xpub=?, btc_gap_limit=?,
+			ln_kind=?, ln_url=?, ln_credential=?, ln_cert=?, evm_addresses=?, tron_address=?, fiat_currency=?
 		WHERE id=?
 	`
 	_, err := db.Exec(statement, user.Username, user.HashedPassword, user.EthAddress,
 		user.SolAddress, user.HexcoinAddress, user.XMRWalletPassword, user.MinDono, user.MinMediaDono,
-		user.MediaEnabled, time.Now().UTC(), user.Links, user.DonoGIF, user.DonoSound, user.AlertURL, user.DateEnabled, user.WalletUploaded, cryptosStructToJSONString(user.CryptosEnabled), user.DefaultCrypto, user.UserID)
+		user.MediaEnabled, time.Now().UTC(), user.Links, user.DonoGIF, user.DonoSound, user.AlertURL, user.DateEnabled, user.WalletUploaded, cryptosStructToJSONString(user.CryptosEnabled), user.DefaultCrypto,
+		user.XMRAddressMode, user.XMRConfirmations, user.AlertOn, user.BTCXpub, user.BTCGapLimit,
+		user.LNKind, user.LNURL, user.LNCredential, user.LNCert, evmAddressesJSON(user.EVMAddresses), user.TronAddress, user.Fiat, user.UserID)
 	if err != nil {
 		log.Fatalf("failed, err: %v", err)
 	}
@@ -2630,66 +3269,13 @@ func updateUser(user utils.User) error {
 		log.Fatalf("failed, err: %v", err)
 	}
 
-	solWallets[user.UserID] = utils.SolWallet{
-		Address: user.SolAddress,
-		Amount:  0.00,
-	}
-
-	utils.SetSolWallets(solWallets)
+	payments.SetUser(user)
 	return err
 }
 
 func getUserByAlertURL(AlertURL string) (utils.User, error) {
-	var user utils.User
-	var links, donoGIF, defaultCrypto, donoSound, alertURL, cryptosEnabled sql.NullString // use sql.NullString for the "links" and "dono_gif" fields
-	row := db.QueryRow("SELECT * FROM users WHERE alert_url=?", AlertURL)
-	err := row.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress,
-		&user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono,
-		&user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &links, &donoGIF, &donoSound, &alertURL, &user.DateEnabled, &user.WalletUploaded, &cryptosEnabled, &defaultCrypto)
-	if err != nil {
-		return utils.User{}, err
-	}
-	user.Links = links.String
-	if !links.Valid {
-		user.Links = ""
-	}
-	user.DonoGIF = donoGIF.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !donoGIF.Valid {           // check if the "dono_gif" column is null
-		user.DonoGIF = "default.gif" // set the user's "DonoGIF"
-	}
-	user.DonoSound = donoSound.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !donoSound.Valid {             // check if the "dono_gif" column is null
-		user.DonoSound = "default.mp3" // set the user's "DonoSound"
-	}
-
-	user.DefaultCrypto = defaultCrypto.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !defaultCrypto.Valid {                 // check if the "dono_gif" column is null
-		user.DefaultCrypto = "" // set the user's "DonoSound"
-	}
-
-	user.AlertURL = alertURL.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !alertURL.Valid {            // check if the "dono_gif" column is null
-		user.AlertURL = utils.GenerateUniqueURL() // set the user's "DonoSound"
-	}
-
-	ce := utils.CryptosEnabled{
-		XMR:   true,
-		SOL:   true,
-		ETH:   false,
-		PAINT: false,
-		HEX:   true,
-		MATIC: false,
-		BUSD:  true,
-		SHIB:  false,
-		PNK:   true,
-	}
-
-	user.CryptosEnabled = cryptosJsonStringToStruct(cryptosEnabled.String) // assign the sql.NullString to the user's "DonoGIF" field
-	if !cryptosEnabled.Valid {                                             // check if the "dono_gif" column is null
-		user.CryptosEnabled = ce // set the user's "DonoSound"
-	}
-
-	return user, nil
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE alert_url=?", AlertURL)
+	return scanUser(row)
 }
 
 func getOBSDataByAlertURL(AlertURL string) (utils.OBSDataStruct, error) {
@@ -2753,34 +3339,8 @@ func getUserByUsernameCached(username string) (utils.User, bool) {
 
 // check a user by their ID
 func checkUserByID(id int) bool {
-	var user utils.User
-	var links, donoGIF, donoSound, defaultCrypto, alertURL, cryptosEnabled sql.NullString // use sql.NullString for the "links" and "dono_gif" fields
-	row := db.QueryRow("SELECT * FROM users WHERE id=?", id)
-	err := row.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress,
-		&user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono,
-		&user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &links, &donoGIF, &donoSound, &alertURL, &user.DateEnabled, &user.WalletUploaded, &cryptosEnabled, &defaultCrypto)
-
-	ce := utils.CryptosEnabled{
-		XMR:   true,
-		SOL:   true,
-		ETH:   false,
-		PAINT: false,
-		HEX:   true,
-		MATIC: false,
-		BUSD:  true,
-		SHIB:  false,
-		PNK:   true,
-	}
-
-	user.CryptosEnabled = cryptosJsonStringToStruct(cryptosEnabled.String) // assign the sql.NullString to the user's "DonoGIF" field
-	if !cryptosEnabled.Valid {                                             // check if the "dono_gif" column is null
-		user.CryptosEnabled = ce // set the user's "DonoSound"
-	}
-
-	user.DefaultCrypto = defaultCrypto.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !defaultCrypto.Valid {                 // check if the "dono_gif" column is null
-		user.DefaultCrypto = "" // set the user's "DonoSound"
-	}
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE id=?", id)
+	_, err := scanUser(row)
 
 	if err == sql.ErrNoRows {
 		log.Println("checkUserByID(", id, "): User doesn't exist")
@@ -2814,34 +3374,8 @@ func printUserColumns() error {
 // check a user by their username and return a bool and the id
 func checkUserByUsername(username string) (bool, int) {
 	printUserColumns()
-	var user utils.User
-	var links, donoGIF, donoSound, defaultCrypto, alertURL, cryptosEnabled sql.NullString // use sql.NullString for the "links" and "dono_gif" fields
-	row := db.QueryRow("SELECT * FROM users WHERE Username=?", username)
-	err := row.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress,
-		&user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono,
-		&user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &links, &donoGIF, &donoSound, &alertURL, &user.DateEnabled, &user.WalletUploaded, &cryptosEnabled, &defaultCrypto)
-
-	ce := utils.CryptosEnabled{
-		XMR:   true,
-		SOL:   true,
-		ETH:   false,
-		PAINT: false,
-		HEX:   true,
-		MATIC: false,
-		BUSD:  true,
-		SHIB:  false,
-		PNK:   true,
-	}
-
-	user.CryptosEnabled = cryptosJsonStringToStruct(cryptosEnabled.String) // assign the sql.NullString to the user's "DonoGIF" field
-	if !cryptosEnabled.Valid {                                             // check if the "dono_gif" column is null
-		user.CryptosEnabled = ce // set the user's "DonoSound"
-	}
-
-	user.DefaultCrypto = defaultCrypto.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !defaultCrypto.Valid {                 // check if the "dono_gif" column is null
-		user.DefaultCrypto = "" // set the user's "DonoSound"
-	}
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE Username=?", username)
+	user, err := scanUser(row)
 
 	if err == sql.ErrNoRows {
 		log.Println("checkUserByUsername(", username, "): User doesn't exist")
@@ -2859,55 +3393,8 @@ func getUserBySession(sessionToken string) (utils.User, error) {
 	if !ok {
 		return utils.User{}, fmt.Errorf("session token not found")
 	}
-	var user utils.User
-	var links, donoGIF, defaultCrypto, donoSound, alertURL, cryptosEnabled sql.NullString // use sql.NullString for the "links" and "dono_gif" fields
-	row := db.QueryRow("SELECT * FROM users WHERE id=?", userID)
-	err := row.Scan(&user.UserID, &user.Username, &user.HashedPassword, &user.EthAddress,
-		&user.SolAddress, &user.HexcoinAddress, &user.XMRWalletPassword, &user.MinDono, &user.MinMediaDono,
-		&user.MediaEnabled, &user.CreationDatetime, &user.ModificationDatetime, &links, &donoGIF, &donoSound, &alertURL, &user.DateEnabled, &user.WalletUploaded, &cryptosEnabled, &defaultCrypto)
-	if err != nil {
-		return utils.User{}, err
-	}
-	user.Links = links.String
-	if !links.Valid {
-		user.Links = ""
-	}
-	user.DonoGIF = donoGIF.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !donoGIF.Valid {           // check if the "dono_gif" column is null
-		user.DonoGIF = "default.gif" // set the user's "DonoGIF"
-	}
-	user.DonoSound = donoSound.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !donoSound.Valid {             // check if the "dono_gif" column is null
-		user.DonoSound = "default.mp3" // set the user's "DonoSound"
-	}
-	user.DefaultCrypto = defaultCrypto.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !defaultCrypto.Valid {                 // check if the "dono_gif" column is null
-		user.DefaultCrypto = "" // set the user's "DonoSound"
-	}
-
-	user.AlertURL = alertURL.String // assign the sql.NullString to the user's "DonoGIF" field
-	if !alertURL.Valid {            // check if the "dono_gif" column is null
-		user.AlertURL = utils.GenerateUniqueURL() // set the user's "DonoSound"
-	}
-
-	ce := utils.CryptosEnabled{
-		XMR:   true,
-		SOL:   true,
-		ETH:   false,
-		PAINT: false,
-		HEX:   true,
-		MATIC: false,
-		BUSD:  true,
-		SHIB:  false,
-		PNK:   true,
-	}
-
-	user.CryptosEnabled = cryptosJsonStringToStruct(cryptosEnabled.String) // assign the sql.NullString to the user's "DonoGIF" field
-	if !cryptosEnabled.Valid {                                             // check if the "dono_gif" column is null
-		user.CryptosEnabled = ce // set the user's "DonoSound"
-	}
-
-	return user, nil
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE id=?", userID)
+	return scanUser(row)
 }
 
 func verifyPassword(user utils.User, password string) bool {
@@ -3088,9 +3575,14 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == "POST" {
 		user.EthAddress = r.FormValue("ethaddress")
-		user.SolAddress = r.FormValue("soladdress")
+		user.SolAddress = strings.TrimSpace(r.FormValue("soladdress"))
+		if user.SolAddress != "" {
+			if err := sol.ValidateAddress(user.SolAddress); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		user.HexcoinAddress = r.FormValue("hexcoinaddress")
-		user.XMRWalletPassword = r.FormValue("xmrwalletpassword")
 		user.MinDono, _ = strconv.Atoi(r.FormValue("mindono"))
 		user.MinMediaDono, _ = strconv.Atoi(r.FormValue("minmediadono"))
 		mediaEnabled := r.FormValue("mediaenabled") == "on"
@@ -3115,7 +3607,7 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 		User  utils.User
 		Links string // Changed to string to hold JSON
 	}{
-		User:  user,
+		User:  setWalletState(user),
 		Links: user.Links, // Convert byte slice to string
 	}
 
@@ -3239,67 +3731,192 @@ func changeUserMoneroHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if user.WalletUploaded {
-			stopMoneroWallet(user)
+		// The uploaded files are saved next to the wallet and only put in
+		// its place once stopped, to be put back if the new wallet won't open
+		moneroDir := fmt.Sprintf("users/%d/monero", user.UserID)
+		walletUploadServer, err := saveUploadedFile(r, "moneroWallet", filepath.Join(moneroDir, "wallet"+uploadSuffix))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		walletKeysUploadServer, err := saveUploadedFile(r, "moneroWalletKeys", filepath.Join(moneroDir, "wallet.keys"+uploadSuffix))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer discardWalletFiles(moneroDir, uploadSuffix)
+		if walletUploadServer != walletKeysUploadServer {
+			http.Error(w, "Upload both the Monero wallet file and its keys file.", http.StatusBadRequest)
+			return
 		}
+		newWallet := walletUploadServer && walletKeysUploadServer
 
-		// Get the uploaded monero wallet file and save it to disk
-		moneroDir := fmt.Sprintf("users/%d/monero", user.UserID)
-		file, header, err := r.FormFile("moneroWallet")
-		walletUploadServer := false
-		walletKeysUploadServer := false
-		if err == nil {
-			defer file.Close()
-			walletPath := filepath.Join(moneroDir, "wallet")
-			err = saveFileToDisk(file, header, walletPath)
+		oldPassword := user.XMRWalletPassword
+		newPassword := r.FormValue("moneroWalletPassword")
+		if newPassword != "" {
+			sealed, err := utils.SealSecret(secretKey, newPassword)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
-				user.WalletUploaded = false
 				return
-			} else {
-				walletUploadServer = true
 			}
-
+			user.XMRWalletPassword = sealed
 		}
 
-		// Get the uploaded monero wallet keys file and save it to disk
-		file, header, err = r.FormFile("moneroWalletKeys")
-		if err == nil {
-			defer file.Close()
-			walletKeyPath := filepath.Join(moneroDir, "wallet.keys")
-			err = saveFileToDisk(file, header, walletKeyPath)
+		if newWallet || user.WalletUploaded && newPassword != "" {
+			if user.WalletUploaded {
+				stopMoneroWallet(user)
+			}
+			if newWallet {
+				log.Println("Monero wallet uploaded")
+				if err := swapWalletFiles(moneroDir); err != nil {
+					restoreWalletFiles(moneroDir)
+					if user.WalletUploaded {
+						user.XMRWalletPassword = oldPassword
+						startMoneroWallet(user)
+					}
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			startMoneroWallet(user)
+			err = moneroWallets.WaitForOpen(user.UserID, 60*time.Second)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				user.WalletUploaded = false
+				stopMoneroWallet(user)
+				user.XMRWalletPassword = oldPassword
+				if newWallet {
+					restoreWalletFiles(moneroDir)
+				}
+				if user.WalletUploaded {
+					startMoneroWallet(user)
+				}
+				http.Error(w, "The Monero wallet could not be opened, check the wallet files and password: "+err.Error(), http.StatusBadRequest)
 				return
-			} else {
-				walletKeysUploadServer = true
 			}
+			discardWalletFiles(moneroDir, oldSuffix)
+			user.WalletUploaded = true
 		}
 
-		if walletUploadServer && walletKeysUploadServer {
+		// Update the user with the new data
+		err = updateUser(user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-			// convert xmrWallets to a map
-			existingWallets := make(map[int]int)
-			for _, wallet := range xmrWallets {
-				existingWallets[wallet[0]*10000+wallet[1]] = 1
-			}
+		// Redirect to the user page
+		http.Redirect(w, r, "/user", http.StatusSeeOther)
+		return
+	}
 
-			user.WalletUploaded = true
-			walletRunning := true
-			log.Println("Monero wallet uploaded")
-			// check if the element exists in the map and append if not
-			if _, ok := existingWallets[user.UserID*10000+starting_port]; !ok {
-				xmrWallets = append(xmrWallets, []int{user.UserID, starting_port})
-				walletRunning = false
+	// render change password form
+	tmpl, err := template.ParseFiles("web/user.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.Execute(w, data)
+}
+
+func registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		user, valid := getUserBySessionCached(cookie.Value)
+		if valid && !checkLoggedInAdmin(w, r) {
+			log.Println("Already logged in as", user.Username, " - redirecting from registration to user panel.")
+			http.Redirect(w, r, "/user", http.StatusSeeOther)
+			return
+		}
+	}
+
+	err = registerTemplate.Execute(w, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+}
+
+func changeUserHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Starting change user handler function")
+	// retrieve user from session
+	sessionToken, err := r.Cookie("session_token")
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	user, valid := getUserBySessionCached(sessionToken.Value)
+	if !valid {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// initialize user page data struct
+	data := utils.UserPageData{}
+
+	// process form submission
+	if r.Method == "POST" {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		user.EthAddress = r.FormValue("ethereumAddress")
+		user.SolAddress = strings.TrimSpace(r.FormValue("solanaAddress"))
+		if user.SolAddress != "" {
+			if err := sol.ValidateAddress(user.SolAddress); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			go startMoneroWallet(starting_port, user.UserID, user)
-			if !walletRunning {
-				starting_port++
+		}
+		user.TronAddress = strings.TrimSpace(r.FormValue("tronAddress"))
+		if user.TronAddress != "" {
+			if err := tron.ValidateAddress(user.TronAddress); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		user.BTCXpub = strings.TrimSpace(r.FormValue("bitcoinXpub"))
+		if user.BTCXpub != "" {
+			if _, err := btc.ParseAccountKey(user.BTCXpub); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
 		}
+		if gapLimit, err := strconv.Atoi(r.FormValue("bitcoinGapLimit")); err == nil && gapLimit >= 1 {
+			user.BTCGapLimit = gapLimit
+		}
+		if err := setLightningNode(&user, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setEVMAddresses(&user, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		user.HexcoinAddress = r.FormValue("hexcoinAddress")
+		minDono, _ := strconv.Atoi(r.FormValue("minUsdAmount"))
+		user.MinDono = minDono
+		if fiat, ok := utils.GetFiat(r.FormValue("fiatCurrency")); ok {
+			user.Fiat = fiat.Code
+		}
+		minDonoValue = float64(minDono)
+
+		switch r.FormValue("xmrAddressMode") {
+		case xmr.ModeIntegrated, xmr.ModeSubaddress:
+			user.XMRAddressMode = r.FormValue("xmrAddressMode")
+		}
+		if confirmations, err := strconv.Atoi(r.FormValue("xmrConfirmations")); err == nil && confirmations >= 1 {
+			user.XMRConfirmations = confirmations
+		}
+		switch r.FormValue("alertOn") {
+		case alertOnConfirmed, alertOnSeen:
+			user.AlertOn = r.FormValue("alertOn")
+		}
 
 		// Update the user with the new data
+
+		user = setUserMinDonos(user)
 		err = updateUser(user)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -3317,31 +3934,78 @@ func changeUserMoneroHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tmpl.Execute(w, data)
+	tmpl.Execute(w, data)
+}
+
+// setEVMAddresses reads which of the configured EVM chains the user accepts
+// payments on, and at which address. An empty address is the Ethereum one.
+func setEVMAddresses(user *utils.User, r *http.Request) error {
+	enabled := map[string]bool{}
+	for _, id := range r.Form["evmChain"] {
+		enabled[id] = true
+	}
+
+	addresses := map[uint64]string{}
+	for _, chain := range evmChains {
+		id := strconv.FormatUint(chain.ID, 10)
+		if !enabled[id] {
+			continue
+		}
+		address := strings.TrimSpace(r.FormValue("evmAddress" + id))
+		if address != "" {
+			if err := eth.ValidateAddress(address); err != nil {
+				return fmt.Errorf("%s: %v", chain.Name, err)
+			}
+		}
+		addresses[chain.ID] = address
+	}
+	user.EVMAddresses = addresses
+	return nil
 }
 
-func registerUserHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_token")
-	if err == nil {
-		user, valid := getUserBySessionCached(cookie.Value)
-		if valid && !checkLoggedInAdmin(w, r) {
-			log.Println("Already logged in as", user.Username, " - redirecting from registration to user panel.")
-			http.Redirect(w, r, "/user", http.StatusSeeOther)
-			return
+// setLightningNode reads the Lightning node from the crypto settings form.
+// The macaroon or rune is never shown again, so leaving it empty keeps the
+// stored one as long as the node is the same; clearing the URL removes the
+// node.
+func setLightningNode(user *utils.User, r *http.Request) error {
+	kind := r.FormValue("lightningKind")
+	nodeURL := strings.TrimSpace(r.FormValue("lightningURL"))
+	credential := strings.TrimSpace(r.FormValue("lightningCredential"))
+	cert := strings.TrimSpace(r.FormValue("lightningCert"))
+
+	if nodeURL == "" {
+		user.LNURL, user.LNCredential, user.LNCert = "", "", ""
+		return nil
+	}
+	if credential == "" {
+		if user.LNCredential == "" || kind != user.LNKind || nodeURL != user.LNURL {
+			return fmt.Errorf("enter the invoice macaroon or rune of the Lightning node")
 		}
+		opened, err := utils.OpenSecret(secretKey, user.LNCredential)
+		if err != nil {
+			return err
+		}
+		credential = opened
+	}
+	if err := lnProvider.CheckNode(kind, nodeURL, credential, cert); err != nil {
+		return err
 	}
 
-	err = registerTemplate.Execute(w, nil)
+	sealed, err := utils.SealSecret(secretKey, credential)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-
+	user.LNKind = kind
+	user.LNURL = nodeURL
+	user.LNCredential = sealed
+	user.LNCert = cert
+	return nil
 }
 
-func changeUserHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("Starting change user handler function")
-	// retrieve user from session
+// createMoneroWalletHandler creates the user's view-only wallet on the
+// server from their primary address and private view key, instead of
+// having them upload wallet files.
+func createMoneroWalletHandler(w http.ResponseWriter, r *http.Request) {
 	sessionToken, err := r.Cookie("session_token")
 	if err != nil {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -3352,46 +4016,115 @@ func changeUserHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/cryptosettings", http.StatusSeeOther)
+		return
+	}
 
-	// initialize user page data struct
-	data := utils.UserPageData{}
-
-	// process form submission
-	if r.Method == "POST" {
-		err := r.ParseForm()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		user.EthAddress = r.FormValue("ethereumAddress")
-		user.SolAddress = r.FormValue("solanaAddress")
-		user.HexcoinAddress = r.FormValue("hexcoinAddress")
-		minDono, _ := strconv.Atoi(r.FormValue("minUsdAmount"))
-		user.MinDono = minDono
-		minDonoValue = float64(minDono)
+	address := strings.TrimSpace(r.FormValue("moneroAddress"))
+	viewKey := strings.ToLower(strings.TrimSpace(r.FormValue("moneroViewKey")))
+	password := r.FormValue("moneroWalletPassword")
+	restoreHeight, err := strconv.ParseUint(strings.TrimSpace(r.FormValue("moneroRestoreHeight")), 10, 64)
+	if err != nil {
+		http.Error(w, "The restore height must be a block number, use 0 if you don't know it.", http.StatusBadRequest)
+		return
+	}
+	if err := xmr.ValidateViewKeys(address, viewKey); err != nil {
+		http.Error(w, "The Monero wallet could not be created: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		// Update the user with the new data
+	sealed, err := utils.SealSecret(secretKey, password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		user = setUserMinDonos(user)
-		err = updateUser(user)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	// the running wallet has to close before its files are replaced, and
+	// comes back with its old files and password if that fails
+	stopMoneroWallet(user)
+	err = moneroWallets.GenerateFromKeys(user.UserID, address, viewKey, restoreHeight, password)
+	if err != nil {
+		log.Println("Error creating monero wallet for", user.UserID, err)
+		if user.WalletUploaded {
+			startMoneroWallet(user)
 		}
-
-		// Redirect to the user page
-		http.Redirect(w, r, "/user", http.StatusSeeOther)
+		http.Error(w, "The Monero wallet could not be created: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// render change password form
-	tmpl, err := template.ParseFiles("web/user.html")
+	user.XMRWalletPassword = sealed
+	user.WalletUploaded = true
+	log.Println("Monero wallet created from keys for", user.UserID)
+	startMoneroWallet(user)
+
+	err = updateUser(user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tmpl.Execute(w, data)
+	http.Redirect(w, r, "/user", http.StatusSeeOther)
+}
+
+// Suffixes of the monero wallet files uploaded and not yet in place, and of
+// the ones they replaced until the new wallet opens.
+const (
+	uploadSuffix = ".upload"
+	oldSuffix    = ".old"
+)
+
+var walletFiles = []string{"wallet", "wallet.keys"}
+
+// saveUploadedFile saves the file uploaded in a form field to path, and
+// reports whether there was one.
+func saveUploadedFile(r *http.Request, field string, path string) (bool, error) {
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+	if err := saveFileToDisk(file, header, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// swapWalletFiles moves a stopped monero wallet aside and the uploaded one
+// into its place.
+func swapWalletFiles(dir string) error {
+	for _, name := range walletFiles {
+		path := filepath.Join(dir, name)
+		if err := os.Rename(path, path+oldSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(path+uploadSuffix, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreWalletFiles puts back the monero wallet swapWalletFiles moved
+// aside.
+func restoreWalletFiles(dir string) {
+	for _, name := range walletFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path + oldSuffix); err != nil {
+			continue
+		}
+		if err := os.Rename(path+oldSuffix, path); err != nil {
+			log.Println("Error restoring monero wallet file", path, err)
+		}
+	}
+}
+
+// discardWalletFiles removes the monero wallet files with a suffix, if any.
+func discardWalletFiles(dir string, suffix string) {
+	for _, name := range walletFiles {
+		if err := os.Remove(filepath.Join(dir, name+suffix)); err != nil && !os.IsNotExist(err) {
+			log.Println("Error removing monero wallet file", name+suffix, err)
+		}
+	}
 }
 
 func saveFileToDisk(file multipart.File, header *multipart.FileHeader, path string) error {
@@ -3582,11 +4315,10 @@ func progressbarOBSHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Println(err)
 		err_ := indexTemplate.Execute(w, nil)
-		return
 		if err_ != nil {
 			http.Error(w, err_.Error(), http.StatusInternalServerError)
-			return
 		}
+		return
 	}
 
 	/*log.Println("Progress bar message:", obsData.Message)
@@ -3596,6 +4328,8 @@ func progressbarOBSHandler(w http.ResponseWriter, r *http.Request) {
 	pb.Message = obsData.Message
 	pb.Needed = obsData.Needed
 	pb.Sent = obsData.Sent
+	user, _ := getUserByAlertURL(value)
+	pb.Symbol = utils.FiatSymbol(user.Fiat)
 
 	err = progressbarTemplate.Execute(w, pb)
 	if err != nil {
@@ -3612,6 +4346,15 @@ func cryptosStructToJSONString(s utils.CryptosEnabled) string {
 	return string(bytes)
 }
 
+func evmAddressesJSON(addresses map[uint64]string) string {
+	bytes, err := json.Marshal(addresses)
+	if err != nil {
+		log.Println("evmAddressesJSON error:", err)
+		return "{}"
+	}
+	return string(bytes)
+}
+
 func cryptosJsonStringToStruct(jsonStr string) utils.CryptosEnabled {
 	var s utils.CryptosEnabled
 	err := json.Unmarshal([]byte(jsonStr), &s)
@@ -3622,6 +4365,36 @@ func cryptosJsonStringToStruct(jsonStr string) utils.CryptosEnabled {
 	return s
 }
 
+// evmChainSetting is an EVM chain on the crypto settings page.
+type evmChainSetting struct {
+	ID      uint64
+	Name    string
+	Enabled bool
+	Address string
+}
+
+func evmChainSettings(user utils.User) []evmChainSetting {
+	settings := []evmChainSetting{}
+	for _, chain := range evmChains {
+		address, enabled := user.EVMAddresses[chain.ID]
+		settings = append(settings, evmChainSetting{ID: chain.ID, Name: chain.Name, Enabled: enabled, Address: address})
+	}
+	return settings
+}
+
+// evmChainName returns the name of an EVM chain by its ID.
+func evmChainName(id uint64) string {
+	if id == eth.MainnetID {
+		return "Ethereum"
+	}
+	for _, chain := range evmChains {
+		if chain.ID == id {
+			return chain.Name
+		}
+	}
+	return "chain " + strconv.FormatUint(id, 10)
+}
+
 func cryptoSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session_token")
 	if err != nil {
@@ -3639,9 +4412,10 @@ func cryptoSettingsHandler(w http.ResponseWriter, r *http.Request) {
 		moneroWalletString := "monero wallet not uploaded"
 		moneroWalletKeysString := "monero wallet not key uploaded"
 
-		if checkUserMoneroWallet(userPath) && !CheckMoneroPort(user.UserID) {
-			moneroWalletString = "monero wallet uploaded but not running correctly. Please ensure you have created a view only wallet with no password."
-			moneroWalletKeysString = "monero wallet key uploaded but not running correctly. Please ensure you have created a view only wallet with no password."
+		user = setWalletState(user)
+		if checkUserMoneroWallet(userPath) && user.WalletState == string(xmr.StateCrashed) {
+			moneroWalletString = "monero wallet uploaded but not running correctly. Please ensure you uploaded a view only wallet and check the wallet password."
+			moneroWalletKeysString = "monero wallet key uploaded but not running correctly. Please ensure you uploaded a view only wallet and check the wallet password."
 		}
 
 		data := struct {
@@ -3650,9 +4424,19 @@ func cryptoSettingsHandler(w http.ResponseWriter, r *http.Request) {
 			HashedPassword         []byte
 			EthAddress             string
 			SolAddress             string
+			TronAddress            string
+			BTCXpub                string
+			BTCGapLimit            int
+			LNKind                 string
+			LNURL                  string
+			LNCredentialSet        bool
+			LNCert                 string
+			EVMChains              []evmChainSetting
 			HexcoinAddress         string
 			XMRWalletPassword      string
 			MinDono                int
+			Fiat                   string
+			Fiats                  []utils.Fiat
 			MinMediaDono           int
 			MediaEnabled           bool
 			CreationDatetime       string
@@ -3661,21 +4445,14 @@ func cryptoSettingsHandler(w http.ResponseWriter, r *http.Request) {
 			DonoGIF                string
 			DonoSound              string
 			AlertURL               string
-			MinSol                 float64
-			MinEth                 float64
-			MinXmr                 float64
-			MinPaint               float64
-			MinHex                 float64
-			MinMatic               float64
-			MinBusd                float64
-			MinShib                float64
-			MinUsdc                float64
-			MinTusd                float64
-			MinWbtc                float64
-			MinPnk                 float64
+			MinDonos               map[string]float64
+			Currencies             []utils.Currency
 			DateEnabled            time.Time
 			WalletUploaded         bool
 			WalletPending          bool
+			XMRAddressMode         string
+			XMRConfirmations       int
+			AlertOn                string
 			CryptosEnabled         utils.CryptosEnabled
 			BillingData            utils.BillingData
 			MoneroWalletString     string
@@ -3686,9 +4463,19 @@ func cryptoSettingsHandler(w http.ResponseWriter, r *http.Request) {
 			HashedPassword:         user.HashedPassword,
 			EthAddress:             user.EthAddress,
 			SolAddress:             user.SolAddress,
+			TronAddress:            user.TronAddress,
+			BTCXpub:                user.BTCXpub,
+			BTCGapLimit:            user.BTCGapLimit,
+			LNKind:                 user.LNKind,
+			LNURL:                  user.LNURL,
+			LNCredentialSet:        user.LNCredential != "",
+			LNCert:                 user.LNCert,
+			EVMChains:              evmChainSettings(user),
 			HexcoinAddress:         user.HexcoinAddress,
 			XMRWalletPassword:      user.XMRWalletPassword,
 			MinDono:                user.MinDono,
+			Fiat:                   user.Fiat,
+			Fiats:                  utils.Fiats,
 			MinMediaDono:           user.MinMediaDono,
 			MediaEnabled:           user.MediaEnabled,
 			CreationDatetime:       user.CreationDatetime,
@@ -3697,21 +4484,14 @@ func cryptoSettingsHandler(w http.ResponseWriter, r *http.Request) {
 			DonoGIF:                user.DonoGIF,
 			DonoSound:              user.DonoSound,
 			AlertURL:               user.AlertURL,
-			MinSol:                 user.MinSol,
-			MinEth:                 user.MinEth,
-			MinXmr:                 user.MinXmr,
-			MinPaint:               user.MinPaint,
-			MinHex:                 user.MinHex,
-			MinMatic:               user.MinMatic,
-			MinBusd:                user.MinBusd,
-			MinShib:                user.MinShib,
-			MinUsdc:                user.MinUsdc,
-			MinTusd:                user.MinTusd,
-			MinWbtc:                user.MinWbtc,
-			MinPnk:                 user.MinPnk,
+			MinDonos:               user.MinDonos,
+			Currencies:             utils.Currencies,
 			DateEnabled:            user.DateEnabled,
 			WalletUploaded:         user.WalletUploaded,
 			WalletPending:          user.WalletPending,
+			XMRAddressMode:         user.XMRAddressMode,
+			XMRConfirmations:       user.XMRConfirmations,
+			AlertOn:                user.AlertOn,
 			CryptosEnabled:         user.CryptosEnabled,
 			BillingData:            user.BillingData,
 			MoneroWalletString:     moneroWalletString,
@@ -3824,79 +4604,66 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 			user.Links = "[]"
 		}
 
-		XMR_ := user.CryptosEnabled.XMR
-
-		if user.CryptosEnabled.XMR == true && user.WalletRunning != false {
-
-		}
-
-		XMR_ = false
-
-		if user.EthAddress == "" {
-			user.CryptosEnabled.ETH = false
-			user.CryptosEnabled.PAINT = false
-			user.CryptosEnabled.HEX = false
-			user.CryptosEnabled.MATIC = false
-			user.CryptosEnabled.BUSD = false
-			user.CryptosEnabled.SHIB = false
-			user.CryptosEnabled.PNK = false
-		}
-
-		if user.SolAddress == "" {
-			user.CryptosEnabled.SOL = false
+		// Only offer a currency if the user accepts it and has somewhere to receive it
+		CE_ := utils.CryptosEnabled{}
+		for _, c := range utils.Currencies {
+			enabled := user.CryptosEnabled[c.Code]
+			switch c.Chain {
+			case utils.ChainMonero:
+				enabled = false // XMR is switched off on the donation page for now
+			case utils.ChainSolana:
+				enabled = enabled && user.SolAddress != ""
+			case utils.ChainTron:
+				enabled = enabled && user.TronAddress != ""
+			case utils.ChainBitcoin:
+				enabled = enabled && user.BTCXpub != ""
+			case utils.ChainLightning:
+				enabled = enabled && user.LNURL != "" && user.LNCredential != ""
+			default:
+				if c.ChainID != 0 {
+					enabled = enabled && eth.UserAddress(user, c.ChainID) != ""
+				}
+			}
+			CE_[c.Code] = enabled
 		}
 
-		CE_ := user.CryptosEnabled
-		CE_.XMR = XMR_
 		user.DefaultCrypto = "SOL"
 		if user.DefaultCrypto == "" {
-			if CE_.XMR {
-				user.DefaultCrypto = "XMR"
-			} else if CE_.SOL {
-				user.DefaultCrypto = "SOL"
-			} else if CE_.ETH {
-				user.DefaultCrypto = "ETH"
-			} else if CE_.PAINT {
-				user.DefaultCrypto = "PAINT"
-			} else if CE_.HEX {
-				user.DefaultCrypto = "HEX"
-			} else if CE_.MATIC {
-				user.DefaultCrypto = "MATIC"
-			} else if CE_.BUSD {
-				user.DefaultCrypto = "BUSD"
-			} else if CE_.SHIB {
-				user.DefaultCrypto = "SHIB"
-			} else if CE_.PNK {
-				user.DefaultCrypto = "PNK"
+			for _, c := range utils.Currencies {
+				if CE_[c.Code] {
+					user.DefaultCrypto = c.Code
+					break
+				}
 			}
+		}
 
+		fiatPrice, fiatOK := fiatRate(user.Fiat)
+		currencies := []utils.CurrencyDisplay{}
+		for _, c := range utils.Currencies {
+			price, ok := priceOracle.Price(c.Code)
+			if !ok || !fiatOK {
+				CE_[c.Code] = false // no quoting a currency without fresh prices
+				price = 0
+			} else {
+				price = math.Round(price/fiatPrice*1e6) / 1e6
+			}
+			currencies = append(currencies, utils.CurrencyDisplay{
+				Currency: c,
+				Min:      user.MinDonos[c.Code],
+				Price:    price,
+				Enabled:  CE_[c.Code],
+			})
 		}
 
 		i := utils.IndexDisplay{
 			MaxChar:        MessageMaxChar,
 			MinDono:        user.MinDono,
-			MinSolana:      user.MinSol,
-			MinEthereum:    user.MinEth,
-			MinMonero:      user.MinXmr,
-			MinHex:         user.MinHex,
-			MinPolygon:     user.MinMatic,
-			MinBusd:        user.MinBusd,
-			MinShib:        user.MinShib,
-			MinPnk:         user.MinPnk,
-			MinPaint:       user.MinPaint,
-			SolPrice:       prices.Solana,
-			ETHPrice:       prices.Ethereum,
-			XMRPrice:       prices.Monero,
-			PolygonPrice:   prices.Polygon,
-			HexPrice:       prices.Hexcoin,
-			BusdPrice:      prices.BinanceUSD,
-			ShibPrice:      prices.ShibaInu,
-			PnkPrice:       prices.Kleros,
-			PaintPrice:     prices.Paint,
+			FiatSymbol:     utils.FiatSymbol(user.Fiat),
+			Currencies:     currencies,
 			CryptosEnabled: CE_,
 			Checked:        checked,
 			Links:          user.Links,
-			WalletPending:  user.WalletPending,
+			WalletPending:  setWalletState(user).WalletPending,
 			DefaultCrypto:  user.DefaultCrypto,
 			Username:       username,
 		}
@@ -3923,7 +4690,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 func checkDonoQueue(db *sql.DB, userID int) (bool, error) {
 
 	// Fetch oldest entry from queue table where user_id matches userID
-	row := db.QueryRow("SELECT name, message, amount, currency, media_url, usd_amount FROM queue WHERE user_id = ? ORDER BY rowid LIMIT 1", userID)
+	row := db.QueryRow("SELECT name, message, amount, currency, media_url, usd_amount, fiat_amount, fiat_currency FROM queue WHERE user_id = ? ORDER BY rowid LIMIT 1", userID)
 
 	var name string
 	var message string
@@ -3931,8 +4698,10 @@ func checkDonoQueue(db *sql.DB, userID int) (bool, error) {
 	var currency string
 	var media_url string
 	var usd_amount float64
+	var fiat_amount sql.NullFloat64
+	var fiat sql.NullString
 
-	err := row.Scan(&name, &message, &amount, &currency, &media_url, &usd_amount)
+	err := row.Scan(&name, &message, &amount, &currency, &media_url, &usd_amount, &fiat_amount, &fiat)
 	if err == sql.ErrNoRows {
 		// Queue is empty, do nothing
 		return false, nil
@@ -3949,6 +4718,7 @@ func checkDonoQueue(db *sql.DB, userID int) (bool, error) {
 	a.Currency = currency
 	a.MediaURL = media_url
 	a.USDAmount = usd_amount
+	a.FiatValue = utils.FormatFiat(fiat_amount.Float64, fiat.String)
 	a.Refresh = getRefreshFromUSDAmount(usd_amount, media_url)
 	a.DisplayToggle = "display: block;"
 
@@ -4044,6 +4814,8 @@ func newAccountHandler(w http.ResponseWriter, r *http.Request) {
 			pendingUser, err := createNewPendingUser(username, password)
 			if err != nil {
 				log.Println(err)
+				errorHandler(w, r, "Sign up unavailable", "Woops, we couldn't set up your account payment.", "Please try again in a few minutes.")
+				return
 			}
 
 			xmrNeeded, err := strconv.ParseFloat(pendingUser.XMRNeeded, 64)
@@ -4063,10 +4835,10 @@ func newAccountHandler(w http.ResponseWriter, r *http.Request) {
 				DateCreated: time.Now().UTC(),
 			}
 
-			tmp, _ := qrcode.Encode(fmt.Sprintf("monero:%s?tx_amount=%s", pendingUser.XMRAddress, pendingUser.XMRNeeded), qrcode.Low, 320)
+			tmp, _ := qrcode.Encode(payments.MoneroURI(pendingUser.XMRAddress, pendingUser.XMRNeeded), qrcode.Low, 320)
 			d.QRB64XMR = base64.StdEncoding.EncodeToString(tmp)
 
-			donationLink := fmt.Sprintf("ethereum:%s?value=%s", pendingUser.ETHAddress, pendingUser.ETHNeeded)
+			donationLink := ethBillingURI(pendingUser.ETHAddress, pendingUser.ETHNeeded)
 			tmp, _ = qrcode.Encode(donationLink, qrcode.Low, 320)
 			d.QRB64ETH = base64.StdEncoding.EncodeToString(tmp)
 
@@ -4107,6 +4879,16 @@ func checkUsernamePendingOrCreated(username_ string) bool {
 
 }
 
+// ethBillingURI returns the EIP-681 URI for a billing amount kept in ETH.
+func ethBillingURI(address string, amountETH string) string {
+	wei, err := utils.ParseAtomic(amountETH, "ETH")
+	if err != nil {
+		log.Println("Bad ETH billing amount", amountETH, err)
+		wei = new(big.Int)
+	}
+	return payments.EthereumURI(address, "", eth.MainnetID, wei)
+}
+
 func accountBillingHandler(w http.ResponseWriter, r *http.Request) {
 	checkLoggedIn(w, r)
 	cookie, _ := r.Cookie("session_token")
@@ -4116,7 +4898,7 @@ func accountBillingHandler(w http.ResponseWriter, r *http.Request) {
 
 		admin, _ := getUserByUsernameCached("admin")
 
-		xmrNeededFormatted := fmt.Sprintf("%.5f", user.BillingData.XMRAmount)
+		xmrNeededFormatted, _ := utils.PruneStringByDecimalPoints(user.BillingData.XMRAmount, 5)
 		d := utils.AccountPayData{
 			Username:    user.Username,
 			AmountXMR:   xmrNeededFormatted,
@@ -4128,10 +4910,10 @@ func accountBillingHandler(w http.ResponseWriter, r *http.Request) {
 			DateCreated: time.Now().UTC(),
 		}
 
-		tmp, _ := qrcode.Encode(fmt.Sprintf("monero:%s?tx_amount=%s", d.AddressXMR, xmrNeededFormatted), qrcode.Low, 320)
+		tmp, _ := qrcode.Encode(payments.MoneroURI(d.AddressXMR, xmrNeededFormatted), qrcode.Low, 320)
 		d.QRB64XMR = base64.StdEncoding.EncodeToString(tmp)
 
-		donationLink := fmt.Sprintf("ethereum:%s?value=%s", admin.EthAddress, d.AmountETH)
+		donationLink := ethBillingURI(admin.EthAddress, d.AmountETH)
 		tmp, _ = qrcode.Encode(donationLink, qrcode.Low, 320)
 		d.QRB64ETH = base64.StdEncoding.EncodeToString(tmp)
 
@@ -4145,78 +4927,48 @@ func accountBillingHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getNewAccountETHPrice() string {
-	ethPrice, _ := strconv.ParseFloat(fmt.Sprintf("%.18f", (15.00/prices.Ethereum)), 64)
-	ethStr := utils.FuzzDono(ethPrice, "ETH")
-	ethStr_, _ := utils.StandardizeFloatToString(ethStr)
-	return ethStr_
-}
-func getNewAccountXMRPrice() string {
-	xmrPrice, _ := strconv.ParseFloat(fmt.Sprintf("%.5f", (15.00/prices.Monero)), 64)
-	xmrStr, _ := utils.StandardizeFloatToString(xmrPrice)
-	return xmrStr
-}
-
-func getXMRAmountInUSD(usdAmount float64) string {
-	xmrPrice, _ := strconv.ParseFloat(fmt.Sprintf("%.5f", (usdAmount/prices.Monero)), 64)
-	xmrStr, _ := utils.StandardizeFloatToString(xmrPrice)
-	return xmrStr
+func getNewAccountETHPrice() (string, bool) {
+	return getETHAmountInUSD(15.00)
 }
-
-func getETHAmountInUSD(usdAmount float64) string {
-	ethPrice, _ := strconv.ParseFloat(fmt.Sprintf("%.18f", (usdAmount/prices.Ethereum)), 64)
-	ethStr := utils.FuzzDono(ethPrice, "ETH")
-	ethStr_, _ := utils.StandardizeFloatToString(ethStr)
-	return ethStr_
+func getNewAccountXMRPrice() (string, bool) {
+	return getXMRAmountInUSD(15.00)
 }
 
-func getNewAccountXMR() (string, string) {
-	payload := strings.NewReader(`{"jsonrpc":"2.0","id":"0","method":"make_integrated_address"}`)
-	userID := 1
-	portID := getPortID(xmrWallets, userID)
-
-	found := true
-	if portID == -100 {
-		found = false
-	}
-
-	if found {
-		fmt.Println("Port ID for user", userID, "is", portID)
-	} else {
-		fmt.Println("Port ID not found for user", userID)
+// getXMRAmountInUSD returns the XMR worth usdAmount, or false if the XMR
+// price is unknown or stale.
+func getXMRAmountInUSD(usdAmount float64) (string, bool) {
+	price, ok := priceOracle.Price("XMR")
+	if !ok {
+		return "0", false
 	}
-
-	rpcURL_ := "http://127.0.0.1:" + strconv.Itoa(portID) + "/json_rpc"
-
-	req, err := http.NewRequest("POST", rpcURL_, payload)
+	xmrAtomic, err := utils.ParseAtomic(fmt.Sprintf("%.5f", usdAmount/price), "XMR")
 	if err != nil {
-		log.Println("ERROR CREATING req:", err)
-		return "", ""
+		return "0", false
 	}
+	return utils.FormatAtomic(xmrAtomic, "XMR"), true
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("ERROR SENDING REQUEST:", err)
-		return "", ""
+// getETHAmountInUSD returns the ETH worth usdAmount, or false if the ETH
+// price is unknown or stale.
+func getETHAmountInUSD(usdAmount float64) (string, bool) {
+	price, ok := priceOracle.Price("ETH")
+	if !ok {
+		return "0", false
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		log.Println("ERROR: Non-200 response code received:", res.StatusCode)
-		return "", ""
+	ethAtomic, err := utils.FuzzAtomic(utils.AtomicFromFloat(usdAmount/price, "ETH"), "ETH", nil)
+	if err != nil {
+		return "0", false
 	}
+	return utils.FormatAtomic(ethAtomic, "ETH"), true
+}
 
-	resp := &utils.RPCResponse{}
-	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
-		log.Println("ERROR DECODING RESPONSE:", err)
+func getNewAccountXMR() (string, string) {
+	PayID, PayAddress, err := xmrProvider.IntegratedAddress(1)
+	if err != nil {
+		log.Println("ERROR CREATING XMR ADDRESS:", err)
 		return "", ""
 	}
 
-	PayID := html.EscapeString(resp.Result.PaymentID)
-
-	PayAddress := html.EscapeString(resp.Result.IntegratedAddress)
-
 	log.Println("RETURNING XMR PAYID:", PayID)
 	return PayID, PayAddress
 }
@@ -4228,14 +4980,19 @@ func createNewPendingUser(username string, password string) (utils.PendingUser,
 	if err != nil {
 		return utils.PendingUser{}, err
 	}
+	ethNeeded, ethOK := getNewAccountETHPrice()
+	xmrNeeded, xmrOK := getNewAccountXMRPrice()
+	if !ethOK || !xmrOK {
+		return utils.PendingUser{}, fmt.Errorf("no fresh XMR and ETH prices to bill the account in")
+	}
 	PayID, PayAddress := getNewAccountXMR()
 	user := utils.PendingUser{
 		Username:       username,
 		HashedPassword: hashedPassword,
 		ETHAddress:     user_.EthAddress,
 		XMRAddress:     PayAddress,
-		ETHNeeded:      getNewAccountETHPrice(),
-		XMRNeeded:      getNewAccountXMRPrice(),
+		ETHNeeded:      ethNeeded,
+		XMRNeeded:      xmrNeeded,
 		XMRPayID:       PayID,
 	}
 
@@ -4276,24 +5033,13 @@ func createPendingUser(user utils.PendingUser) error {
 }
 
 func getNewUser(username string, hashedPassword []byte) utils.User {
-	ce := utils.CryptosEnabled{
-		XMR:   false,
-		SOL:   false,
-		ETH:   false,
-		PAINT: false,
-		HEX:   false,
-		MATIC: false,
-		BUSD:  false,
-		SHIB:  false,
-		PNK:   false,
-	}
-
 	user := utils.User{
 		Username:          username,
 		HashedPassword:    hashedPassword,
-		CryptosEnabled:    ce,
+		CryptosEnabled:    utils.DefaultCryptosEnabled(),
 		EthAddress:        "",
 		SolAddress:        "",
+		BTCGapLimit:       btc.DefaultGapLimit,
 		HexcoinAddress:    "",
 		XMRWalletPassword: "",
 		MinDono:           3,
@@ -4379,19 +5125,7 @@ func paymentHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("fAmount", fAmount)
 	fmt.Println("Amount", amount)
 
-	minValues := map[string]float64{
-		"XMR":   user.MinXmr,
-		"SOL":   user.MinSol,
-		"ETH":   user.MinEth,
-		"PAINT": user.MinPaint,
-		"HEX":   user.MinHex,
-		"MATIC": user.MinMatic,
-		"BUSD":  user.MinBusd,
-		"SHIB":  user.MinShib,
-		"PNK":   user.MinPnk,
-	}
-
-	if minValue, ok := minValues[fCrypto]; ok && amount < minValue {
+	if minValue, ok := user.MinDonos[fCrypto]; ok && amount < minValue {
 		amount = minValue
 	}
 
@@ -4423,32 +5157,31 @@ func paymentHandler(w http.ResponseWriter, r *http.Request) {
 	s.Message = html.EscapeString(truncateStrings(condenseSpaces(message), MessageMaxChar))
 	s.Media = html.EscapeString(media)
 
-	USDAmount := getUSDValue(amount, fCrypto)
-	if fCrypto == "XMR" {
-		createNewXMRDono(s.Name, s.Message, s.Media, amount, ip)
-		handleMoneroPayment(w, &s, params, amount, ip, showAmount, USDAmount, user.UserID)
-	} else if fCrypto == "SOL" {
-		new_dono := createNewSolDono(s.Name, s.Message, s.Media, utils.FuzzDono(amount, "SOL"), ip)
-		handleSolanaPayment(w, &s, params, new_dono.Name, new_dono.Message, new_dono.AmountNeeded, showAmount, media, ip, USDAmount, user.UserID)
-	} else {
-		s.Currency = fCrypto
-		new_dono := createNewEthDono(s.Name, s.Message, s.Media, amount, fCrypto, ip)
-		handleEthereumPayment(w, &s, new_dono.Name, new_dono.Message, new_dono.AmountNeeded, showAmount, new_dono.MediaURL, fCrypto, ip, USDAmount, user.UserID)
+	provider, ok := payments.ForCurrency(fCrypto)
+	if !ok {
+		errorHandler(w, r, "Currency not supported", "Woops, that cryptocurrency isn't accepted here.", "Please go back and pick a different cryptocurrency.")
+		return
+	}
+
+	rate, ok := priceOracle.Price(fCrypto)
+	if _, fiatOK := fiatRate(user.Fiat); !fiatOK {
+		ok = false
+	}
+	if !ok {
+		errorHandler(w, r, "Price unavailable", "We can't get a current price for "+fCrypto+" right now, so it can't be quoted.", "Please try again in a few minutes or pick a different cryptocurrency.")
+		return
 	}
+	createNewPendingDono(s.Name, s.Message, s.Media, amount, fCrypto, ip)
+	handlePayment(w, r, provider, &s, params, user, fCrypto, amount, showAmount, ip, rate)
 }
 
-func createNewSolDono(name string, message string, mediaURL string, amountNeeded float64, encrypted_ip string) utils.SuperChat {
-	new_dono := utils.CreatePendingDono(name, message, mediaURL, amountNeeded, "SOL", encrypted_ip)
+func createNewPendingDono(name string, message string, mediaURL string, amountNeeded float64, cryptoCode string, encrypted_ip string) utils.SuperChat {
+	new_dono := utils.CreatePendingDono(name, message, mediaURL, amountNeeded, cryptoCode, encrypted_ip)
 	pending_donos = utils.AppendPendingDono(pending_donos, new_dono)
 
 	return new_dono
 }
 
-func createNewXMRDono(name string, message string, mediaURL string, amountNeeded float64, encrypted_ip string) {
-	new_dono := utils.CreatePendingDono(name, message, mediaURL, amountNeeded, "XMR", encrypted_ip)
-	pending_donos = utils.AppendPendingDono(pending_donos, new_dono)
-}
-
 func checkDonationStatusHandler(w http.ResponseWriter, r *http.Request) {
 	donationIDStr := r.FormValue("donation_id") // Get the donation ID from the query string
 	donationID, err := strconv.Atoi(donationIDStr)
@@ -4458,196 +5191,84 @@ func checkDonationStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	completed := isDonoFulfilled(donationID)
-	if completed {
-		fmt.Fprintf(w, `true`) // Return the status as a JSON response
-	} else {
-		fmt.Fprintf(w, `false`) // Return the status as a JSON response
-	}
+	// only a confirmed dono went through as asked; an underpaid one was
+	// received but not shown, and the donor should know why
+	state, txHash := donoStatus(donationID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Fulfilled bool            `json:"fulfilled"`
+		Underpaid bool            `json:"underpaid"`
+		State     utils.DonoState `json:"state"`
+		TxHash    string          `json:"tx_hash"` // so the donor can look the payment up
+	}{state == utils.DonoConfirmed, state == utils.DonoUnderpaid, state, txHash})
 }
 
-func isDonoFulfilled(donoID int) bool {
-	// Open a new database connection
-	db, err := sql.Open("sqlite3", "users.db")
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
-	// Retrieve the dono with the given ID
-	row := db.QueryRow("SELECT fulfilled FROM donos WHERE dono_id = ?", donoID)
-
-	var fulfilled bool
-	err = row.Scan(&fulfilled)
+// donoStatus returns the state of a dono and the transaction it was matched
+// to, if any yet.
+func donoStatus(donoID int) (utils.DonoState, string) {
+	var state, txHash sql.NullString
+	err := db.QueryRow("SELECT state, tx_hash FROM donos WHERE dono_id = ?", donoID).Scan(&state, &txHash)
 	if err != nil {
-		panic(err)
+		log.Println("Error getting the status of dono", donoID, err)
 	}
-
-	return fulfilled
+	return utils.DonoState(state.String), txHash.String
 }
 
-func ethToWei(ethStr string) *big.Int {
-	etherValue := big.NewFloat(1000000000000000000)
-	f, err := strconv.ParseFloat(ethStr, 64)
+// handlePayment creates the payment request of a dono quoted at rate USD per
+// unit of fCrypto and shows the donor the page to pay it on. The rate holds
+// for quoteLock, or until the payment request expires if that is sooner.
+func handlePayment(w http.ResponseWriter, r *http.Request, provider payments.Provider, s *utils.CryptoSuperChat, params url.Values, user utils.User, fCrypto string, amount float64, showAmount bool, encrypted_ip string, rate float64) {
+	req, err := provider.CreatePaymentRequest(user, fCrypto, amount)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return nil
-	}
-	number := big.NewFloat(f)
-
-	weiValue := new(big.Int)
-	weiValue, _ = weiValue.SetString(number.Mul(number, etherValue).Text('f', 0), 10)
-
-	return weiValue
-}
-
-func getEthAddressByID(userID int) string {
-	if user, ok := globalUsers[userID]; ok {
-		log.Println("Got userID:", user.UserID, "Returned:", user.EthAddress)
-		return user.EthAddress
-	}
-	log.Println("Got userID:", userID, "No user found")
-	return ""
-}
-
-func getSolAddressByID(userID int) string {
-	if user, ok := globalUsers[userID]; ok {
-		log.Println("Got userID:", user.UserID, "Returned:", user.SolAddress)
-		return user.SolAddress
+		log.Println(provider.Name(), "CreatePaymentRequest() error:", err)
+		errorHandler(w, r, "Payment unavailable", "Woops, we couldn't create a payment address.", "Please go back and try again, or pick a different cryptocurrency.")
+		return
 	}
-	log.Println("Got userID:", userID, "No user found")
-	return ""
-}
 
-func getPortID(xmrWallets [][]int, userID int) int {
-	for _, innerList := range xmrWallets {
-		if innerList[0] == userID {
-			return innerList[1]
+	s.Amount = req.Amount
+	s.Address = req.Address
+	s.PayID = req.PayID
+	s.Currency = req.Currency
+	s.ContractAddress = req.ContractAddress
+	if c, ok := utils.GetCurrency(req.Currency); ok {
+		s.Chain = c.Chain
+		if c.ChainID != 0 && c.ChainID != eth.MainnetID {
+			s.Network = evmChainName(c.ChainID)
 		}
 	}
-	return -100
-}
-
-func handleEthereumPayment(w http.ResponseWriter, s *utils.CryptoSuperChat, name_ string, message_ string, amount_ float64, showAmount_ bool, media_ string, fCrypto string, encrypted_ip string, USDAmount float64, userID int) {
-	address := getEthAddressByID(userID)
-	log.Println("handleEthereumPayment() address:", address)
-
-	decimals, _ := utils.GetCryptoDecimalsByCode(fCrypto)
-	donoStr := fmt.Sprintf("%.*f", decimals, amount_)
-	log.Println("handleEthereumPayment() donoStr:", address)
-
-	s.Amount = donoStr
-	log.Println("handleEthereumPayment() donoStr:", s.Amount)
-
-	if fCrypto != "ETH" {
-		s.ContractAddress, _ = utils.GetCryptoContractByCode(fCrypto)
-	} else {
-		s.ContractAddress = "ETH"
-	}
-
-	if name_ == "" {
-		s.Name = "Anonymous"
-		name_ = s.Name
-	} else {
-		s.Name = html.EscapeString(truncateStrings(condenseSpaces(name_), NameMaxChar))
-	}
-
-	s.WeiAmount = ethToWei(donoStr)
-	s.Media = html.EscapeString(media_)
-	s.Address = address
-
-	donationLink := fmt.Sprintf("ethereum:%s?value=%s", address, donoStr)
-
-	tmp, _ := qrcode.Encode(donationLink, qrcode.Low, 320)
-	s.QRB64 = base64.StdEncoding.EncodeToString(tmp)
-	s.DonationID = createNewDono(userID, address, s.Name, s.Message, s.Amount, fCrypto, encrypted_ip, showAmount_, USDAmount, media_)
-	err := payTemplate.Execute(w, s)
-	if err != nil {
-		fmt.Println(err)
-	}
-}
-
-func handleSolanaPayment(w http.ResponseWriter, s *utils.CryptoSuperChat, params url.Values, name_ string, message_ string, amount_ float64, showAmount_ bool, media_ string, encrypted_ip string, USDAmount float64, userID int) {
-	// Get Solana address and desired balance from request
-	address := getSolAddressByID(userID)
-	donoStr := fmt.Sprintf("%.*f", 9, amount_)
 
-	s.Amount = donoStr
-
-	if name_ == "" {
-		s.Name = "Anonymous"
-	} else {
-		s.Name = html.EscapeString(truncateStrings(condenseSpaces(name_), NameMaxChar))
+	params.Add("id", req.PayID)
+	if req.Address != req.PayID {
+		params.Add("address", req.Address)
 	}
-
-	s.Media = html.EscapeString(media_)
-	s.PayID = address
-	s.Address = address
-	s.Currency = "SOL"
-
-	params.Add("id", s.Address)
-
 	s.CheckURL = params.Encode()
+	s.WalletLinks = payments.WalletLinks(req.URI)
+	s.Reference = req.Reference
 
-	tmp, _ := qrcode.Encode("solana:"+address+"?amount="+donoStr, qrcode.Low, 320)
+	tmp, _ := qrcode.Encode(req.URI, qrcode.Low, 320)
 	s.QRB64 = base64.StdEncoding.EncodeToString(tmp)
 
-	s.DonationID = createNewDono(userID, address, name_, message_, s.Amount, "SOL", encrypted_ip, showAmount_, USDAmount, media_)
-
-	err := payTemplate.Execute(w, s)
-	if err != nil {
-		fmt.Println(err)
-	}
-}
-
-func handleMoneroPayment(w http.ResponseWriter, s *utils.CryptoSuperChat, params url.Values, amount float64, encrypted_ip string, showAmount bool, USDAmount float64, userID int) {
-	payload := strings.NewReader(`{"jsonrpc":"2.0","id":"0","method":"make_integrated_address"}`)
-	portID := getPortID(xmrWallets, userID)
-
-	found := true
-	if portID == -100 {
-		found = false
+	USDAmount := math.Round(amount*rate*100) / 100
+	fiatAmount, ok := usdToFiat(USDAmount, user.Fiat)
+	if !ok {
+		errorHandler(w, r, "Price unavailable", "We can't get a current "+user.Fiat+" rate right now, so this can't be quoted.", "Please try again in a few minutes.")
+		return
 	}
-
-	if found {
-		fmt.Println("Port ID for user", userID, "is", portID)
-	} else {
-		fmt.Println("Port ID not found for user", userID)
+	quoteExpiresAt := time.Now().Add(quoteLock)
+	if !req.ExpiresAt.IsZero() && req.ExpiresAt.Before(quoteExpiresAt) {
+		quoteExpiresAt = req.ExpiresAt
 	}
+	fiatPrice, _ := fiatRate(user.Fiat)
+	s.Rate = utils.FormatFiatPrice(rate/fiatPrice, user.Fiat)
+	s.QuoteExpiresAt = quoteExpiresAt
 
-	rpcURL_ := "http://127.0.0.1:" + strconv.Itoa(portID) + "/json_rpc"
-
-	req, err := http.NewRequest("POST", rpcURL_, payload)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("ERROR CREATING")
-	}
-	req.Header.Set("Content-Type", "application/json")
-	res, err := http.DefaultClient.Do(req)
+	s.DonationID = createNewDono(user.UserID, req.PayID, req.Reference, req.ExpiresAt, s.Name, s.Message, req.Atomic, fCrypto, encrypted_ip, showAmount, USDAmount, fiatAmount, user.Fiat, rate, quoteExpiresAt, s.Media)
+	dono := utils.Dono{ID: int(s.DonationID), State: utils.DonoCreated}
+	err = setDonoState(&dono, utils.DonoAwaitingPayment, provider.Name()+" payment request for "+req.Amount+" "+req.Currency)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("ERROR CREATING")
-	}
-
-	resp := &utils.RPCResponse{}
-	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
-		fmt.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("ERROR CREATING")
+		log.Println("Error marking dono", s.DonationID, "as awaiting payment:", err)
 	}
 
-	s.PayID = html.EscapeString(resp.Result.PaymentID)
-	s.Address = html.EscapeString(resp.Result.IntegratedAddress)
-	s.Currency = "XMR"
-	params.Add("id", resp.Result.PaymentID)
-	params.Add("address", resp.Result.IntegratedAddress)
-	s.CheckURL = params.Encode()
-
-	tmp, _ := qrcode.Encode(fmt.Sprintf("monero:%s?tx_amount=%s", resp.Result.IntegratedAddress, s.Amount), qrcode.Low, 320)
-	s.QRB64 = base64.StdEncoding.EncodeToString(tmp)
-
-	s.DonationID = createNewDono(userID, s.PayID, s.Name, s.Message, s.Amount, "XMR", encrypted_ip, showAmount, USDAmount, s.Media)
-
 	err = payTemplate.Execute(w, s)
 	if err != nil {
 		fmt.Println(err)
@@ -0,0 +1,79 @@
+package payments
+
+import (
+	"fmt"
+	"math/big"
+	"shadowchat/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PendingDonos looks up the donos still waiting for a payment.
+type PendingDonos interface {
+	// PendingAmounts returns the atomic amounts the pending donos to an
+	// address in a currency wait for.
+	PendingAmounts(address string, currency string) ([]*big.Int, error)
+}
+
+// issuedFor is how long an amount handed out counts as taken on its own,
+// until its dono is stored and PendingDonos knows about it.
+const issuedFor = time.Minute
+
+// Amounts hands out fuzzed amounts for providers that tell donos to the same
+// address apart by amount alone, drawing again while a pending dono to the
+// address already waits for the amount drawn.
+type Amounts struct {
+	pending PendingDonos
+
+	mu     sync.Mutex
+	issued map[string]time.Time // currency:address:atomic -> when it was handed out
+}
+
+// NewAmounts returns Amounts checking against pending, which may be nil to
+// only check against the amounts it handed out itself.
+func NewAmounts(pending PendingDonos) *Amounts {
+	return &Amounts{pending: pending, issued: make(map[string]time.Time)}
+}
+
+// Fuzz returns atomic fuzzed by utils.FuzzAtomic to an amount no pending
+// dono to address in currency waits for.
+func (a *Amounts) Fuzz(atomic *big.Int, currency string, address string) (*big.Int, error) {
+	var waiting []*big.Int
+	if a.pending != nil {
+		amounts, err := a.pending.PendingAmounts(address, currency)
+		if err != nil {
+			return nil, fmt.Errorf("looking up pending %s donos: %w", currency, err)
+		}
+		waiting = amounts
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for key, at := range a.issued {
+		if now.Sub(at) > issuedFor {
+			delete(a.issued, key)
+		}
+	}
+
+	// addresses are compared without case, as EVM ones may be checksummed;
+	// a base58 one clashing with another case of itself only costs a draw
+	prefix := currency + ":" + strings.ToLower(address) + ":"
+	fuzzed, err := utils.FuzzAtomic(atomic, currency, func(n *big.Int) bool {
+		if _, ok := a.issued[prefix+n.String()]; ok {
+			return true
+		}
+		for _, w := range waiting {
+			if w.Cmp(n) == 0 {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.issued[prefix+fuzzed.String()] = now
+	return fuzzed, nil
+}
@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"math/big"
+	"testing"
+)
+
+type fixedPending []*big.Int
+
+func (f fixedPending) PendingAmounts(address string, currency string) ([]*big.Int, error) {
+	return f, nil
+}
+
+const testAddress = "0xAbC0000000000000000000000000000000000001"
+
+func TestFuzzAvoidsIssuedAmounts(t *testing.T) {
+	a := NewAmounts(nil)
+	base := big.NewInt(5000000)
+	seen := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		n, err := a.Fuzz(base, "USDC", testAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[n.String()] {
+			t.Fatalf("draw %d handed out %s twice", i, n)
+		}
+		seen[n.String()] = true
+	}
+}
+
+func TestFuzzAvoidsPendingAmounts(t *testing.T) {
+	base := big.NewInt(5000000)
+	var pending fixedPending
+	for i := int64(0); i < 100; i++ {
+		if i != 42 {
+			pending = append(pending, new(big.Int).Add(base, big.NewInt(i)))
+		}
+	}
+
+	// only one amount in the span of 100 is free, so most calls give up
+	a := NewAmounts(pending)
+	for i := 0; i < 50; i++ {
+		n, err := a.Fuzz(base, "USDC", testAddress)
+		if err == nil {
+			if n.Int64() != 5000042 {
+				t.Fatalf("handed out %s, which a pending dono waits for", n)
+			}
+			return
+		}
+	}
+}
+
+func TestFuzzGivesUpWhenEverythingIsTaken(t *testing.T) {
+	base := big.NewInt(5000000)
+	var pending fixedPending
+	for i := int64(0); i < 100; i++ {
+		pending = append(pending, new(big.Int).Add(base, big.NewInt(i)))
+	}
+	if n, err := NewAmounts(pending).Fuzz(base, "USDC", testAddress); err == nil {
+		t.Errorf("handed out %s with every amount taken", n)
+	}
+}
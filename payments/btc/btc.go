@@ -0,0 +1,398 @@
+package btc
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultGapLimit is how many unpaid addresses in a row wallets look past
+// the last paid one, for streamers that haven't set their own.
+const DefaultGapLimit = 20
+
+// heldFor is how long an address handed out counts as held on its own,
+// until its dono is stored and PendingDonos knows about it.
+const heldFor = time.Minute
+
+// Config says how Bitcoin payments are found.
+type Config struct {
+	// Confirmations is how many blocks deep a payment must be before its
+	// dono is fulfilled. It is seen as soon as it is in the mempool.
+	Confirmations int
+
+	// LookupEvery is how long what an address received is reused before
+	// the backend is asked again, as pending donos are checked every few
+	// seconds and public Esplora APIs are rate limited. Zero asks every
+	// time, for a backend of your own.
+	LookupEvery time.Duration
+}
+
+// DefaultConfig fulfils donos on their first confirmation and asks about
+// each address at most every 30 seconds.
+func DefaultConfig() Config {
+	return Config{Confirmations: 1, LookupEvery: 30 * time.Second}
+}
+
+// lookup is what an address had received when the backend was asked.
+type lookup struct {
+	at      time.Time
+	outputs []Output
+}
+
+// Provider accepts Bitcoin to addresses derived from each streamer's
+// watch-only account key, a fresh one per dono, so a payment is matched by
+// the address it went to.
+type Provider struct {
+	cfg     Config
+	backend Backend
+	cursors payments.Cursors
+	pending payments.PendingDonos
+	claims  payments.Claims
+
+	mu      sync.Mutex
+	users   map[int]utils.User
+	keys    map[string]*AccountKey // parsed account keys, by key string
+	indexes map[string]uint32      // index of every address handed out since the start
+	given   map[string]time.Time   // when each address was last handed out
+
+	lookupsMu sync.Mutex
+	lookups   map[string]lookup // by address
+}
+
+// New returns a Bitcoin provider looking payments up through backend. The
+// derivation indexes handed out are kept in cursors. Addresses pending
+// donos wait on are not handed out again, and outputs claims says paid
+// another dono are not matched.
+func New(cfg Config, backend Backend, cursors payments.Cursors, pending payments.PendingDonos, claims payments.Claims) *Provider {
+	return &Provider{
+		cfg:     cfg,
+		backend: backend,
+		cursors: cursors,
+		pending: pending,
+		claims:  claims,
+		users:   make(map[int]utils.User),
+		keys:    make(map[string]*AccountKey),
+		indexes: make(map[string]uint32),
+		given:   make(map[string]time.Time),
+		lookups: make(map[string]lookup),
+	}
+}
+
+// received returns the outputs paying an address, from the backend if it
+// wasn't asked about the address in the last LookupEvery.
+func (p *Provider) received(address string) ([]Output, error) {
+	p.lookupsMu.Lock()
+	last, ok := p.lookups[address]
+	p.lookupsMu.Unlock()
+	if ok && time.Since(last.at) < p.cfg.LookupEvery {
+		return last.outputs, nil
+	}
+
+	outputs, err := p.backend.Received(address)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.LookupEvery > 0 {
+		p.lookupsMu.Lock()
+		for a, l := range p.lookups {
+			if time.Since(l.at) >= p.cfg.LookupEvery {
+				delete(p.lookups, a)
+			}
+		}
+		p.lookups[address] = lookup{at: time.Now(), outputs: outputs}
+		p.lookupsMu.Unlock()
+	}
+	return outputs, nil
+}
+
+func (p *Provider) Name() string {
+	return "bitcoin"
+}
+
+func (p *Provider) Currencies() []string {
+	return utils.CurrencyCodesForChain(utils.ChainBitcoin)
+}
+
+func (p *Provider) Start(users []utils.User) {
+	for _, user := range users {
+		p.SetUser(user)
+	}
+}
+
+func (p *Provider) SetUser(user utils.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[user.UserID] = user
+}
+
+// accountKey returns the user's parsed account key. The caller holds p.mu.
+func (p *Provider) accountKey(user utils.User) (*AccountKey, error) {
+	if user.BTCXpub == "" {
+		return nil, fmt.Errorf("user %d has no bitcoin account key", user.UserID)
+	}
+	if key, ok := p.keys[user.BTCXpub]; ok {
+		return key, nil
+	}
+	key, err := ParseAccountKey(user.BTCXpub)
+	if err != nil {
+		return nil, err
+	}
+	p.keys[user.BTCXpub] = key
+	return key, nil
+}
+
+// The indexes are saved per user and account key, so pasting a new key
+// starts from its first address.
+func indexKey(user utils.User, which string) string {
+	return "bitcoin:" + strconv.Itoa(user.UserID) + ":" + user.BTCXpub + ":" + which
+}
+
+// index reads a saved derivation index, -1 if there is none yet.
+func (p *Provider) index(user utils.User, which string) int64 {
+	value, ok := p.cursors.Cursor(indexKey(user, which))
+	if !ok {
+		return -1
+	}
+	index, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+func (p *Provider) setIndex(user utils.User, which string, index int64) error {
+	return p.cursors.SetCursor(indexKey(user, which), strconv.FormatInt(index, 10))
+}
+
+func gapLimit(user utils.User) int64 {
+	if user.BTCGapLimit < 1 {
+		return DefaultGapLimit
+	}
+	return int64(user.BTCGapLimit)
+}
+
+// nextAddress hands out the first address after the last one given that
+// no pending dono waits on. Wallets stop looking for payments a gap limit of
+// unpaid addresses past the last paid one, so once that many are out the
+// unpaid ones are given again, oldest first, rather than ones the
+// streamer's wallet would never see. If every one of them is still waited
+// on there is no address to give.
+func (p *Provider) nextAddress(user utils.User) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, err := p.accountKey(user)
+	if err != nil {
+		return "", err
+	}
+
+	gap := gapLimit(user)
+	used := p.index(user, "used")
+	last := p.index(user, "last")
+	start := last + 1
+	if start <= used || start > used+gap {
+		start = used + 1 // an address after the last given was paid, or the gap is full
+	}
+
+	for i := int64(0); i < gap; i++ {
+		index := used + 1 + (start-used-1+i)%gap
+		address, err := key.Address(uint32(index))
+		if err != nil {
+			return "", err
+		}
+		reused := index <= last
+		free, err := p.free(address, reused)
+		if err != nil {
+			return "", err
+		}
+		if !free {
+			continue
+		}
+		if reused {
+			log.Println("bitcoin: user", user.UserID, "has", gap, "unpaid addresses out, reusing index", index)
+		}
+		if err := p.setIndex(user, "last", index); err != nil {
+			return "", err
+		}
+		p.indexes[address] = uint32(index)
+		p.given[address] = time.Now()
+		return address, nil
+	}
+	return "", fmt.Errorf("user %d has %d addresses out, all waiting for pending donos", user.UserID, gap)
+}
+
+// free reports whether an address can be handed out: no pending dono waits
+// on it, and, if it was handed out before, nothing was paid to it since, as
+// a dono that expired may have been paid late. The caller holds p.mu.
+func (p *Provider) free(address string, reused bool) (bool, error) {
+	for a, at := range p.given {
+		if time.Since(at) > heldFor {
+			delete(p.given, a)
+		}
+	}
+	if _, ok := p.given[address]; ok {
+		return false, nil
+	}
+	if p.pending != nil {
+		amounts, err := p.pending.PendingAmounts(address, "BTC")
+		if err != nil {
+			return false, fmt.Errorf("looking up pending BTC donos: %w", err)
+		}
+		if len(amounts) > 0 {
+			return false, nil
+		}
+	}
+	if reused {
+		outputs, err := p.received(address)
+		if err != nil {
+			return false, err
+		}
+		if len(outputs) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// markUsed records that an address received a payment, which moves the
+// window of addresses the streamer's wallet watches. The caller holds p.mu.
+func (p *Provider) markUsed(user utils.User, address string) {
+	index, ok := p.indexes[address]
+	if !ok {
+		// handed out before a restart, it is within the gap after the last used
+		key, err := p.accountKey(user)
+		if err != nil {
+			return
+		}
+		used := p.index(user, "used")
+		for i := used + 1; i <= used+gapLimit(user); i++ {
+			if a, err := key.Address(uint32(i)); err == nil && a == address {
+				index, ok = uint32(i), true
+				p.indexes[address] = index
+				break
+			}
+		}
+	}
+	if !ok || int64(index) <= p.index(user, "used") {
+		return
+	}
+	if err := p.setIndex(user, "used", int64(index)); err != nil {
+		log.Println("bitcoin: error saving used index of user", user.UserID, err)
+	}
+}
+
+// CreatePaymentRequest hands out the user's next receiving address and
+// asks for the exact amount chosen.
+func (p *Provider) CreatePaymentRequest(user utils.User, currency string, amount float64) (payments.PaymentRequest, error) {
+	if c, ok := utils.GetCurrency(currency); !ok || c.Chain != utils.ChainBitcoin {
+		return payments.PaymentRequest{}, fmt.Errorf("%s is not paid on Bitcoin", currency)
+	}
+	address, err := p.nextAddress(user)
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+	if err := p.backend.Watch(address); err != nil {
+		return payments.PaymentRequest{}, err
+	}
+
+	atomic := utils.AtomicFromFloat(amount, "BTC")
+	amountStr := utils.FormatAtomic(atomic, "BTC")
+	req := payments.PaymentRequest{
+		Currency: "BTC",
+		Address:  address,
+		PayID:    address,
+		Amount:   amountStr,
+		Atomic:   atomic,
+		URI:      payments.BitcoinURI(address, amountStr),
+	}
+	return req, nil
+}
+
+// Poll does nothing for Bitcoin, each dono's address is looked up in Match.
+func (p *Provider) Poll(donos []utils.Dono) error {
+	return nil
+}
+
+// Match looks for an output of at least the amount asked paying the dono's
+// address. It is seen in the mempool and found once it has Confirmations.
+// Smaller outputs add up, and if they fall short the confirmed ones are
+// reported as Partial.
+func (p *Provider) Match(dono utils.Dono) (payments.Match, error) {
+	outputs, err := p.received(dono.Address)
+	if err != nil {
+		return payments.Match{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var smaller []Output
+	for _, out := range outputs {
+		if p.claims != nil {
+			donoID, err := p.claims.ClaimedBy(out.TxID, out.Vout)
+			if err != nil {
+				return payments.Match{}, err
+			}
+			if donoID != 0 && donoID != dono.ID {
+				continue // already paid another dono given the same address
+			}
+		}
+		if out.Value.Cmp(dono.AtomicToSend) < 0 {
+			smaller = append(smaller, out)
+			continue
+		}
+		p.markUsed(p.users[dono.UserID], dono.Address)
+		return outputMatch(out, out.Value, out.Confirmations >= p.cfg.Confirmations), nil
+	}
+	if len(smaller) == 0 {
+		return payments.Match{}, nil
+	}
+	p.markUsed(p.users[dono.UserID], dono.Address)
+
+	// the first output stands for a split payment, as it is the one claimed
+	seen := new(big.Int)
+	confirmed := new(big.Int)
+	for _, out := range smaller {
+		seen.Add(seen, out.Value)
+		if out.Confirmations >= p.cfg.Confirmations {
+			confirmed.Add(confirmed, out.Value)
+		}
+	}
+	switch {
+	case seen.Cmp(dono.AtomicToSend) >= 0:
+		found := confirmed.Cmp(dono.AtomicToSend) >= 0
+		return outputMatch(smaller[0], seen, found), nil
+	case confirmed.Sign() > 0:
+		match := outputMatch(smaller[0], confirmed, false)
+		match.Seen = false
+		match.Partial = true
+		return match, nil
+	}
+	return payments.Match{}, nil
+}
+
+func outputMatch(out Output, amount *big.Int, found bool) payments.Match {
+	match := payments.Match{
+		Found:         found,
+		Seen:          true,
+		AmountSent:    new(big.Int).Set(amount),
+		Confirmations: out.Confirmations,
+		TxHash:        out.TxID,
+		LogIndex:      out.Vout,
+	}
+	if out.Confirmations > 0 {
+		match.BlockNumber = out.BlockHeight
+		match.BlockHash = out.BlockHash
+	}
+	return match
+}
+
+// Seen is Match, so a payment in the mempool keeps its dono alive while it
+// waits for a block. Lookups are reused for LookupEvery, so asking every
+// few seconds doesn't reach the backend that often.
+func (p *Provider) Seen(dono utils.Dono) (payments.Match, error) {
+	return p.Match(dono)
+}
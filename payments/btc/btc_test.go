@@ -0,0 +1,264 @@
+package btc
+
+import (
+	"math/big"
+	"shadowchat/payments/paymentstest"
+	"shadowchat/utils"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testKey is the BIP84 test vector account key, of the "abandon ... about"
+// mnemonic.
+const testKey = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+
+type fakeBackend map[string][]Output
+
+func (b fakeBackend) Watch(address string) error {
+	return nil
+}
+
+func (b fakeBackend) Received(address string) ([]Output, error) {
+	return b[address], nil
+}
+
+// pendingDonos holds the amount each pending dono waits for, by address.
+type pendingDonos map[string]*big.Int
+
+func (d pendingDonos) PendingAmounts(address string, currency string) ([]*big.Int, error) {
+	if amount, ok := d[address]; ok {
+		return []*big.Int{amount}, nil
+	}
+	return nil, nil
+}
+
+// claims maps "txid:vout" to the dono the output paid.
+type claims map[string]int
+
+func (c claims) ClaimedBy(txHash string, logIndex int) (int, error) {
+	return c[txHash+":"+strconv.Itoa(logIndex)], nil
+}
+
+var testUser = utils.User{UserID: 1, BTCXpub: testKey, BTCGapLimit: 3}
+
+// newTestProvider returns a provider that asks the backend every time, so
+// tests see what they change in it straight away.
+func newTestProvider(backend fakeBackend, pending pendingDonos, claimed claims) *Provider {
+	cfg := DefaultConfig()
+	cfg.LookupEvery = 0
+	p := New(cfg, backend, paymentstest.NewMemCursors(nil), pending, claimed)
+	p.SetUser(testUser)
+	return p
+}
+
+// handOut hands out the next address and stores a pending dono on it, as
+// the donation handler does.
+func handOut(t *testing.T, p *Provider, pending pendingDonos) string {
+	t.Helper()
+	address, err := p.nextAddress(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending[address] = big.NewInt(10000)
+	return address
+}
+
+// forgetGiven makes the addresses handed out older than heldFor, so only
+// the pending donos hold them.
+func forgetGiven(p *Provider) {
+	for address := range p.given {
+		p.given[address] = time.Now().Add(-2 * heldFor)
+	}
+}
+
+func TestFirstAddress(t *testing.T) {
+	// BIP44, BIP49 and BIP84 test vectors, all of the "abandon ... about"
+	// mnemonic
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj", "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA"},
+		{"ypub6Ww3ibxVfGzLrAH1PNcjyAWenMTbbAosGNB6VvmSEgytSER9azLDWCxoJwW7Ke7icmizBMXrzBx9979FfaHxHcrArf3zbeJJJUZPf663zsP", "37VucYSaXLCAsxYyAPfbSi9eh4iEcbShgf"},
+		{testKey, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+	}
+	for _, tt := range tests {
+		key, err := ParseAccountKey(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err := key.Address(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address != tt.want {
+			t.Errorf("%s address 0 = %s, want %s", tt.key[:4], address, tt.want)
+		}
+	}
+}
+
+func TestGapFullOfPendingDonos(t *testing.T) {
+	pending := pendingDonos{}
+	p := newTestProvider(fakeBackend{}, pending, claims{})
+
+	given := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		given[handOut(t, p, pending)] = true
+	}
+	if len(given) != 3 {
+		t.Fatalf("handed out %d different addresses, want 3", len(given))
+	}
+
+	forgetGiven(p)
+	if address, err := p.nextAddress(testUser); err == nil {
+		t.Fatalf("handed out %s while every address in the gap is waited on", address)
+	}
+
+	second, _ := p.keys[testKey].Address(1)
+	delete(pending, second) // its dono expired
+	address, err := p.nextAddress(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != second {
+		t.Errorf("handed out %s, want the freed %s", address, second)
+	}
+}
+
+func TestJustGivenAddressIsHeld(t *testing.T) {
+	p := newTestProvider(fakeBackend{}, pendingDonos{}, claims{})
+
+	// the donos are never stored, so only the time since each was handed
+	// out keeps the addresses apart
+	given := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		address, err := p.nextAddress(testUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		given[address] = true
+	}
+	if len(given) != 3 {
+		t.Fatalf("handed out %d different addresses, want 3", len(given))
+	}
+	if address, err := p.nextAddress(testUser); err == nil {
+		t.Errorf("handed out %s again right away", address)
+	}
+}
+
+func TestPaidLateAddressIsNotReused(t *testing.T) {
+	backend := fakeBackend{}
+	pending := pendingDonos{}
+	p := newTestProvider(backend, pending, claims{})
+
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		addresses = append(addresses, handOut(t, p, pending))
+	}
+	// every dono expired, and the first was paid afterwards
+	for address := range pending {
+		delete(pending, address)
+	}
+	forgetGiven(p)
+	backend[addresses[0]] = []Output{{TxID: "late", Value: big.NewInt(10000)}}
+
+	address, err := p.nextAddress(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != addresses[1] {
+		t.Errorf("handed out %s, want %s, the oldest unpaid one", address, addresses[1])
+	}
+}
+
+func TestMatchSkipsClaimedOutputs(t *testing.T) {
+	key, _ := ParseAccountKey(testKey)
+	address, _ := key.Address(0)
+	backend := fakeBackend{address: {
+		{TxID: "first", Vout: 0, Value: big.NewInt(20000), Confirmations: 2},
+		{TxID: "second", Vout: 1, Value: big.NewInt(10000), Confirmations: 1},
+	}}
+	p := newTestProvider(backend, pendingDonos{}, claims{"first:0": 1})
+
+	dono := utils.Dono{ID: 2, UserID: 1, Address: address, CurrencyType: "BTC", AtomicToSend: big.NewInt(10000)}
+	m, err := p.Match(dono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Found || m.TxHash != "second" || m.LogIndex != 1 {
+		t.Errorf("matched %+v, want the output no other dono claimed", m)
+	}
+
+	dono.ID = 1
+	if m, _ := p.Match(dono); m.TxHash != "first" {
+		t.Errorf("the dono that claimed an output matched %+v", m)
+	}
+}
+
+func TestSmallerOutputs(t *testing.T) {
+	key, _ := ParseAccountKey(testKey)
+	address, _ := key.Address(0)
+	backend := fakeBackend{address: {
+		{TxID: "first", Vout: 0, Value: big.NewInt(4000), Confirmations: 1},
+		{TxID: "second", Vout: 0, Value: big.NewInt(3000), Confirmations: 0},
+	}}
+	p := newTestProvider(backend, pendingDonos{}, claims{})
+	dono := utils.Dono{ID: 1, UserID: 1, Address: address, CurrencyType: "BTC", AtomicToSend: big.NewInt(10000)}
+
+	m, err := p.Match(dono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Partial || m.Seen || m.Found || m.AmountSent.Int64() != 4000 || m.TxHash != "first" {
+		t.Errorf("short payment matched %+v, want the confirmed 4000 as partial", m)
+	}
+
+	backend[address] = append(backend[address], Output{TxID: "third", Vout: 2, Value: big.NewInt(3000), Confirmations: 1})
+	m, _ = p.Match(dono)
+	if m.Partial || !m.Seen || m.Found || m.AmountSent.Int64() != 10000 {
+		t.Errorf("split payment matched %+v, want seen in full", m)
+	}
+
+	backend[address][1].Confirmations = 1
+	if m, _ = p.Match(dono); !m.Found {
+		t.Errorf("confirmed split payment matched %+v, want found", m)
+	}
+}
+
+// countingBackend counts the lookups of each address.
+type countingBackend struct {
+	fakeBackend
+	lookups map[string]int
+}
+
+func (b countingBackend) Received(address string) ([]Output, error) {
+	b.lookups[address]++
+	return b.fakeBackend.Received(address)
+}
+
+func TestLookupsAreReused(t *testing.T) {
+	key, _ := ParseAccountKey(testKey)
+	address, _ := key.Address(0)
+	backend := countingBackend{fakeBackend{address: {{TxID: "tx", Value: big.NewInt(10000)}}}, map[string]int{}}
+	cfg := DefaultConfig()
+	cfg.LookupEvery = 50 * time.Millisecond
+	p := New(cfg, backend, paymentstest.NewMemCursors(nil), pendingDonos{}, claims{})
+	p.SetUser(testUser)
+
+	dono := utils.Dono{ID: 1, UserID: 1, Address: address, CurrencyType: "BTC", AtomicToSend: big.NewInt(10000)}
+	for i := 0; i < 5; i++ {
+		if m, err := p.Seen(dono); err != nil || !m.Seen {
+			t.Fatalf("seen %+v, %v", m, err)
+		}
+	}
+	if n := backend.lookups[address]; n != 1 {
+		t.Errorf("address looked up %d times, want once", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	p.Seen(dono)
+	if n := backend.lookups[address]; n != 2 {
+		t.Errorf("address looked up %d times after LookupEvery, want twice", n)
+	}
+}
@@ -0,0 +1,95 @@
+package btc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// Address types an account key derives, told apart by the key's version
+// bytes as wallets export them.
+const (
+	TypeP2PKH       = "p2pkh"       // xpub, BIP44 legacy addresses (1...)
+	TypeP2SHP2WPKH  = "p2sh-p2wpkh" // ypub, BIP49 nested segwit addresses (3...)
+	TypeP2WPKH      = "p2wpkh"      // zpub, BIP84 native segwit addresses (bc1q...)
+	accountKeyDepth = 3             // m / purpose' / coin' / account'
+)
+
+type keyVersion struct {
+	addressType string
+	net         *chaincfg.Params
+}
+
+var keyVersions = map[string]keyVersion{
+	"0488b21e": {TypeP2PKH, &chaincfg.MainNetParams},       // xpub
+	"049d7cb2": {TypeP2SHP2WPKH, &chaincfg.MainNetParams},  // ypub
+	"04b24746": {TypeP2WPKH, &chaincfg.MainNetParams},      // zpub
+	"043587cf": {TypeP2PKH, &chaincfg.TestNet3Params},      // tpub
+	"044a5262": {TypeP2SHP2WPKH, &chaincfg.TestNet3Params}, // upub
+	"045f1cf6": {TypeP2WPKH, &chaincfg.TestNet3Params},     // vpub
+}
+
+// AccountKey is a streamer's watch-only account key. Receiving addresses are
+// derived from its external chain, m/.../account'/0/i.
+type AccountKey struct {
+	AddressType string
+	Net         *chaincfg.Params
+	external    *hdkeychain.ExtendedKey
+}
+
+// ParseAccountKey reads an account-level xpub, ypub or zpub (or their
+// testnet tpub, upub and vpub). Private keys are refused.
+func ParseAccountKey(key string) (*AccountKey, error) {
+	key = strings.TrimSpace(key)
+	extended, err := hdkeychain.NewKeyFromString(key)
+	if err != nil {
+		return nil, fmt.Errorf("not an extended public key: %v", err)
+	}
+	if extended.IsPrivate() {
+		return nil, fmt.Errorf("that is a private key, paste the extended public key (xpub, ypub or zpub) instead")
+	}
+	version, ok := keyVersions[hex.EncodeToString(extended.Version())]
+	if !ok {
+		return nil, fmt.Errorf("unknown extended key version, use an xpub, ypub or zpub")
+	}
+	if extended.Depth() != accountKeyDepth {
+		return nil, fmt.Errorf("the key is at depth %d, export the account's extended public key (depth %d)", extended.Depth(), accountKeyDepth)
+	}
+	external, err := extended.Derive(0)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountKey{AddressType: version.addressType, Net: version.net, external: external}, nil
+}
+
+// Address returns the receiving address at index.
+func (k *AccountKey) Address(index uint32) (string, error) {
+	child, err := k.external.Derive(index)
+	if err != nil {
+		return "", err
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+	hash := btcutil.Hash160(pubKey.SerializeCompressed())
+
+	var address btcutil.Address
+	switch k.AddressType {
+	case TypeP2WPKH:
+		address, err = btcutil.NewAddressWitnessPubKeyHash(hash, k.Net)
+	case TypeP2SHP2WPKH:
+		// the redeem script is the P2WPKH program: OP_0 <20 byte hash>
+		address, err = btcutil.NewAddressScriptHash(append([]byte{0x00, 0x14}, hash...), k.Net)
+	default:
+		address, err = btcutil.NewAddressPubKeyHash(hash, k.Net)
+	}
+	if err != nil {
+		return "", err
+	}
+	return address.EncodeAddress(), nil
+}
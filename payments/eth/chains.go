@@ -0,0 +1,175 @@
+package eth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"shadowchat/utils"
+	"strings"
+)
+
+// MainnetID is the chain ID of Ethereum mainnet, whose currencies are the
+// built-in ones in the registry.
+const MainnetID = 1
+
+// Chain is an EVM chain besides Ethereum mainnet that donos can be paid
+// on, as listed in the evm_chains file.
+type Chain struct {
+	ID            uint64  `json:"id"`
+	Key           string  `json:"key"` // short lowercase name, the suffix of its currency codes
+	Name          string  `json:"name"`
+	RPCURL        string  `json:"rpc"`
+	Native        string  `json:"native"`          // symbol of the native coin, e.g. POL
+	NativePriceID string  `json:"native_price_id"` // CoinGecko id of the native coin
+	Confirmations uint64  `json:"confirmations"`   // blocks deep a payment has to be, 0 for the default
+	Tokens        []Token `json:"tokens"`
+}
+
+// Token is an ERC-20 token accepted on a chain.
+type Token struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Contract string `json:"contract"`
+	Decimals int    `json:"decimals"`
+	PriceID  string `json:"price_id"` // CoinGecko id
+}
+
+var (
+	chainKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	symbolPattern   = regexp.MustCompile(`^[A-Z0-9]+$`)
+	addressPattern  = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+)
+
+// ValidateAddress checks that address is a hex EVM address.
+func ValidateAddress(address string) error {
+	if !addressPattern.MatchString(address) {
+		return fmt.Errorf("%q is not an EVM address (0x followed by 40 hex digits)", address)
+	}
+	return nil
+}
+
+// ReadChains reads a JSON list of chains from path and checks them.
+func ReadChains(path string) ([]Chain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var chains []Chain
+	if err := json.Unmarshal(data, &chains); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	ids := map[uint64]bool{MainnetID: true}
+	for i, c := range chains {
+		c.Key = strings.ToLower(strings.TrimSpace(c.Key))
+		c.Native = strings.ToUpper(strings.TrimSpace(c.Native))
+		c.RPCURL = strings.TrimSpace(c.RPCURL)
+		if c.ID == 0 || ids[c.ID] {
+			return nil, fmt.Errorf("%s: chain %q needs a chain ID of its own, and Ethereum (1) is set up with eth_rpc", path, c.Key)
+		}
+		ids[c.ID] = true
+		if !chainKeyPattern.MatchString(c.Key) || len(utils.CurrencyCodesForChain(c.Key)) > 0 {
+			return nil, fmt.Errorf("%s: chain %d needs a lowercase key that no other chain uses", path, c.ID)
+		}
+		if c.Name == "" {
+			c.Name = c.Key
+		}
+		if u, err := url.Parse(c.RPCURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%s: chain %s has no http or https rpc url", path, c.Key)
+		}
+		if !symbolPattern.MatchString(c.Native) {
+			return nil, fmt.Errorf("%s: chain %s has no native coin symbol", path, c.Key)
+		}
+		for j, t := range c.Tokens {
+			t.Symbol = strings.ToUpper(strings.TrimSpace(t.Symbol))
+			if !symbolPattern.MatchString(t.Symbol) {
+				return nil, fmt.Errorf("%s: token %d on %s has no symbol", path, j, c.Key)
+			}
+			if err := ValidateAddress(t.Contract); err != nil {
+				return nil, fmt.Errorf("%s: %s on %s: %v", path, t.Symbol, c.Key, err)
+			}
+			if t.Decimals <= 0 || t.Decimals > 36 {
+				return nil, fmt.Errorf("%s: %s on %s needs its decimals", path, t.Symbol, c.Key)
+			}
+			c.Tokens[j] = t
+		}
+		chains[i] = c
+	}
+	return chains, nil
+}
+
+// Currencies returns the registry entries of the chain's native coin and
+// tokens. Their codes are the symbol and the chain key, e.g. USDC_BASE, so
+// they never clash with those of other chains.
+func (c Chain) Currencies() []utils.Currency {
+	suffix := "_" + strings.ToUpper(c.Key)
+	currencies := []utils.Currency{{
+		Code:     c.Native + suffix,
+		Key:      strings.ToLower(c.Native) + "_" + c.Key,
+		Name:     c.Native + " (" + c.Name + ")",
+		Chain:    c.Key,
+		ChainID:  c.ID,
+		Decimals: 18,
+		PriceID:  c.NativePriceID,
+		Icon:     iconFor(c.NativePriceID),
+	}}
+	for _, t := range c.Tokens {
+		name := t.Name
+		if name == "" {
+			name = t.Symbol
+		}
+		currencies = append(currencies, utils.Currency{
+			Code:     t.Symbol + suffix,
+			Key:      strings.ToLower(t.Symbol) + "_" + c.Key,
+			Name:     name + " (" + c.Name + ")",
+			Chain:    c.Key,
+			ChainID:  c.ID,
+			Decimals: t.Decimals,
+			Contract: t.Contract,
+			PriceID:  t.PriceID,
+			Icon:     iconFor(t.PriceID),
+		})
+	}
+	return currencies
+}
+
+// Config returns the provider configuration of the chain.
+func (c Chain) Config() Config {
+	cfg := DefaultConfig()
+	cfg.Chain = c.Key
+	cfg.ChainID = c.ID
+	cfg.RPCURL = c.RPCURL
+	if c.Confirmations > 0 {
+		cfg.Confirmations = c.Confirmations
+	}
+	return cfg
+}
+
+// iconFor borrows the icon of a built-in currency with the same price, so
+// USDC looks like USDC on every chain.
+func iconFor(priceID string) string {
+	for _, c := range utils.Currencies {
+		if priceID != "" && c.PriceID == priceID {
+			return c.Icon
+		}
+	}
+	return "eth.svg"
+}
+
+// UserAddress returns the address the user receives payments at on a
+// chain, empty if they don't accept payments on it.
+func UserAddress(user utils.User, chainID uint64) string {
+	if chainID == MainnetID {
+		return user.EthAddress
+	}
+	address, ok := user.EVMAddresses[chainID]
+	if !ok {
+		return ""
+	}
+	if address == "" {
+		return user.EthAddress
+	}
+	return address
+}
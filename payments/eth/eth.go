@@ -0,0 +1,602 @@
+package eth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config says which chain and node to watch and how.
+type Config struct {
+	Chain        string        // chain key of the currencies accepted, utils.ChainEthereum for mainnet
+	ChainID      uint64        // EVM chain ID, put in the payment URIs
+	RPCURL       string        // any standard Ethereum JSON-RPC endpoint
+	PollInterval time.Duration // how often new blocks are looked for
+	MaxBlocks    uint64        // most blocks scanned per poll, so a long catch up doesn't stall
+	StartBack    uint64        // blocks before the head to start from when nothing was scanned yet
+	KeepFor      time.Duration // how long found transfers are kept for matching
+
+	// Confirmations is how deep a transfer's block must be before its dono
+	// is fulfilled. Until then a reorg takes the transfer back out.
+	Confirmations uint64
+}
+
+// DefaultConfig watches Ethereum mainnet through a public endpoint. Running
+// your own node and pointing RPCURL at it is preferred.
+func DefaultConfig() Config {
+	return Config{
+		Chain:        utils.ChainEthereum,
+		ChainID:      MainnetID,
+		RPCURL:       "https://ethereum-rpc.publicnode.com",
+		PollInterval: 15 * time.Second,
+		MaxBlocks:    100,
+		StartBack:    20,
+		KeepFor:      48 * time.Hour,
+
+		Confirmations: 12,
+	}
+}
+
+// The cursor is saved per chain as "<block number>:<block hash>" so a reorg
+// of the last scanned block is noticed after a restart as well.
+func (p *Provider) cursorKey() string {
+	return p.cfg.Chain + ":last_block"
+}
+
+// The transfers found are saved next to the cursor, so ones found before a
+// restart, in blocks the cursor has moved past, can still be matched after
+// it.
+func (p *Provider) transfersKey() string {
+	return p.cfg.Chain + ":transfers"
+}
+
+// maxReorgDepth is how far back scan looks for the block a reorg forked at.
+const maxReorgDepth = 64
+
+// Transfer is an incoming native coin or ERC-20 payment to a watched
+// address.
+type Transfer struct {
+	Currency    string
+	From        string
+	To          string
+	Value       *big.Int // atomic units of Currency
+	TxHash      string
+	LogIndex    int // -1 for native ETH transfers
+	BlockNumber uint64
+	BlockHash   string
+	foundAt     time.Time
+	donoID      int // dono the transfer was matched to, 0 while unclaimed
+}
+
+// savedTransfer is a Transfer as kept in the cursors.
+type savedTransfer struct {
+	Transfer
+	FoundAt time.Time
+	DonoID  int
+}
+
+// Provider accepts the native coin and ERC-20 tokens of one EVM chain sent
+// to each user's address on it. It follows the chain block by block over
+// plain JSON-RPC: block transactions for the native coin and eth_getLogs for
+// token Transfer events.
+type Provider struct {
+	cfg     Config
+	client  *Client
+	cursors payments.Cursors
+	amounts *payments.Amounts
+
+	mu        sync.Mutex
+	addresses map[int]string // user ID -> watched address, lowercased
+	transfers []Transfer
+	head      uint64
+	hashes    map[uint64]string // hashes of recently scanned blocks, to find reorgs
+}
+
+// New returns a provider for the chain in cfg. The last scanned block and
+// the transfers found are kept in cursors. Amounts are fuzzed clear of the
+// ones pending donos wait for.
+func New(cfg Config, cursors payments.Cursors, pending payments.PendingDonos) *Provider {
+	return &Provider{
+		cfg:       cfg,
+		client:    NewClient(cfg.RPCURL),
+		cursors:   cursors,
+		amounts:   payments.NewAmounts(pending),
+		addresses: make(map[int]string),
+		hashes:    make(map[uint64]string),
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Chain
+}
+
+func (p *Provider) Currencies() []string {
+	return utils.CurrencyCodesForChain(p.cfg.Chain)
+}
+
+// native returns the code of the chain's own coin.
+func (p *Provider) native() string {
+	for _, c := range utils.Currencies {
+		if c.Chain == p.cfg.Chain && c.Contract == "" {
+			return c.Code
+		}
+	}
+	return ""
+}
+
+// Start watches the users' addresses from the last scanned block on, with
+// the transfers found before.
+func (p *Provider) Start(users []utils.User) {
+	for _, user := range users {
+		p.SetUser(user)
+	}
+	if err := p.loadTransfers(); err != nil {
+		log.Println(p.cfg.Chain, "error loading saved transfers:", err)
+	}
+	go p.run()
+}
+
+func (p *Provider) SetUser(user utils.User) {
+	address := UserAddress(user, p.cfg.ChainID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if address == "" {
+		delete(p.addresses, user.UserID)
+		return
+	}
+	p.addresses[user.UserID] = normalizeAddress(address)
+}
+
+func (p *Provider) CreatePaymentRequest(user utils.User, currency string, amount float64) (payments.PaymentRequest, error) {
+	c, ok := utils.GetCurrency(currency)
+	if !ok || c.Chain != p.cfg.Chain {
+		return payments.PaymentRequest{}, fmt.Errorf("%s is not paid on %s", currency, p.cfg.Chain)
+	}
+	address := UserAddress(user, p.cfg.ChainID)
+	if address == "" {
+		return payments.PaymentRequest{}, fmt.Errorf("user %d has no address on %s", user.UserID, p.cfg.Chain)
+	}
+
+	atomic, err := p.amounts.Fuzz(utils.AtomicFromFloat(amount, c.Code), c.Code, address)
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+	donoStr := utils.FormatAtomic(atomic, c.Code)
+	log.Println(p.cfg.Chain, "CreatePaymentRequest() donoStr:", donoStr)
+
+	contract := c.Code
+	if c.Contract != "" {
+		contract = c.Contract
+	}
+
+	req := payments.PaymentRequest{
+		Currency:        c.Code,
+		Address:         address,
+		PayID:           address,
+		Amount:          donoStr,
+		Atomic:          atomic,
+		ContractAddress: contract,
+		URI:             payments.EthereumURI(address, c.Contract, p.cfg.ChainID, atomic),
+	}
+	return req, nil
+}
+
+// Poll does nothing, the chain is followed in the background since Start.
+func (p *Provider) Poll(donos []utils.Dono) error {
+	return nil
+}
+
+func (p *Provider) Match(dono utils.Dono) (payments.Match, error) {
+	address := normalizeAddress(dono.Address)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.transfers {
+		if t.To != address || t.Currency != dono.CurrencyType {
+			continue
+		}
+		if t.Value.Cmp(dono.AtomicToSend) != 0 {
+			continue
+		}
+		if t.donoID != 0 && t.donoID != dono.ID {
+			continue // already paid another dono
+		}
+		p.transfers[i].donoID = dono.ID
+		confirmations := 0
+		if p.head >= t.BlockNumber {
+			confirmations = int(p.head - t.BlockNumber + 1)
+		}
+		log.Println("Matching TX!", t.TxHash, "confirmations:", confirmations)
+		return payments.Match{
+			Found:         uint64(confirmations) >= p.cfg.Confirmations,
+			Seen:          true,
+			AmountSent:    new(big.Int).Set(t.Value),
+			Confirmations: confirmations,
+			BlockNumber:   t.BlockNumber,
+			BlockHash:     t.BlockHash,
+			TxHash:        t.TxHash,
+			LogIndex:      t.LogIndex,
+		}, nil
+	}
+	return payments.Match{}, nil
+}
+
+// Transactions returns the transfers found so far in the shape the billing
+// checks use.
+func (p *Provider) Transactions() []utils.Transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	transfers := make([]utils.Transfer, 0, len(p.transfers))
+	for _, t := range p.transfers {
+		contract := ""
+		if c, ok := utils.GetCurrency(t.Currency); ok {
+			contract = c.Contract
+		}
+		transfers = append(transfers, utils.Transfer{
+			BlockNum:    quantity(t.BlockNumber),
+			Hash:        t.TxHash,
+			From:        t.From,
+			To:          t.To,
+			Asset:       t.Currency,
+			RawContract: utils.RawContract{Value: "0x" + t.Value.Text(16), Address: contract},
+		})
+	}
+	return transfers
+}
+
+func (p *Provider) run() {
+	for {
+		if err := p.scan(); err != nil {
+			log.Println(p.cfg.Chain, "scan error:", err)
+		}
+		time.Sleep(p.cfg.PollInterval)
+	}
+}
+
+// scan processes the blocks after the saved cursor, up to MaxBlocks at a
+// time, and saves the cursor once all of them have been read.
+func (p *Provider) scan() error {
+	head, err := p.client.BlockNumber()
+	if err != nil {
+		return err
+	}
+
+	var last uint64
+	var lastHash string
+	saved, ok := p.cursors.Cursor(p.cursorKey())
+	if ok {
+		number, hash, _ := strings.Cut(saved, ":")
+		lastHash = hash
+		last, err = strconv.ParseUint(number, 10, 64)
+	}
+	if !ok || err != nil {
+		last = 0
+		lastHash = ""
+		if head > p.cfg.StartBack {
+			last = head - p.cfg.StartBack
+		}
+	}
+
+	if lastHash != "" {
+		fork, err := p.findFork(last, lastHash)
+		if err != nil {
+			return err
+		}
+		if fork < last {
+			log.Println(p.cfg.Chain, "reorg: blocks after", fork, "were replaced, rescanning them")
+			p.rollback(fork)
+			if err := p.saveTransfers(); err != nil {
+				return err
+			}
+			last = fork
+		}
+	}
+
+	p.mu.Lock()
+	p.head = head
+	p.mu.Unlock()
+
+	if last >= head {
+		return nil
+	}
+	to := head
+	if to-last > p.cfg.MaxBlocks {
+		to = last + p.cfg.MaxBlocks
+	}
+
+	watched := p.watched()
+	found := []Transfer{}
+	if len(watched) > 0 {
+		native, err := p.scanBlocks(last+1, to, watched)
+		if err != nil {
+			return err
+		}
+		tokens, err := p.scanLogs(last+1, to, watched)
+		if err != nil {
+			return err
+		}
+		found = append(native, tokens...)
+	}
+
+	toHash, err := p.blockHash(to)
+	if err != nil {
+		return err
+	}
+
+	// the transfers are saved before the cursor moves past their blocks
+	p.addTransfers(found)
+	if err := p.saveTransfers(); err != nil {
+		return err
+	}
+	return p.cursors.SetCursor(p.cursorKey(), strconv.FormatUint(to, 10)+":"+toHash)
+}
+
+// saveTransfers writes the transfers kept for matching to the cursors.
+func (p *Provider) saveTransfers() error {
+	p.mu.Lock()
+	saved := make([]savedTransfer, 0, len(p.transfers))
+	for _, t := range p.transfers {
+		saved = append(saved, savedTransfer{Transfer: t, FoundAt: t.foundAt, DonoID: t.donoID})
+	}
+	p.mu.Unlock()
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return p.cursors.SetCursor(p.transfersKey(), string(data))
+}
+
+// loadTransfers reads back the transfers saveTransfers wrote.
+func (p *Provider) loadTransfers() error {
+	data, ok := p.cursors.Cursor(p.transfersKey())
+	if !ok {
+		return nil
+	}
+	var saved []savedTransfer
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transfers = p.transfers[:0]
+	for _, s := range saved {
+		t := s.Transfer
+		t.foundAt = s.FoundAt
+		t.donoID = s.DonoID
+		p.transfers = append(p.transfers, t)
+	}
+	return nil
+}
+
+// findFork returns the highest block, from last down, whose hash is still
+// the one scanned. If the fork is older than the hashes remembered, the
+// whole confirmation window is rescanned.
+func (p *Provider) findFork(last uint64, lastHash string) (uint64, error) {
+	n := last
+	known := lastHash
+	for depth := 0; depth < maxReorgDepth && known != ""; depth++ {
+		header, err := p.client.HeaderByNumber(n)
+		if err != nil {
+			return 0, err
+		}
+		if strings.EqualFold(header.Hash, known) {
+			return n, nil
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		n--
+		p.mu.Lock()
+		known = p.hashes[n]
+		p.mu.Unlock()
+	}
+	if last > p.cfg.Confirmations {
+		return last - p.cfg.Confirmations, nil
+	}
+	return 0, nil
+}
+
+// rollback forgets transfers and block hashes after a fork, so the dono
+// they matched goes back to pending until the transfer shows up again.
+func (p *Provider) rollback(fork uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	kept := p.transfers[:0]
+	for _, t := range p.transfers {
+		if t.BlockNumber > fork {
+			log.Println(p.cfg.Chain, "transfer", t.TxHash, "in block", t.BlockNumber, "was reorged out")
+			continue
+		}
+		kept = append(kept, t)
+	}
+	p.transfers = kept
+	for n := range p.hashes {
+		if n > fork {
+			delete(p.hashes, n)
+		}
+	}
+}
+
+// blockHash returns the hash of a scanned block, fetching the header if the
+// block itself wasn't read.
+func (p *Provider) blockHash(n uint64) (string, error) {
+	p.mu.Lock()
+	hash, ok := p.hashes[n]
+	p.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+	header, err := p.client.HeaderByNumber(n)
+	if err != nil {
+		return "", err
+	}
+	p.rememberHash(n, header.Hash)
+	return header.Hash, nil
+}
+
+// rememberHash keeps the hash of a scanned block for maxReorgDepth blocks.
+func (p *Provider) rememberHash(n uint64, hash string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hashes[n] = hash
+	for old := range p.hashes {
+		if old+maxReorgDepth < n {
+			delete(p.hashes, old)
+		}
+	}
+}
+
+// scanBlocks finds the native coin sent to watched addresses by reading
+// every transaction of every block. Coins moved by contracts (internal
+// transfers) are not seen.
+func (p *Provider) scanBlocks(from, to uint64, watched map[string]bool) ([]Transfer, error) {
+	native := p.native()
+	found := []Transfer{}
+	for n := from; n <= to; n++ {
+		block, err := p.client.BlockByNumber(n)
+		if err != nil {
+			return nil, err
+		}
+		p.rememberHash(n, block.Hash)
+		for _, tx := range block.Transactions {
+			if tx.To == "" || !watched[normalizeAddress(tx.To)] {
+				continue
+			}
+			value, ok := utils.ParseHexAtomic(tx.Value)
+			if !ok || value.Sign() == 0 {
+				continue
+			}
+			found = append(found, Transfer{
+				Currency:    native,
+				From:        normalizeAddress(tx.From),
+				To:          normalizeAddress(tx.To),
+				Value:       value,
+				TxHash:      tx.Hash,
+				LogIndex:    -1,
+				BlockNumber: n,
+				BlockHash:   block.Hash,
+			})
+		}
+	}
+	return found, nil
+}
+
+// scanLogs finds ERC-20 Transfer events of the registry's tokens to watched
+// addresses.
+func (p *Provider) scanLogs(from, to uint64, watched map[string]bool) ([]Transfer, error) {
+	contracts := tokenContracts(p.cfg.Chain)
+	if len(contracts) == 0 {
+		return nil, nil
+	}
+	recipients := make([]string, 0, len(watched))
+	for address := range watched {
+		recipients = append(recipients, address)
+	}
+
+	logs, err := p.client.TransferLogs(from, to, contracts, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Transfer{}
+	for _, l := range logs {
+		if l.Removed || len(l.Topics) != 3 || !strings.EqualFold(l.Topics[0], TransferTopic) {
+			continue
+		}
+		currency, ok := utils.CurrencyByContract(p.cfg.Chain, l.Address)
+		if !ok {
+			continue
+		}
+		recipient := topicAddress(l.Topics[2])
+		if !watched[recipient] {
+			continue
+		}
+		value, ok := utils.ParseHexAtomic(l.Data)
+		if !ok || value.Sign() == 0 {
+			continue
+		}
+		blockNumber, err := parseQuantity(l.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		logIndex, err := parseQuantity(l.LogIndex)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, Transfer{
+			Currency:    currency.Code,
+			From:        topicAddress(l.Topics[1]),
+			To:          recipient,
+			Value:       value,
+			TxHash:      l.TransactionHash,
+			LogIndex:    int(logIndex),
+			BlockNumber: blockNumber,
+			BlockHash:   l.BlockHash,
+		})
+	}
+	return found, nil
+}
+
+// addTransfers keeps newly found transfers, skipping ones already known,
+// and forgets those older than KeepFor.
+func (p *Provider) addTransfers(found []Transfer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known := make(map[string]bool, len(p.transfers))
+	kept := p.transfers[:0]
+	for _, t := range p.transfers {
+		if time.Since(t.foundAt) > p.cfg.KeepFor {
+			continue
+		}
+		kept = append(kept, t)
+		known[t.TxHash+":"+strconv.Itoa(t.LogIndex)] = true
+	}
+	for _, t := range found {
+		key := t.TxHash + ":" + strconv.Itoa(t.LogIndex)
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		t.foundAt = time.Now()
+		log.Println(p.cfg.Chain, "transfer found:", utils.FormatAtomic(t.Value, t.Currency), t.Currency, "to", t.To, "in", t.TxHash)
+		kept = append(kept, t)
+	}
+	p.transfers = kept
+}
+
+func (p *Provider) watched() map[string]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	watched := make(map[string]bool, len(p.addresses))
+	for _, address := range p.addresses {
+		watched[address] = true
+	}
+	return watched
+}
+
+// tokenContracts returns the contract of every ERC-20 of a chain in the
+// registry.
+func tokenContracts(chain string) []string {
+	contracts := []string{}
+	for _, c := range utils.Currencies {
+		if c.Chain == chain && c.Contract != "" {
+			contracts = append(contracts, normalizeAddress(c.Contract))
+		}
+	}
+	return contracts
+}
+
+func normalizeAddress(address string) string {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if !strings.HasPrefix(address, "0x") {
+		address = "0x" + address
+	}
+	return address
+}
@@ -0,0 +1,410 @@
+package eth
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"shadowchat/payments/paymentstest"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	watchedAddress = "0x1111111111111111111111111111111111111111"
+	donorAddress   = "0x2222222222222222222222222222222222222222"
+	otherAddress   = "0x3333333333333333333333333333333333333333"
+	usdcContract   = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	usdtContract   = "0xdAC17F958D2ee523a2206206994597C13D831ec7"
+)
+
+// fakeChain is a JSON-RPC stand-in serving a chain of blocks that tests can
+// extend and reorg.
+type fakeChain struct {
+	mu     sync.Mutex
+	blocks []RPCBlock // by number
+	logs   []RPCLog   // ERC-20 Transfer events, served by eth_getLogs
+	fork   int        // bumped on every reorg, so replaced blocks get new hashes
+}
+
+func newFakeChain(t *testing.T, n int) (*fakeChain, *httptest.Server) {
+	c := &fakeChain{}
+	c.extend(n)
+	srv := httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+// extend adds n empty blocks.
+func (c *fakeChain) extend(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < n; i++ {
+		number := len(c.blocks)
+		c.blocks = append(c.blocks, RPCBlock{
+			Number: quantity(uint64(number)),
+			Hash:   fmt.Sprintf("0x%064x", c.fork*1000000+number+1),
+		})
+	}
+}
+
+// pay adds an ETH transfer to the watched address in a block.
+func (c *fakeChain) pay(block int, wei int64, txHash string) {
+	c.payTo(block, watchedAddress, wei, txHash)
+}
+
+// payTo adds an ETH transfer to an address in a block.
+func (c *fakeChain) payTo(block int, to string, wei int64, txHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks[block].Transactions = append(c.blocks[block].Transactions, RPCTransaction{
+		Hash:  txHash,
+		From:  donorAddress,
+		To:    to,
+		Value: "0x" + strconv.FormatInt(wei, 16),
+	})
+}
+
+// payToken adds an ERC-20 Transfer event of a token contract to an address
+// in a block.
+func (c *fakeChain) payToken(block int, contract, to string, value int64, txHash string, logIndex int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, RPCLog{
+		Address:         normalizeAddress(contract),
+		Topics:          []string{TransferTopic, addressTopic(donorAddress), addressTopic(to)},
+		Data:            fmt.Sprintf("0x%064x", value),
+		BlockNumber:     quantity(uint64(block)),
+		BlockHash:       c.blocks[block].Hash,
+		TransactionHash: txHash,
+		LogIndex:        quantity(uint64(logIndex)),
+	})
+}
+
+// getLogs returns the logs matching an eth_getLogs filter as built by
+// TransferLogs.
+func (c *fakeChain) getLogs(param json.RawMessage) []RPCLog {
+	var filter struct {
+		FromBlock string            `json:"fromBlock"`
+		ToBlock   string            `json:"toBlock"`
+		Address   []string          `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	}
+	json.Unmarshal(param, &filter)
+	from, _ := parseQuantity(filter.FromBlock)
+	to, _ := parseQuantity(filter.ToBlock)
+	var recipients []string
+	if len(filter.Topics) == 3 {
+		json.Unmarshal(filter.Topics[2], &recipients)
+	}
+
+	logs := []RPCLog{}
+	for _, l := range c.logs {
+		n, _ := parseQuantity(l.BlockNumber)
+		if n < from || n > to || !contains(filter.Address, l.Address) || !contains(recipients, l.Topics[2]) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	return logs
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// reorg replaces the blocks from a number on with empty ones, dropping their
+// logs.
+func (c *fakeChain) reorg(from int) {
+	c.mu.Lock()
+	n := len(c.blocks) - from
+	c.blocks = c.blocks[:from]
+	logs := c.logs[:0]
+	for _, l := range c.logs {
+		if number, _ := parseQuantity(l.BlockNumber); number < uint64(from) {
+			logs = append(logs, l)
+		}
+	}
+	c.logs = logs
+	c.fork++
+	c.mu.Unlock()
+	c.extend(n)
+}
+
+func (c *fakeChain) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int64             `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result interface{}
+	switch req.Method {
+	case "eth_blockNumber":
+		result = quantity(uint64(len(c.blocks) - 1))
+	case "eth_getBlockByNumber":
+		var number string
+		var full bool
+		json.Unmarshal(req.Params[0], &number)
+		json.Unmarshal(req.Params[1], &full)
+		n, _ := parseQuantity(number)
+		if n >= uint64(len(c.blocks)) {
+			break
+		}
+		block := c.blocks[n]
+		if !full {
+			block.Transactions = nil
+		}
+		result = block
+	case "eth_getLogs":
+		result = c.getLogs(req.Params[0])
+	default:
+		http.Error(w, "unknown method "+req.Method, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+func newTestProvider(url string, cursors *paymentstest.MemCursors) *Provider {
+	cfg := DefaultConfig()
+	cfg.RPCURL = url
+	cfg.StartBack = 5
+	cfg.Confirmations = 3
+	p := New(cfg, cursors, nil)
+	p.SetUser(utils.User{UserID: 1, EthAddress: watchedAddress})
+	return p
+}
+
+func testDono(wei int64) utils.Dono {
+	return utils.Dono{ID: 7, Address: watchedAddress, CurrencyType: "ETH", AtomicToSend: big.NewInt(wei)}
+}
+
+func TestScanResumesFromCursor(t *testing.T) {
+	chain, srv := newFakeChain(t, 30)
+	chain.pay(8, 1000, "0xbefore")
+	chain.pay(12, 2000, "0xafter")
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "10:" + chain.blocks[10].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, _ := p.Match(testDono(1000)); m.Seen {
+		t.Error("transfer before the cursor was scanned")
+	}
+	m, err := p.Match(testDono(2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Seen || m.TxHash != "0xafter" || m.BlockNumber != 12 {
+		t.Errorf("transfer after the cursor not matched: %+v", m)
+	}
+	if want := "29:" + chain.blocks[29].Hash; cursors.Get("ethereum:last_block") != want {
+		t.Errorf("cursor = %q, want %q", cursors.Get("ethereum:last_block"), want)
+	}
+}
+
+func TestScanStartsBackWithoutCursor(t *testing.T) {
+	chain, srv := newFakeChain(t, 30)
+	chain.pay(20, 1000, "0xold")
+	chain.pay(26, 2000, "0xnew")
+
+	p := newTestProvider(srv.URL, paymentstest.NewMemCursors(nil))
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := p.Match(testDono(1000)); m.Seen {
+		t.Error("transfer older than StartBack was scanned")
+	}
+	if m, _ := p.Match(testDono(2000)); !m.Seen {
+		t.Error("transfer within StartBack not matched")
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.pay(19, 1000, "0xtx")
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := p.Match(testDono(1000))
+	if !m.Seen || m.Found || m.Confirmations != 1 {
+		t.Fatalf("at the head: %+v, want seen with 1 confirmation", m)
+	}
+
+	chain.extend(2)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = p.Match(testDono(1000))
+	if !m.Found || m.Confirmations != 3 {
+		t.Fatalf("2 blocks later: %+v, want found with 3 confirmations", m)
+	}
+}
+
+func TestReorgRollsBackTransfers(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.pay(18, 1000, "0xtx")
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := p.Match(testDono(1000)); !m.Seen {
+		t.Fatal("transfer not matched before the reorg")
+	}
+
+	chain.reorg(17)
+	fork, err := p.findFork(19, "stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork != 16 {
+		t.Errorf("findFork = %d, want 16", fork)
+	}
+
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := p.Match(testDono(1000)); m.Seen {
+		t.Errorf("transfer reorged out is still matched: %+v", m)
+	}
+	if want := "19:" + chain.blocks[19].Hash; cursors.Get("ethereum:last_block") != want {
+		t.Errorf("cursor = %q, want %q", cursors.Get("ethereum:last_block"), want)
+	}
+}
+
+func TestRestartKeepsTransfers(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.pay(18, 1000, "0xtx")
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := p.Match(testDono(1000)); !m.Seen {
+		t.Fatal("transfer not matched before the restart")
+	}
+
+	restarted := newTestProvider(srv.URL, cursors)
+	if err := restarted.loadTransfers(); err != nil {
+		t.Fatal(err)
+	}
+	chain.extend(1)
+	if err := restarted.scan(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := restarted.Match(testDono(1000))
+	if !m.Found || m.TxHash != "0xtx" || m.BlockHash != chain.blocks[18].Hash {
+		t.Errorf("transfer found before the restart not matched after it: %+v", m)
+	}
+}
+
+func TestTokenTransferMatches(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.payToken(17, usdcContract, watchedAddress, 5000000, "0xtoken", 4)
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	dono := utils.Dono{ID: 7, Address: watchedAddress, CurrencyType: "USDC", AtomicToSend: big.NewInt(5000000)}
+	if m, _ := p.Match(testDono(5000000)); m.Seen {
+		t.Errorf("token transfer matched an ETH dono: %+v", m)
+	}
+	m, err := p.Match(dono)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Found || m.TxHash != "0xtoken" || m.LogIndex != 4 || m.Confirmations != 3 || m.BlockNumber != 17 {
+		t.Errorf("token transfer: %+v, want found in 0xtoken log 4 with 3 confirmations", m)
+	}
+}
+
+func TestOtherAddressDoesNotMatch(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.payTo(17, otherAddress, 1000, "0xother")
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	p.SetUser(utils.User{UserID: 2, EthAddress: otherAddress})
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, _ := p.Match(testDono(1000)); m.Seen {
+		t.Errorf("transfer to another address matched: %+v", m)
+	}
+	other := utils.Dono{ID: 8, Address: otherAddress, CurrencyType: "ETH", AtomicToSend: big.NewInt(1000)}
+	if m, _ := p.Match(other); !m.Seen || m.TxHash != "0xother" {
+		t.Errorf("transfer not matched to the dono of its address: %+v", m)
+	}
+}
+
+func TestOtherTokenDoesNotMatch(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.payToken(17, usdtContract, watchedAddress, 5000000, "0xusdt", 0)
+	chain.payToken(17, "0x4444444444444444444444444444444444444444", watchedAddress, 5000000, "0xunknown", 1)
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	usdc := utils.Dono{ID: 7, Address: watchedAddress, CurrencyType: "USDC", AtomicToSend: big.NewInt(5000000)}
+	if m, _ := p.Match(usdc); m.Seen {
+		t.Errorf("transfer of another token matched a USDC dono: %+v", m)
+	}
+	usdt := utils.Dono{ID: 8, Address: watchedAddress, CurrencyType: "USDT", AtomicToSend: big.NewInt(5000000)}
+	if m, _ := p.Match(usdt); !m.Seen || m.TxHash != "0xusdt" {
+		t.Errorf("USDT transfer not matched to the USDT dono: %+v", m)
+	}
+}
+
+func TestTransferPaysOneDono(t *testing.T) {
+	chain, srv := newFakeChain(t, 20)
+	chain.pay(17, 1000, "0xtx")
+
+	cursors := paymentstest.NewMemCursors(map[string]string{"ethereum:last_block": "15:" + chain.blocks[15].Hash})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	a := testDono(1000)
+	if m, _ := p.Match(a); !m.Found {
+		t.Fatalf("transfer not matched to the first dono: %+v", m)
+	}
+	b := testDono(1000)
+	b.ID = 8
+	if m, _ := p.Match(b); m.Seen {
+		t.Errorf("transfer matched to dono %d was matched again to dono %d: %+v", a.ID, b.ID, m)
+	}
+	if m, _ := p.Match(a); !m.Found {
+		t.Errorf("transfer no longer matched to its own dono: %+v", m)
+	}
+}
@@ -0,0 +1,173 @@
+package eth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"shadowchat/utils"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Client talks to any standard Ethereum JSON-RPC endpoint.
+type Client struct {
+	URL  string
+	http *http.Client
+	id   int64
+}
+
+// NewClient returns a client for the JSON-RPC endpoint at url.
+func NewClient(url string) *Client {
+	return &Client{URL: url, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *Client) call(method string, params []interface{}, out interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      atomic.AddInt64(&c.id, 1),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.http.Post(c.URL, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: non-200 response code received: %d", method, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: rpc error %d: %s", method, resp.Error.Code, resp.Error.Message)
+	}
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return fmt.Errorf("%s: empty result", method)
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// RPCTransaction is a transaction as returned inside eth_getBlockByNumber.
+type RPCTransaction struct {
+	Hash  string `json:"hash"`
+	From  string `json:"from"`
+	To    string `json:"to"` // empty for contract creation
+	Value string `json:"value"`
+}
+
+// RPCBlock is a block as returned by eth_getBlockByNumber with full
+// transactions.
+type RPCBlock struct {
+	Number       string           `json:"number"`
+	Hash         string           `json:"hash"`
+	ParentHash   string           `json:"parentHash"`
+	Timestamp    string           `json:"timestamp"`
+	Transactions []RPCTransaction `json:"transactions"` // empty for HeaderByNumber
+}
+
+// RPCLog is an entry of an eth_getLogs response.
+type RPCLog struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     string   `json:"blockNumber"`
+	BlockHash       string   `json:"blockHash"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+	Removed         bool     `json:"removed"`
+}
+
+// BlockNumber returns the number of the most recent block.
+func (c *Client) BlockNumber() (uint64, error) {
+	var result string
+	if err := c.call("eth_blockNumber", nil, &result); err != nil {
+		return 0, err
+	}
+	return parseQuantity(result)
+}
+
+// BlockByNumber returns a block with its full transactions.
+func (c *Client) BlockByNumber(number uint64) (RPCBlock, error) {
+	var block RPCBlock
+	err := c.call("eth_getBlockByNumber", []interface{}{quantity(number), true}, &block)
+	return block, err
+}
+
+// HeaderByNumber returns a block without its transactions.
+func (c *Client) HeaderByNumber(number uint64) (RPCBlock, error) {
+	var block RPCBlock
+	err := c.call("eth_getBlockByNumber", []interface{}{quantity(number), false}, &block)
+	return block, err
+}
+
+// TransferLogs returns the ERC-20 Transfer events emitted by the given
+// token contracts to any of the given addresses, between two blocks
+// inclusive.
+func (c *Client) TransferLogs(from, to uint64, contracts []string, recipients []string) ([]RPCLog, error) {
+	topics := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		topics = append(topics, addressTopic(r))
+	}
+	filter := map[string]interface{}{
+		"fromBlock": quantity(from),
+		"toBlock":   quantity(to),
+		"address":   contracts,
+		"topics":    []interface{}{TransferTopic, nil, topics},
+	}
+	var logs []RPCLog
+	err := c.call("eth_getLogs", []interface{}{filter}, &logs)
+	return logs, err
+}
+
+// TransferTopic is keccak256("Transfer(address,address,uint256)").
+const TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// addressTopic left-pads an address to a 32 byte topic.
+func addressTopic(address string) string {
+	return "0x000000000000000000000000" + normalizeAddress(address)[2:]
+}
+
+// topicAddress reads the address out of a 32 byte topic.
+func topicAddress(topic string) string {
+	if len(topic) < 40 {
+		return ""
+	}
+	return normalizeAddress("0x" + topic[len(topic)-40:])
+}
+
+func quantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+func parseQuantity(s string) (uint64, error) {
+	n, ok := utils.ParseHexAtomic(s)
+	if !ok || !n.IsUint64() {
+		return 0, fmt.Errorf("bad quantity %q", s)
+	}
+	return n.Uint64(), nil
+}
@@ -0,0 +1,182 @@
+package ln
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cln talks to Core Lightning's REST plugin (clnrest) with a rune, which
+// should be restricted to the invoice, listinvoices and waitanyinvoice
+// methods.
+type cln struct {
+	url  string
+	rune string
+	http *http.Client
+}
+
+func newCLN(url string, rune string, client *http.Client) *cln {
+	return &cln{url: url, rune: rune, http: client}
+}
+
+// msat reads an amount that newer nodes send as a number and older ones as
+// a string like "1000msat".
+type msat uint64
+
+func (m *msat) UnmarshalJSON(data []byte) error {
+	s := strings.TrimSuffix(strings.Trim(string(data), `"`), "msat")
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("amount %s: %v", data, err)
+	}
+	*m = msat(n)
+	return nil
+}
+
+type clnInvoice struct {
+	PaymentHash        string `json:"payment_hash"`
+	Status             string `json:"status"` // unpaid, paid or expired
+	AmountReceivedMsat msat   `json:"amount_received_msat"`
+	PayIndex           uint64 `json:"pay_index"`
+}
+
+func (i clnInvoice) state() InvoiceState {
+	return InvoiceState{
+		PaymentHash: i.PaymentHash,
+		Settled:     i.Status == "paid",
+		PaidMsat:    uint64(i.AmountReceivedMsat),
+		SettleIndex: i.PayIndex,
+	}
+}
+
+func (n *cln) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url+"/v1/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Rune", n.rune)
+	res, err := n.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		var rpcErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &rpcErr) == nil && rpcErr.Message != "" {
+			return fmt.Errorf("cln %s: rpc error %d: %s", method, rpcErr.Code, rpcErr.Message)
+		}
+		return fmt.Errorf("cln %s: non-200 response code received: %d", method, res.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// CreateInvoice labels the invoice with a random label, as labels have to
+// be unique and the dono doesn't exist yet.
+func (n *cln) CreateInvoice(amountMsat uint64, memo string, expiry time.Duration) (Invoice, error) {
+	label := make([]byte, 16)
+	if _, err := rand.Read(label); err != nil {
+		return Invoice{}, err
+	}
+	params := map[string]interface{}{
+		"amount_msat": amountMsat,
+		"label":       "shadowchat-" + hex.EncodeToString(label),
+		"description": memo,
+		"expiry":      int(expiry.Seconds()),
+	}
+	var resp struct {
+		PaymentHash string `json:"payment_hash"`
+		BOLT11      string `json:"bolt11"`
+		ExpiresAt   int64  `json:"expires_at"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := n.call(ctx, "invoice", params, &resp); err != nil {
+		return Invoice{}, err
+	}
+	if resp.BOLT11 == "" {
+		return Invoice{}, fmt.Errorf("cln returned no bolt11 invoice")
+	}
+	return Invoice{PaymentHash: resp.PaymentHash, BOLT11: resp.BOLT11, ExpiresAt: time.Unix(resp.ExpiresAt, 0)}, nil
+}
+
+func (n *cln) Lookup(paymentHash string) (InvoiceState, error) {
+	var resp struct {
+		Invoices []clnInvoice `json:"invoices"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := n.call(ctx, "listinvoices", map[string]string{"payment_hash": paymentHash}, &resp); err != nil {
+		return InvoiceState{}, err
+	}
+	if len(resp.Invoices) == 0 {
+		return InvoiceState{}, fmt.Errorf("cln has no invoice %s", paymentHash)
+	}
+	return resp.Invoices[0].state(), nil
+}
+
+// Subscribe calls waitanyinvoice over and over, each call returning the
+// next invoice paid after the pay index given. With no index it starts
+// after the last invoice paid so far, as waitanyinvoice would otherwise go
+// through every invoice the node was ever paid.
+func (n *cln) Subscribe(ctx context.Context, settleIndex uint64, settled func(InvoiceState)) error {
+	if settleIndex == 0 {
+		last, err := n.lastPayIndex(ctx)
+		if err != nil {
+			return err
+		}
+		settleIndex = last
+	}
+	for {
+		params := map[string]interface{}{}
+		if settleIndex > 0 {
+			params["lastpay_index"] = settleIndex
+		}
+		var invoice clnInvoice
+		if err := n.call(ctx, "waitanyinvoice", params, &invoice); err != nil {
+			return err
+		}
+		if invoice.PayIndex <= settleIndex {
+			return fmt.Errorf("cln waitanyinvoice went back to pay index %d", invoice.PayIndex)
+		}
+		settleIndex = invoice.PayIndex
+		settled(invoice.state())
+	}
+}
+
+// lastPayIndex returns the pay index of the last invoice paid, 0 if none
+// was.
+func (n *cln) lastPayIndex(ctx context.Context) (uint64, error) {
+	var resp struct {
+		Invoices []clnInvoice `json:"invoices"`
+	}
+	if err := n.call(ctx, "listinvoices", map[string]string{}, &resp); err != nil {
+		return 0, err
+	}
+	var last uint64
+	for _, invoice := range resp.Invoices {
+		if invoice.PayIndex > last {
+			last = invoice.PayIndex
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,282 @@
+package ln
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config says how Lightning invoices are made and followed.
+type Config struct {
+	InvoiceExpiry time.Duration // how long a dono's invoice, and so the dono, can be paid
+	MaxBackoff    time.Duration // longest wait before resubscribing to a node that failed
+	KeepFor       time.Duration // how long settled invoices pushed by the nodes are kept
+
+	// AllowPrivateNodes lets nodes be plain http and on loopback or private
+	// addresses, for servers whose streamers are trusted, such as one
+	// streamer running the server next to their own node.
+	AllowPrivateNodes bool
+
+	// OpenSecret unseals a stored macaroon or rune. Nil if they are stored
+	// as they are.
+	OpenSecret func(stored string) (string, error)
+}
+
+// DefaultConfig gives donors half an hour to pay an invoice.
+func DefaultConfig() Config {
+	return Config{
+		InvoiceExpiry: 30 * time.Minute,
+		MaxBackoff:    5 * time.Minute,
+		KeepFor:       24 * time.Hour,
+	}
+}
+
+// nodeConn is a user's node and the subscription to its settled invoices.
+type nodeConn struct {
+	settings string // what the node was made from, to notice changes
+	node     Node
+	cancel   context.CancelFunc
+	live     bool
+}
+
+type settledInvoice struct {
+	state InvoiceState
+	at    time.Time
+}
+
+// Provider accepts Lightning payments to each streamer's own node. Every
+// dono gets a BOLT11 invoice from the node, and the node's settled invoices
+// are subscribed to, so a payment is known the moment it settles.
+type Provider struct {
+	cfg     Config
+	cursors payments.Cursors
+
+	mu      sync.Mutex
+	nodes   map[int]*nodeConn         // by user ID
+	settled map[string]settledInvoice // pushed by the subscriptions, by payment hash
+}
+
+// New returns a Lightning provider. The settle index each node's
+// subscription has reached is kept in cursors.
+func New(cfg Config, cursors payments.Cursors) *Provider {
+	return &Provider{
+		cfg:     cfg,
+		cursors: cursors,
+		nodes:   make(map[int]*nodeConn),
+		settled: make(map[string]settledInvoice),
+	}
+}
+
+func (p *Provider) Name() string {
+	return "lightning"
+}
+
+func (p *Provider) Currencies() []string {
+	return utils.CurrencyCodesForChain(utils.ChainLightning)
+}
+
+func (p *Provider) Start(users []utils.User) {
+	for _, user := range users {
+		p.SetUser(user)
+	}
+}
+
+// SetUser connects to the user's node, or reconnects if its settings
+// changed, and subscribes to its settled invoices.
+func (p *Provider) SetUser(user utils.User) {
+	settings := strings.Join([]string{user.LNKind, user.LNURL, user.LNCredential, user.LNCert}, "\x00")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old, ok := p.nodes[user.UserID]; ok {
+		if old.settings == settings {
+			return
+		}
+		old.cancel()
+		delete(p.nodes, user.UserID)
+	}
+	if user.LNURL == "" || user.LNCredential == "" {
+		return
+	}
+
+	credential := user.LNCredential
+	if p.cfg.OpenSecret != nil {
+		var err error
+		if credential, err = p.cfg.OpenSecret(user.LNCredential); err != nil {
+			log.Println("lightning: can't open the credential of user", user.UserID, err)
+			return
+		}
+	}
+	node, err := NewNode(user.LNKind, user.LNURL, credential, user.LNCert, p.cfg.AllowPrivateNodes)
+	if err != nil {
+		log.Println("lightning: not connecting to the node of user", user.UserID, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &nodeConn{settings: settings, node: node, cancel: cancel}
+	p.nodes[user.UserID] = conn
+	go p.subscribe(ctx, user, conn)
+}
+
+// CheckNode tells whether a node can be connected with the given settings,
+// before they are saved.
+func (p *Provider) CheckNode(kind string, rawURL string, credential string, certPEM string) error {
+	_, err := NewNode(kind, rawURL, credential, certPEM, p.cfg.AllowPrivateNodes)
+	return err
+}
+
+// The settle index is saved per node, so a new node starts over.
+func cursorKey(user utils.User) string {
+	return "lightning:" + strconv.Itoa(user.UserID) + ":" + user.LNKind + ":" + user.LNURL
+}
+
+// subscribe keeps a subscription to the node's settled invoices open,
+// resuming after the last one seen so none are missed while it was down.
+func (p *Provider) subscribe(ctx context.Context, user utils.User, conn *nodeConn) {
+	failures := 0
+	for {
+		var index uint64
+		if value, ok := p.cursors.Cursor(cursorKey(user)); ok {
+			index, _ = strconv.ParseUint(value, 10, 64)
+		}
+
+		p.setLive(conn, true)
+		err := conn.node.Subscribe(ctx, index, func(state InvoiceState) {
+			failures = 0
+			p.settle(user, state)
+		})
+		p.setLive(conn, false)
+		if ctx.Err() != nil {
+			return // the user changed nodes
+		}
+
+		failures++
+		delay := 5 * time.Second << uint(failures-1)
+		if delay > p.cfg.MaxBackoff || delay <= 0 {
+			delay = p.cfg.MaxBackoff
+		}
+		log.Println("lightning: subscription to the node of user", user.UserID, "failed, retrying in", delay, "-", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (p *Provider) setLive(conn *nodeConn, live bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn.live = live
+}
+
+func (p *Provider) settle(user utils.User, state InvoiceState) {
+	p.mu.Lock()
+	p.settled[state.PaymentHash] = settledInvoice{state: state, at: time.Now()}
+	for hash, s := range p.settled {
+		if time.Since(s.at) > p.cfg.KeepFor {
+			delete(p.settled, hash)
+		}
+	}
+	p.mu.Unlock()
+
+	if state.SettleIndex > 0 {
+		if err := p.cursors.SetCursor(cursorKey(user), strconv.FormatUint(state.SettleIndex, 10)); err != nil {
+			log.Println("lightning: error saving the settle index of user", user.UserID, err)
+		}
+	}
+	payments.NotifySettled()
+}
+
+// Subscribed tells whether the user's node is pushing settled invoices.
+func (p *Provider) Subscribed(userID int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.nodes[userID]
+	return ok && conn.live
+}
+
+func (p *Provider) node(userID int) (Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.nodes[userID]
+	if !ok {
+		return nil, fmt.Errorf("user %d has no lightning node", userID)
+	}
+	return conn.node, nil
+}
+
+// CreatePaymentRequest asks the user's node for an invoice of the amount
+// chosen. The dono is matched by the invoice's payment hash and expires
+// with the invoice.
+func (p *Provider) CreatePaymentRequest(user utils.User, currency string, amount float64) (payments.PaymentRequest, error) {
+	c, ok := utils.GetCurrency(currency)
+	if !ok || c.Chain != utils.ChainLightning {
+		return payments.PaymentRequest{}, fmt.Errorf("%s is not paid over Lightning", currency)
+	}
+	node, err := p.node(user.UserID)
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+
+	atomic := utils.AtomicFromFloat(amount, c.Code) // satoshis
+	invoice, err := node.CreateInvoice(atomic.Uint64()*1000, "Donation to "+user.Username, p.cfg.InvoiceExpiry)
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+
+	req := payments.PaymentRequest{
+		Currency:  c.Code,
+		Address:   invoice.BOLT11,
+		PayID:     invoice.PaymentHash,
+		Amount:    utils.FormatAtomic(atomic, c.Code),
+		Atomic:    atomic,
+		URI:       payments.LightningURI(invoice.BOLT11),
+		ExpiresAt: invoice.ExpiresAt,
+	}
+	return req, nil
+}
+
+// Poll does nothing, settled invoices are pushed by the subscriptions.
+func (p *Provider) Poll(donos []utils.Dono) error {
+	return nil
+}
+
+// Match finds the dono's invoice among the settled ones pushed by the
+// user's node, or asks the node about it. A settled invoice is final.
+func (p *Provider) Match(dono utils.Dono) (payments.Match, error) {
+	p.mu.Lock()
+	settled, ok := p.settled[dono.Address]
+	p.mu.Unlock()
+
+	state := settled.state
+	if !ok {
+		node, err := p.node(dono.UserID)
+		if err != nil {
+			return payments.Match{}, err
+		}
+		if state, err = node.Lookup(dono.Address); err != nil {
+			return payments.Match{}, err
+		}
+	}
+	if !state.Settled {
+		return payments.Match{}, nil
+	}
+
+	return payments.Match{
+		Found:         true,
+		Seen:          true,
+		AmountSent:    new(big.Int).SetUint64(state.PaidMsat / 1000),
+		Confirmations: 1,
+		TxHash:        dono.Address,
+		LogIndex:      -1,
+	}, nil
+}
@@ -0,0 +1,482 @@
+package ln
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"shadowchat/payments/paymentstest"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	testMacaroon = "0201036c6e64"
+	testRune     = "testrune"
+)
+
+type fakeInvoice struct {
+	hash     []byte
+	amount   uint64 // msat
+	paid     uint64 // msat
+	payIndex uint64 // 0 until paid
+}
+
+// fakeNode is a stand-in for LND's REST API or Core Lightning's clnrest,
+// holding invoices the tests can pay.
+type fakeNode struct {
+	mu        sync.Mutex
+	invoices  []*fakeInvoice
+	paidCount uint64
+	changed   chan struct{} // closed and replaced whenever an invoice is paid
+	waits     []string      // the lastpay_index of every waitanyinvoice, "" if none
+	streams   int           // open LND subscriptions
+}
+
+func newFakeNode(t *testing.T, kind string) (*fakeNode, *httptest.Server) {
+	n := &fakeNode{changed: make(chan struct{})}
+	handler := n.serveLND
+	if kind == KindCLN {
+		handler = n.serveCLN
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(handler))
+	t.Cleanup(srv.Close)
+	return n, srv
+}
+
+func (n *fakeNode) add(amount uint64) *fakeInvoice {
+	hash := make([]byte, 32)
+	rand.Read(hash)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	invoice := &fakeInvoice{hash: hash, amount: amount}
+	n.invoices = append(n.invoices, invoice)
+	return invoice
+}
+
+func (n *fakeNode) find(hash string) *fakeInvoice {
+	for _, invoice := range n.invoices {
+		if hex.EncodeToString(invoice.hash) == hash {
+			return invoice
+		}
+	}
+	return nil
+}
+
+// pay settles the invoice with the given payment hash.
+func (n *fakeNode) pay(hash string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	invoice := n.find(hash)
+	n.paidCount++
+	invoice.paid = invoice.amount
+	invoice.payIndex = n.paidCount
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// paidAfter returns the invoices paid after index, in order, and a channel
+// closed on the next payment.
+func (n *fakeNode) paidAfter(index uint64) ([]*fakeInvoice, chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	paid := make([]*fakeInvoice, n.paidCount)
+	for _, invoice := range n.invoices {
+		if invoice.payIndex > 0 {
+			paid[invoice.payIndex-1] = invoice
+		}
+	}
+	if index > n.paidCount {
+		index = n.paidCount
+	}
+	return paid[index:], n.changed
+}
+
+func (n *fakeNode) lndInvoice(invoice *fakeInvoice) lndInvoice {
+	state := "OPEN"
+	if invoice.payIndex > 0 {
+		state = "SETTLED"
+	}
+	return lndInvoice{
+		RHash:          base64.StdEncoding.EncodeToString(invoice.hash),
+		PaymentRequest: "lnbc" + hex.EncodeToString(invoice.hash[:8]),
+		State:          state,
+		AmtPaidMsat:    strconv.FormatUint(invoice.paid, 10),
+		SettleIndex:    strconv.FormatUint(invoice.payIndex, 10),
+	}
+}
+
+func (n *fakeNode) serveLND(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Grpc-Metadata-macaroon") != testMacaroon {
+		http.Error(w, `{"message":"verification failed"}`, http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/invoices":
+		var req struct {
+			ValueMsat string `json:"value_msat"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		amount, _ := strconv.ParseUint(req.ValueMsat, 10, 64)
+		invoice := n.add(amount)
+		json.NewEncoder(w).Encode(n.lndInvoice(invoice))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/invoice/"):
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		invoice := n.find(strings.TrimPrefix(r.URL.Path, "/v1/invoice/"))
+		if invoice == nil {
+			http.Error(w, `{"message":"unable to locate invoice"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(n.lndInvoice(invoice))
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/invoices/subscribe":
+		// like LND, only a settle index above zero replays what came before
+		index, _ := strconv.ParseUint(r.URL.Query().Get("settle_index"), 10, 64)
+		n.mu.Lock()
+		if index == 0 {
+			index = n.paidCount
+		}
+		n.streams++
+		n.mu.Unlock()
+		defer func() {
+			n.mu.Lock()
+			n.streams--
+			n.mu.Unlock()
+		}()
+		w.(http.Flusher).Flush()
+		for {
+			paid, changed := n.paidAfter(index)
+			for _, invoice := range paid {
+				n.mu.Lock()
+				update := map[string]lndInvoice{"result": n.lndInvoice(invoice)}
+				n.mu.Unlock()
+				json.NewEncoder(w).Encode(update)
+				index = invoice.payIndex
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *fakeNode) clnInvoice(invoice *fakeInvoice) map[string]interface{} {
+	status := "unpaid"
+	if invoice.payIndex > 0 {
+		status = "paid"
+	}
+	result := map[string]interface{}{
+		"payment_hash": hex.EncodeToString(invoice.hash),
+		"status":       status,
+		"bolt11":       "lnbc" + hex.EncodeToString(invoice.hash[:8]),
+		"expires_at":   time.Now().Add(time.Hour).Unix(),
+	}
+	if invoice.payIndex > 0 {
+		result["amount_received_msat"] = invoice.paid
+		result["pay_index"] = invoice.payIndex
+	}
+	return result
+}
+
+func (n *fakeNode) serveCLN(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Rune") != testRune {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": 1501, "message": "Not authorized"})
+		return
+	}
+	var params map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&params)
+	switch r.URL.Path {
+	case "/v1/invoice":
+		var amount uint64
+		json.Unmarshal(params["amount_msat"], &amount)
+		invoice := n.add(amount)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(n.clnInvoice(invoice))
+	case "/v1/listinvoices":
+		var hash string
+		json.Unmarshal(params["payment_hash"], &hash)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		invoices := []map[string]interface{}{}
+		for _, invoice := range n.invoices {
+			if hash == "" || hex.EncodeToString(invoice.hash) == hash {
+				invoices = append(invoices, n.clnInvoice(invoice))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"invoices": invoices})
+	case "/v1/waitanyinvoice":
+		var index uint64
+		json.Unmarshal(params["lastpay_index"], &index)
+		n.mu.Lock()
+		n.waits = append(n.waits, string(params["lastpay_index"]))
+		n.mu.Unlock()
+		for {
+			paid, changed := n.paidAfter(index)
+			if len(paid) > 0 {
+				n.mu.Lock()
+				defer n.mu.Unlock()
+				json.NewEncoder(w).Encode(n.clnInvoice(paid[0]))
+				return
+			}
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *fakeNode) lastWait() (string, int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.waits) == 0 {
+		return "", 0
+	}
+	return n.waits[len(n.waits)-1], len(n.waits)
+}
+
+func (n *fakeNode) openStreams() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.streams
+}
+
+func certPEM(srv *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+}
+
+func testUser(kind string, srv *httptest.Server) utils.User {
+	credential := testMacaroon
+	if kind == KindCLN {
+		credential = testRune
+	}
+	return utils.User{UserID: 1, Username: "streamer", LNKind: kind, LNURL: srv.URL, LNCredential: credential, LNCert: certPEM(srv)}
+}
+
+// newTestProvider connects a provider to the stand-in node, which is on
+// 127.0.0.1 and so only allowed with AllowPrivateNodes.
+func newTestProvider(t *testing.T, user utils.User, cursors *paymentstest.MemCursors) *Provider {
+	cfg := DefaultConfig()
+	cfg.AllowPrivateNodes = true
+	p := New(cfg, cursors)
+	p.SetUser(user)
+	t.Cleanup(func() { p.SetUser(utils.User{UserID: user.UserID}) })
+	return p
+}
+
+func testDono(req string) utils.Dono {
+	return utils.Dono{ID: 1, UserID: 1, Address: req, CurrencyType: "BTC_LN"}
+}
+
+func TestNewNodeRefusesPrivateURLs(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://node.example.com:8080",
+		"https://localhost:8080",
+		"https://127.0.0.1:8080",
+		"https://10.1.2.3",
+		"https://192.168.1.10:3010",
+		"https://[::1]:8080",
+		"https://169.254.169.254",
+		"https://100.64.0.1",
+		"https://0.0.0.0",
+	} {
+		if _, err := NewNode(KindCLN, rawURL, testRune, "", false); err == nil {
+			t.Errorf("%s was accepted", rawURL)
+		}
+		if _, err := NewNode(KindCLN, rawURL, testRune, "", true); err != nil {
+			t.Errorf("%s was refused with private nodes allowed: %v", rawURL, err)
+		}
+	}
+	if _, err := NewNode(KindCLN, "https://node.example.com:3010", testRune, "", false); err != nil {
+		t.Errorf("public https node refused: %v", err)
+	}
+}
+
+func TestDialRefusesPrivateAddresses(t *testing.T) {
+	var conn syscall.RawConn
+	for address, public := range map[string]bool{
+		"93.184.216.34:443":        true,
+		"[2606:2800:220:1::]:443":  true,
+		"127.0.0.1:443":            false,
+		"10.0.0.5:8080":            false,
+		"172.16.0.1:443":           false,
+		"169.254.169.254:80":       false,
+		"[::1]:443":                false,
+		"[fd00::1]:443":            false,
+		"[::ffff:192.168.0.1]:443": false,
+	} {
+		if err := dialPublicOnly("tcp", address, conn); (err == nil) != public {
+			t.Errorf("dialing %s: %v, want public %t", address, err, public)
+		}
+	}
+}
+
+func TestLNDInvoiceSettles(t *testing.T) {
+	node, srv := newFakeNode(t, KindLND)
+	user := testUser(KindLND, srv)
+	cursors := paymentstest.NewMemCursors(nil)
+	p := newTestProvider(t, user, cursors)
+	paymentstest.WaitFor(t, "the node is subscribed to", func() bool { return node.openStreams() == 1 })
+
+	req, err := p.CreatePaymentRequest(user, "BTC_LN", 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Atomic.Int64() != 10000 || !strings.HasPrefix(req.Address, "lnbc") {
+		t.Fatalf("payment request = %+v, want an invoice of 10000 sats", req)
+	}
+	if m, err := p.Match(testDono(req.PayID)); err != nil || m.Found {
+		t.Fatalf("open invoice: %+v, %v", m, err)
+	}
+
+	node.pay(req.PayID)
+	paymentstest.WaitFor(t, "the settled invoice is pushed", func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		_, ok := p.settled[req.PayID]
+		return ok
+	})
+	m, err := p.Match(testDono(req.PayID))
+	if err != nil || !m.Found || m.AmountSent.Int64() != 10000 || m.TxHash != req.PayID {
+		t.Errorf("settled invoice: %+v, %v", m, err)
+	}
+	if got, _ := cursors.Cursor(cursorKey(user)); got != "1" {
+		t.Errorf("cursor = %q, want 1", got)
+	}
+}
+
+func TestLNDResumesFromCursor(t *testing.T) {
+	node, srv := newFakeNode(t, KindLND)
+	user := testUser(KindLND, srv)
+	first := node.add(1000)
+	second := node.add(2000)
+	node.pay(hex.EncodeToString(first.hash))
+	node.pay(hex.EncodeToString(second.hash))
+
+	cursors := paymentstest.NewMemCursors(map[string]string{cursorKey(user): "1"})
+	p := newTestProvider(t, user, cursors)
+	paymentstest.WaitFor(t, "the invoice paid while down is pushed", func() bool {
+		got, _ := cursors.Cursor(cursorKey(user))
+		return got == "2"
+	})
+	p.mu.Lock()
+	_, replayed := p.settled[hex.EncodeToString(first.hash)]
+	p.mu.Unlock()
+	if replayed {
+		t.Error("invoice at the cursor was pushed again")
+	}
+}
+
+func TestCLNStartsAfterLastPaidInvoice(t *testing.T) {
+	node, srv := newFakeNode(t, KindCLN)
+	user := testUser(KindCLN, srv)
+	var history []string
+	for i := 0; i < 2; i++ {
+		invoice := node.add(1000)
+		history = append(history, hex.EncodeToString(invoice.hash))
+		node.pay(history[i])
+	}
+
+	cursors := paymentstest.NewMemCursors(nil)
+	p := newTestProvider(t, user, cursors)
+	paymentstest.WaitFor(t, "waitanyinvoice is called", func() bool { _, calls := node.lastWait(); return calls > 0 })
+	if index, calls := node.lastWait(); index != "2" || calls != 1 {
+		t.Fatalf("waitanyinvoice was called %d times, last from %q, want once from 2", calls, index)
+	}
+	p.mu.Lock()
+	replayed := len(p.settled)
+	p.mu.Unlock()
+	if _, ok := cursors.Cursor(cursorKey(user)); ok || replayed > 0 {
+		t.Errorf("%d invoices paid before the subscription were pushed", replayed)
+	}
+
+	req, err := p.CreatePaymentRequest(user, "BTC_LN", 0.00002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.pay(req.PayID)
+	paymentstest.WaitFor(t, "the settled invoice is pushed", func() bool {
+		got, _ := cursors.Cursor(cursorKey(user))
+		return got == "3"
+	})
+	if m, err := p.Match(testDono(req.PayID)); err != nil || !m.Found || m.AmountSent.Int64() != 2000 {
+		t.Errorf("settled invoice: %+v, %v", m, err)
+	}
+	paymentstest.WaitFor(t, "waitanyinvoice is called again", func() bool { index, _ := node.lastWait(); return index == "3" })
+
+	// invoices paid before are still found by asking the node
+	if m, err := p.Match(testDono(history[0])); err != nil || !m.Found {
+		t.Errorf("invoice paid before the subscription: %+v, %v", m, err)
+	}
+}
+
+func TestCLNResumesFromCursor(t *testing.T) {
+	node, srv := newFakeNode(t, KindCLN)
+	user := testUser(KindCLN, srv)
+	first := node.add(1000)
+	second := node.add(2000)
+	node.pay(hex.EncodeToString(first.hash))
+	node.pay(hex.EncodeToString(second.hash))
+
+	cursors := paymentstest.NewMemCursors(map[string]string{cursorKey(user): "1"})
+	p := newTestProvider(t, user, cursors)
+	paymentstest.WaitFor(t, "the invoice paid while down is pushed", func() bool {
+		got, _ := cursors.Cursor(cursorKey(user))
+		return got == "2"
+	})
+	node.mu.Lock()
+	from := node.waits[0]
+	node.mu.Unlock()
+	if from != "1" {
+		t.Errorf("waitanyinvoice first called from %q, want the cursor", from)
+	}
+	if m, err := p.Match(testDono(hex.EncodeToString(second.hash))); err != nil || !m.Found {
+		t.Errorf("invoice paid while down: %+v, %v", m, err)
+	}
+}
+
+func TestNodeIsNotTrustedBlindly(t *testing.T) {
+	for _, kind := range []string{KindLND, KindCLN} {
+		_, srv := newFakeNode(t, kind)
+		user := testUser(kind, srv)
+		wrong := "00"
+		if kind == KindCLN {
+			wrong = "wrongrune"
+		}
+		node, err := NewNode(kind, srv.URL, wrong, user.LNCert, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := node.CreateInvoice(1000, "test", time.Minute); err == nil {
+			t.Errorf("%s: invoice created with the wrong credential", kind)
+		}
+
+		// the node's certificate is self-signed, so it has to be given
+		node, err = NewNode(kind, srv.URL, user.LNCredential, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := node.CreateInvoice(1000, "test", time.Minute); err == nil {
+			t.Errorf("%s: invoice created over an unverified connection", kind)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package ln
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// lnd talks to LND's REST API with an invoice macaroon, which can create,
+// look up and subscribe to invoices but not spend.
+type lnd struct {
+	url      string
+	macaroon string // hex
+	http     *http.Client
+}
+
+func newLND(url string, macaroon string, client *http.Client) (*lnd, error) {
+	if _, err := hex.DecodeString(macaroon); err != nil {
+		return nil, fmt.Errorf("the macaroon must be hex encoded (xxd -p -c 10000 invoice.macaroon)")
+	}
+	return &lnd{url: url, macaroon: macaroon, http: client}, nil
+}
+
+// lndInvoice is an invoice as LND's REST API returns it. 64 bit numbers are
+// strings and byte fields base64.
+type lndInvoice struct {
+	RHash          string `json:"r_hash"`
+	PaymentRequest string `json:"payment_request"`
+	State          string `json:"state"` // OPEN, SETTLED, CANCELED or ACCEPTED
+	AmtPaidMsat    string `json:"amt_paid_msat"`
+	SettleIndex    string `json:"settle_index"`
+}
+
+func (i lndInvoice) state() (InvoiceState, error) {
+	hash, err := base64.StdEncoding.DecodeString(i.RHash)
+	if err != nil {
+		return InvoiceState{}, err
+	}
+	paid, _ := strconv.ParseUint(i.AmtPaidMsat, 10, 64)
+	settleIndex, _ := strconv.ParseUint(i.SettleIndex, 10, 64)
+	return InvoiceState{
+		PaymentHash: hex.EncodeToString(hash),
+		Settled:     i.State == "SETTLED",
+		PaidMsat:    paid,
+		SettleIndex: settleIndex,
+	}, nil
+}
+
+func (n *lnd) request(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, n.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Grpc-Metadata-macaroon", n.macaroon)
+	res, err := n.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		return nil, fmt.Errorf("lnd %s: non-200 response code received: %d %s", path, res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return res, nil
+}
+
+func (n *lnd) call(method string, path string, body interface{}, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := n.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (n *lnd) CreateInvoice(amountMsat uint64, memo string, expiry time.Duration) (Invoice, error) {
+	body := map[string]string{
+		"value_msat": strconv.FormatUint(amountMsat, 10),
+		"memo":       memo,
+		"expiry":     strconv.Itoa(int(expiry.Seconds())),
+	}
+	var resp lndInvoice
+	if err := n.call(http.MethodPost, "/v1/invoices", body, &resp); err != nil {
+		return Invoice{}, err
+	}
+	hash, err := base64.StdEncoding.DecodeString(resp.RHash)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if resp.PaymentRequest == "" {
+		return Invoice{}, fmt.Errorf("lnd returned no payment request")
+	}
+	return Invoice{
+		PaymentHash: hex.EncodeToString(hash),
+		BOLT11:      resp.PaymentRequest,
+		ExpiresAt:   time.Now().Add(expiry),
+	}, nil
+}
+
+func (n *lnd) Lookup(paymentHash string) (InvoiceState, error) {
+	var resp lndInvoice
+	if err := n.call(http.MethodGet, "/v1/invoice/"+paymentHash, nil, &resp); err != nil {
+		return InvoiceState{}, err
+	}
+	return resp.state()
+}
+
+// Subscribe reads /v1/invoices/subscribe, which streams one JSON object per
+// invoice update and first replays the invoices settled after settleIndex.
+func (n *lnd) Subscribe(ctx context.Context, settleIndex uint64, settled func(InvoiceState)) error {
+	res, err := n.request(ctx, http.MethodGet, "/v1/invoices/subscribe?settle_index="+strconv.FormatUint(settleIndex, 10), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var update struct {
+			Result *lndInvoice `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&update); err != nil {
+			return err
+		}
+		if update.Error != nil {
+			return fmt.Errorf("lnd invoice subscription: %s", update.Error.Message)
+		}
+		if update.Result == nil || update.Result.State != "SETTLED" {
+			continue
+		}
+		state, err := update.Result.state()
+		if err != nil {
+			return err
+		}
+		settled(state)
+	}
+}
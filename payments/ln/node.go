@@ -0,0 +1,131 @@
+package ln
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Node kinds a streamer can connect.
+const (
+	KindLND = "lnd" // LND REST with an invoice macaroon
+	KindCLN = "cln" // Core Lightning's clnrest with a rune
+)
+
+// Invoice is a BOLT11 invoice created for a dono.
+type Invoice struct {
+	PaymentHash string // hex
+	BOLT11      string
+	ExpiresAt   time.Time
+}
+
+// InvoiceState is what a node says about an invoice.
+type InvoiceState struct {
+	PaymentHash string // hex
+	Settled     bool
+	PaidMsat    uint64
+	SettleIndex uint64 // order the node settled it in, to resume a subscription from
+}
+
+// Node is a streamer's Lightning node, reached with a credential that can
+// only create and read invoices.
+type Node interface {
+	// CreateInvoice asks for amountMsat, payable until expiry has passed.
+	CreateInvoice(amountMsat uint64, memo string, expiry time.Duration) (Invoice, error)
+	// Lookup returns the state of the invoice with the given payment hash.
+	Lookup(paymentHash string) (InvoiceState, error)
+	// Subscribe calls settled for every invoice settled after settleIndex,
+	// in order, until ctx is done or the connection fails. A zero
+	// settleIndex starts from the invoices settled from now on.
+	Subscribe(ctx context.Context, settleIndex uint64, settled func(InvoiceState)) error
+}
+
+// NewNode returns a client for a node of the given kind at rawURL. The
+// credential is the hex encoded LND invoice macaroon or the CLN rune. A PEM
+// certificate, if given, is the only one trusted for the node, for nodes
+// with a self-signed certificate.
+//
+// The URL comes from a streamer, so unless allowPrivate is set it has to be
+// https and the node is only ever reached on a public address; otherwise a
+// streamer could have the server make requests to services only it can
+// reach, such as its own wallets or the network it runs in.
+func NewNode(kind string, rawURL string, credential string, certPEM string, allowPrivate bool) (Node, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme != "https" && u.Scheme != "http":
+		return nil, fmt.Errorf("%q is not an http or https url", rawURL)
+	case allowPrivate:
+	case u.Scheme != "https":
+		return nil, fmt.Errorf("%q is not an https url", rawURL)
+	case u.Hostname() == "localhost" || strings.HasSuffix(u.Hostname(), ".localhost"):
+		return nil, fmt.Errorf("%s is not a public host", u.Hostname())
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !allowPrivate && !publicIP(ip) {
+		return nil, fmt.Errorf("%s is not a public address", ip)
+	}
+	base := strings.TrimRight(u.String(), "/")
+	credential = strings.TrimSpace(credential)
+	if credential == "" {
+		return nil, fmt.Errorf("no macaroon or rune")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		// checked on every connection, so a name that resolves to a private
+		// address later is refused as well
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublicOnly}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+	if strings.TrimSpace(certPEM) != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(certPEM)) {
+			return nil, fmt.Errorf("the TLS certificate is not PEM encoded")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	// no client timeout, subscriptions stay open; calls use requestTimeout
+	client := &http.Client{Transport: transport}
+
+	switch kind {
+	case KindLND:
+		return newLND(base, credential, client)
+	case KindCLN:
+		return newCLN(base, credential, client), nil
+	}
+	return nil, fmt.Errorf("unknown node kind %q, use %s or %s", kind, KindLND, KindCLN)
+}
+
+// requestTimeout bounds calls that are not subscriptions.
+const requestTimeout = 30 * time.Second
+
+// cgnat is the shared address space carriers put their customers in.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is reachable on the internet, rather than
+// loopback, private, link-local or otherwise special.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip)
+}
+
+// dialPublicOnly refuses connections to addresses that are not public.
+func dialPublicOnly(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
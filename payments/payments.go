@@ -0,0 +1,159 @@
+package payments
+
+import (
+	"log"
+	"math/big"
+	"shadowchat/utils"
+	"strings"
+	"time"
+)
+
+// PaymentRequest is what a provider hands back when a donor picks its
+// currency on the donation page.
+type PaymentRequest struct {
+	Currency        string
+	Address         string   // address shown to the donor
+	PayID           string   // stored as the dono address and used to match the payment
+	Reference       string   // Solana Pay reference key, stored with the dono
+	Amount          string   // amount the donor has to send, formatted for display
+	Atomic          *big.Int // the same amount in atomic units, used for matching
+	ContractAddress string
+	URI             string    // payment URI encoded into the QR code
+	ExpiresAt       time.Time // when the request can no longer be paid, zero if it doesn't expire
+}
+
+// Match is the result of checking a pending dono against a provider.
+type Match struct {
+	Found         bool     // paid and confirmed as far as the streamer asked for
+	Seen          bool     // paid, but maybe still in the mempool
+	Partial       bool     // less than asked was paid and confirmed, AmountSent says how much
+	AmountSent    *big.Int // atomic units received
+	Confirmations int
+	BlockNumber   uint64 // block the payment is in, on chains that can reorg
+	BlockHash     string
+	TxHash        string // transaction hash or signature of the payment
+	LogIndex      int    // which transfer in the transaction, -1 if it has only one
+}
+
+// Provider is a payment method for one chain. Adding a chain means adding a
+// package that implements Provider and registering it at startup.
+type Provider interface {
+	// Name returns a short identifier used in logs.
+	Name() string
+	// Currencies returns the currency codes this provider accepts.
+	Currencies() []string
+	// Start begins watching the addresses of the given users.
+	Start(users []utils.User)
+	// SetUser is called whenever a user's settings are saved.
+	SetUser(user utils.User)
+	// CreatePaymentRequest returns where and how much a donor has to pay.
+	CreatePaymentRequest(user utils.User, currency string, amount float64) (PaymentRequest, error)
+	// Poll fetches incoming payments for the given pending donos.
+	Poll(donos []utils.Dono) error
+	// Match checks whether a pending dono has been paid.
+	Match(dono utils.Dono) (Match, error)
+}
+
+// MempoolWatcher is implemented by providers that can see a payment before
+// it is confirmed, cheaply enough to be asked every few seconds.
+type MempoolWatcher interface {
+	Seen(dono utils.Dono) (Match, error)
+}
+
+// Subscriber is implemented by providers that are told about payments by
+// the user's node as they happen, so their donos don't have to be checked
+// on a backoff while the subscription is up.
+type Subscriber interface {
+	Subscribed(userID int) bool
+}
+
+var settled = make(chan struct{}, 1)
+
+// NotifySettled tells the dono checker a payment came in, so it checks the
+// pending donos now instead of after its usual wait.
+func NotifySettled() {
+	select {
+	case settled <- struct{}{}:
+	default:
+	}
+}
+
+// Settled receives after NotifySettled was called.
+func Settled() <-chan struct{} {
+	return settled
+}
+
+// Cursors keeps a provider's progress through a chain across restarts: how
+// far it has scanned, such as the last block processed, and what it found
+// there that can't be found again without rescanning. Keys are picked by
+// the provider.
+type Cursors interface {
+	Cursor(key string) (string, bool)
+	SetCursor(key string, value string) error
+}
+
+// Claims looks up which dono a transfer paid, as stored by the dono
+// checker, for providers whose transfers can come up again for another dono.
+type Claims interface {
+	// ClaimedBy returns the ID of the dono the transfer paid, 0 if none.
+	ClaimedBy(txHash string, logIndex int) (int, error)
+}
+
+var providers []Provider
+
+// Register adds a provider. Currencies already claimed by an earlier
+// provider are not overridden.
+func Register(p Provider) {
+	providers = append(providers, p)
+}
+
+// All returns every registered provider in registration order.
+func All() []Provider {
+	return providers
+}
+
+// ForCurrency returns the provider that accepts the given currency code.
+func ForCurrency(code string) (Provider, bool) {
+	code = strings.ToUpper(code)
+	for _, p := range providers {
+		for _, c := range p.Currencies() {
+			if c == code {
+				return p, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// StartAll starts every registered provider.
+func StartAll(users []utils.User) {
+	for _, p := range providers {
+		p.Start(users)
+	}
+}
+
+// SetUser forwards updated user settings to every registered provider.
+func SetUser(user utils.User) {
+	for _, p := range providers {
+		p.SetUser(user)
+	}
+}
+
+// PollAll groups pending donos by provider and polls each provider once.
+func PollAll(donos []utils.Dono) {
+	grouped := make(map[Provider][]utils.Dono)
+	for _, dono := range donos {
+		p, ok := ForCurrency(dono.CurrencyType)
+		if !ok {
+			continue
+		}
+		grouped[p] = append(grouped[p], dono)
+	}
+
+	for p, d := range grouped {
+		err := p.Poll(d)
+		if err != nil {
+			log.Println(p.Name(), "poll error:", err)
+		}
+	}
+}
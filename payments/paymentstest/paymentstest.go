@@ -0,0 +1,57 @@
+// Package paymentstest has what the payment provider tests share: cursors
+// kept in memory and waiting on providers that work in the background.
+package paymentstest
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// MemCursors implements payments.Cursors in memory.
+type MemCursors struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// NewMemCursors returns cursors starting out with the given values, which
+// may be nil.
+func NewMemCursors(values map[string]string) *MemCursors {
+	m := &MemCursors{values: make(map[string]string)}
+	for key, value := range values {
+		m.values[key] = value
+	}
+	return m
+}
+
+func (m *MemCursors) Cursor(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *MemCursors) SetCursor(key string, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+// Get returns a cursor's value, empty if it isn't set.
+func (m *MemCursors) Get(key string) string {
+	value, _ := m.Cursor(key)
+	return value
+}
+
+// WaitFor fails the test if cond doesn't hold within 5 seconds.
+func WaitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting until", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,238 @@
+package sol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRPC is the public mainnet endpoint. It is heavily rate limited.
+const DefaultRPC = "https://api.mainnet-beta.solana.com"
+
+// Client talks to Solana JSON-RPC endpoints. Calls go to the endpoint that
+// answered last and fall over to the next one in the list when it fails.
+type Client struct {
+	URLs    []string
+	http    *http.Client
+	id      int64
+	mu      sync.Mutex
+	current int
+}
+
+// NewClient returns a client for the JSON-RPC endpoints at urls, best first.
+func NewClient(urls ...string) *Client {
+	return &Client{URLs: urls, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *Client) call(method string, params []interface{}, out interface{}) error {
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	var err error
+	for i := range c.URLs {
+		n := (start + i) % len(c.URLs)
+		if err = c.callURL(c.URLs[n], method, params, out); err == nil {
+			c.mu.Lock()
+			c.current = n
+			c.mu.Unlock()
+			return nil
+		}
+		if len(c.URLs) > 1 {
+			log.Println("solana rpc", c.URLs[n], "failed:", err)
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("%s: no rpc endpoints", method)
+	}
+	return err
+}
+
+func (c *Client) callURL(url string, method string, params []interface{}, out interface{}) error {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      atomic.AddInt64(&c.id, 1),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := c.http.Post(url, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: non-200 response code received: %d", method, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s: rpc error %d: %s", method, resp.Error.Code, resp.Error.Message)
+	}
+	return json.Unmarshal(resp.Result, out)
+}
+
+// SignatureInfo is an entry of a getSignaturesForAddress response, newest
+// first.
+type SignatureInfo struct {
+	Signature          string      `json:"signature"`
+	Slot               uint64      `json:"slot"`
+	Err                interface{} `json:"err"`                // nil if the transaction succeeded
+	ConfirmationStatus string      `json:"confirmationStatus"` // "processed", "confirmed" or "finalized"
+}
+
+// SignatureOptions pages through getSignaturesForAddress. Before and Until
+// are signatures: results are older than Before and newer than Until.
+type SignatureOptions struct {
+	Limit      int
+	Before     string
+	Until      string
+	Commitment string // "confirmed" or "finalized"
+}
+
+// SignaturesForAddress returns the signatures of transactions that include
+// address, newest first.
+func (c *Client) SignaturesForAddress(address string, opts SignatureOptions) ([]SignatureInfo, error) {
+	params := map[string]interface{}{"limit": opts.Limit, "commitment": opts.Commitment}
+	if opts.Before != "" {
+		params["before"] = opts.Before
+	}
+	if opts.Until != "" {
+		params["until"] = opts.Until
+	}
+	var sigs []SignatureInfo
+	err := c.call("getSignaturesForAddress", []interface{}{address, params}, &sigs)
+	return sigs, err
+}
+
+// RPCTransaction is a getTransaction response in "json" encoding.
+type RPCTransaction struct {
+	Slot uint64 `json:"slot"`
+	Meta struct {
+		Err               interface{}    `json:"err"`
+		Fee               uint64         `json:"fee"`
+		PreBalances       []uint64       `json:"preBalances"`
+		PostBalances      []uint64       `json:"postBalances"`
+		PreTokenBalances  []TokenBalance `json:"preTokenBalances"`
+		PostTokenBalances []TokenBalance `json:"postTokenBalances"`
+		LoadedAddresses   struct {
+			Writable []string `json:"writable"`
+			Readonly []string `json:"readonly"`
+		} `json:"loadedAddresses"`
+	} `json:"meta"`
+	Transaction struct {
+		Message struct {
+			AccountKeys []string `json:"accountKeys"`
+		} `json:"message"`
+		Signatures []string `json:"signatures"`
+	} `json:"transaction"`
+}
+
+// TokenBalance is the balance of one token account before or after a
+// transaction.
+type TokenBalance struct {
+	AccountIndex  int    `json:"accountIndex"`
+	Mint          string `json:"mint"`
+	Owner         string `json:"owner"` // wallet the token account belongs to
+	UITokenAmount struct {
+		Amount   string `json:"amount"` // in the token's smallest unit
+		Decimals int    `json:"decimals"`
+	} `json:"uiTokenAmount"`
+}
+
+// Transaction returns a transaction at the given commitment, or nil if the
+// node doesn't have it (yet).
+func (c *Client) Transaction(signature string, commitment string) (*RPCTransaction, error) {
+	var tx *RPCTransaction
+	opts := map[string]interface{}{"encoding": "json", "commitment": commitment, "maxSupportedTransactionVersion": 0}
+	err := c.call("getTransaction", []interface{}{signature, opts}, &tx)
+	return tx, err
+}
+
+// AccountKeys returns every account of the transaction in the order the
+// balances are listed, including ones loaded from lookup tables.
+func (tx *RPCTransaction) AccountKeys() []string {
+	keys := append([]string{}, tx.Transaction.Message.AccountKeys...)
+	keys = append(keys, tx.Meta.LoadedAddresses.Writable...)
+	return append(keys, tx.Meta.LoadedAddresses.Readonly...)
+}
+
+// HasAccount tells whether address is one of the transaction's accounts,
+// which is how Solana Pay references are found.
+func (tx *RPCTransaction) HasAccount(address string) bool {
+	for _, key := range tx.AccountKeys() {
+		if key == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Received returns the lamports address gained in the transaction, zero if
+// it isn't part of it or lost lamports.
+func (tx *RPCTransaction) Received(address string) *big.Int {
+	received := new(big.Int)
+	for i, key := range tx.AccountKeys() {
+		if key != address || i >= len(tx.Meta.PreBalances) || i >= len(tx.Meta.PostBalances) {
+			continue
+		}
+		pre, post := tx.Meta.PreBalances[i], tx.Meta.PostBalances[i]
+		if post > pre {
+			received.SetUint64(post - pre)
+		}
+		break
+	}
+	return received
+}
+
+// TokenReceived returns how much of the token with the given mint the
+// token accounts of owner gained in the transaction, zero if they lost some.
+func (tx *RPCTransaction) TokenReceived(owner string, mint string) *big.Int {
+	received := new(big.Int)
+	add := func(balances []TokenBalance, sign int) {
+		for _, b := range balances {
+			if b.Owner != owner || b.Mint != mint {
+				continue
+			}
+			amount, ok := new(big.Int).SetString(b.UITokenAmount.Amount, 10)
+			if !ok {
+				continue
+			}
+			if sign < 0 {
+				amount.Neg(amount)
+			}
+			received.Add(received, amount)
+		}
+	}
+	add(tx.Meta.PostTokenBalances, 1)
+	add(tx.Meta.PreTokenBalances, -1)
+	if received.Sign() < 0 {
+		received.SetInt64(0)
+	}
+	return received
+}
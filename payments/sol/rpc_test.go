@@ -0,0 +1,126 @@
+package sol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	usdcMint = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	usdtMint = "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB"
+	payer    = "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"
+	payee    = "HN7cABqLq46Es1jh92dQQisAq662SmxELLLsHHe4YWrH"
+	lookedUp = "2ZTcZ8x4RqCfQk6ZRx4K6Tz2z6FqGDbVkoi6mzV3AYpv"
+)
+
+// testTx is a getTransaction response, in "json" encoding, of a transfer
+// of 0.25 SOL and 12.5 USDC from payer to payee. payee had no USDC before,
+// and also gets USDT through an account loaded from a lookup table.
+const testTx = `{
+	"slot": 250000000,
+	"meta": {
+		"err": null,
+		"fee": 5000,
+		"preBalances": [2000000000, 1000000000, 2039280, 2039280, 2039280],
+		"postBalances": [1749995000, 1250000000, 2039280, 2039280, 2039280],
+		"preTokenBalances": [
+			{"accountIndex": 2, "mint": "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", "owner": "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "uiTokenAmount": {"amount": "50000000", "decimals": 6}},
+			{"accountIndex": 4, "mint": "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB", "owner": "HN7cABqLq46Es1jh92dQQisAq662SmxELLLsHHe4YWrH", "uiTokenAmount": {"amount": "1000000", "decimals": 6}}
+		],
+		"postTokenBalances": [
+			{"accountIndex": 2, "mint": "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", "owner": "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "uiTokenAmount": {"amount": "37500000", "decimals": 6}},
+			{"accountIndex": 3, "mint": "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", "owner": "HN7cABqLq46Es1jh92dQQisAq662SmxELLLsHHe4YWrH", "uiTokenAmount": {"amount": "12500000", "decimals": 6}},
+			{"accountIndex": 4, "mint": "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB", "owner": "HN7cABqLq46Es1jh92dQQisAq662SmxELLLsHHe4YWrH", "uiTokenAmount": {"amount": "3000000", "decimals": 6}}
+		],
+		"loadedAddresses": {"writable": ["2ZTcZ8x4RqCfQk6ZRx4K6Tz2z6FqGDbVkoi6mzV3AYpv"], "readonly": []}
+	},
+	"transaction": {
+		"message": {"accountKeys": ["9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM", "HN7cABqLq46Es1jh92dQQisAq662SmxELLLsHHe4YWrH", "ata1", "ata2"]},
+		"signatures": ["5tx"]
+	}
+}`
+
+func parseTestTx(t *testing.T) *RPCTransaction {
+	t.Helper()
+	var tx RPCTransaction
+	if err := json.Unmarshal([]byte(testTx), &tx); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func TestReceived(t *testing.T) {
+	tx := parseTestTx(t)
+	tests := map[string]string{
+		payee:    "250000000",
+		payer:    "0", // lost lamports
+		lookedUp: "0", // loaded from a lookup table, balance unchanged
+		owner:    "0", // not in the transaction
+	}
+	for address, want := range tests {
+		if got := tx.Received(address); got.String() != want {
+			t.Errorf("Received(%s) = %s, want %s", address, got, want)
+		}
+	}
+	if !tx.HasAccount(lookedUp) {
+		t.Error("account loaded from a lookup table not found")
+	}
+}
+
+func TestTokenReceived(t *testing.T) {
+	tx := parseTestTx(t)
+	tests := []struct {
+		owner, mint, want string
+	}{
+		{payee, usdcMint, "12500000"}, // new token account, no pre balance
+		{payee, usdtMint, "2000000"},  // existing token account
+		{payer, usdcMint, "0"},        // sent tokens
+		{payer, usdtMint, "0"},        // no such token account
+		{owner, usdcMint, "0"},        // not in the transaction
+	}
+	for _, tt := range tests {
+		if got := tx.TokenReceived(tt.owner, tt.mint); got.String() != tt.want {
+			t.Errorf("TokenReceived(%s, %s) = %s, want %s", tt.owner, tt.mint, got, tt.want)
+		}
+	}
+	if got := received(tx, payee, "USDC_SOL"); got.String() != "12500000" {
+		t.Errorf("received USDC_SOL = %s, want the token amount", got)
+	}
+	if got := received(tx, payee, "SOL"); got.String() != "250000000" {
+		t.Errorf("received SOL = %s, want the lamports", got)
+	}
+}
+
+func TestClientFailsOver(t *testing.T) {
+	var downCalls atomic.Int64
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downCalls.Add(1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	t.Cleanup(down.Close)
+	up, upURL := newFakeRPC(t)
+
+	c := NewClient(down.URL, upURL)
+	for i := 0; i < 3; i++ {
+		if _, err := c.SignaturesForAddress(owner, SignatureOptions{Limit: 10}); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if n := downCalls.Load(); n != 1 {
+		t.Errorf("the failed endpoint was asked %d times, want once before the client moved on", n)
+	}
+	if n := up.calls.Load(); n != 3 {
+		t.Errorf("the working endpoint was asked %d times, want 3", n)
+	}
+
+	up.failing.Store(true)
+	if _, err := c.SignaturesForAddress(owner, SignatureOptions{Limit: 10}); err == nil {
+		t.Error("no error with every endpoint down")
+	}
+	if n := downCalls.Load(); n != 2 {
+		t.Errorf("the first endpoint was asked %d times, want it tried again once the second failed", n)
+	}
+}
@@ -0,0 +1,327 @@
+package sol
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// Config says which endpoints to watch Solana through and how.
+type Config struct {
+	RPCURLs      []string      // JSON-RPC endpoints, best first
+	PollInterval time.Duration // how often watched accounts are checked
+	PageSize     int           // signatures asked for per getSignaturesForAddress call
+	MaxPages     int           // most pages read per account and poll, so a long catch up doesn't stall
+	StartBack    int           // latest transactions read for an account seen for the first time
+	KeepFor      time.Duration // how long found transfers are kept for matching
+	MaxBackoff   time.Duration // longest wait before retrying an account that failed
+
+	// WSURL is a websocket endpoint to subscribe to the watched accounts
+	// through, empty to only poll. While subscribed, accounts are scanned
+	// when a transaction mentions them and polled every ResyncInterval.
+	WSURL          string
+	ResyncInterval time.Duration
+}
+
+// DefaultConfig uses the public mainnet endpoint, which is heavily rate
+// limited. A private endpoint in front of it is preferred.
+func DefaultConfig() Config {
+	return Config{
+		RPCURLs:      []string{DefaultRPC},
+		PollInterval: 20 * time.Second,
+		PageSize:     100,
+		MaxPages:     10,
+		StartBack:    10,
+		KeepFor:      48 * time.Hour,
+		MaxBackoff:   10 * time.Minute,
+
+		ResyncInterval: 5 * time.Minute,
+	}
+}
+
+// ReadRPCList reads JSON-RPC endpoint URLs from a file, one per line, best
+// first. Blank lines and lines starting with # are skipped.
+func ReadRPCList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no rpc urls in %s", path)
+	}
+	return urls, nil
+}
+
+// ValidateAddress checks that address is a base58 encoded public key.
+func ValidateAddress(address string) error {
+	if _, err := solana.PublicKeyFromBase58(address); err != nil {
+		return fmt.Errorf("%q is not a Solana address: %v", address, err)
+	}
+	return nil
+}
+
+// Transfer is SOL or an SPL token received by a watched address.
+type Transfer struct {
+	Currency  string
+	To        string   // owner of the receiving account
+	Amount    *big.Int // atomic units of Currency
+	Signature string
+	Slot      uint64
+	accounts  []string // every account of the transaction, to find references in
+	foundAt   time.Time
+	donoID    int // dono the transfer was matched to, 0 while unclaimed
+}
+
+// Provider accepts SOL and SPL tokens sent to each user's Solana address.
+// It follows every address, and the address's associated token accounts,
+// through getSignaturesForAddress with a cursor saved per account, polling
+// them or, with a websocket endpoint, scanning them when notified.
+type Provider struct {
+	cfg     Config
+	client  *Client
+	cursors payments.Cursors
+
+	mu        sync.Mutex
+	owners    map[int]string // user ID to Solana address
+	transfers []Transfer
+	retry     map[string]backoff // accounts that failed, by account
+
+	live  atomic.Bool               // websocket subscribed
+	wake  chan struct{}             // signalled when woken has accounts
+	woken map[string]watchedAccount // accounts to scan now, by account
+}
+
+// New returns a Solana provider. Scan cursors are kept in cursors.
+func New(cfg Config, cursors payments.Cursors) *Provider {
+	return &Provider{
+		cfg:     cfg,
+		client:  NewClient(cfg.RPCURLs...),
+		cursors: cursors,
+		owners:  map[int]string{},
+		retry:   map[string]backoff{},
+		wake:    make(chan struct{}, 1),
+		woken:   map[string]watchedAccount{},
+	}
+}
+
+func (p *Provider) Name() string {
+	return "solana"
+}
+
+func (p *Provider) Currencies() []string {
+	return utils.CurrencyCodesForChain(utils.ChainSolana)
+}
+
+// Start begins watching the Solana addresses of the given users.
+func (p *Provider) Start(users []utils.User) {
+	for _, user := range users {
+		p.SetUser(user)
+	}
+	log.Println("solana: watching", len(p.watched()), "accounts via", p.cfg.RPCURLs)
+	go p.run()
+	if p.cfg.WSURL != "" {
+		go p.listen()
+	}
+}
+
+// SetUser starts watching the user's current address. Addresses that are
+// not valid public keys are skipped.
+func (p *Provider) SetUser(user utils.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if user.SolAddress == "" {
+		delete(p.owners, user.UserID)
+		return
+	}
+	if err := ValidateAddress(user.SolAddress); err != nil {
+		log.Println("solana: not watching user", user.UserID, err)
+		delete(p.owners, user.UserID)
+		return
+	}
+	p.owners[user.UserID] = user.SolAddress
+}
+
+// CreatePaymentRequest asks for the exact amount chosen. Each dono gets its
+// own Solana Pay reference key in the URI, which the donor's wallet adds to
+// the transaction, so the payment is found by the reference.
+func (p *Provider) CreatePaymentRequest(user utils.User, currency string, amount float64) (payments.PaymentRequest, error) {
+	c, ok := utils.GetCurrency(currency)
+	if !ok || c.Chain != utils.ChainSolana {
+		return payments.PaymentRequest{}, fmt.Errorf("%s is not paid on Solana", currency)
+	}
+	if err := ValidateAddress(user.SolAddress); err != nil {
+		return payments.PaymentRequest{}, err
+	}
+	atomic := utils.AtomicFromFloat(amount, c.Code)
+	donoStr := utils.FormatAtomic(atomic, c.Code)
+
+	reference, err := newReference()
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+
+	req := payments.PaymentRequest{
+		Currency:        c.Code,
+		Address:         user.SolAddress,
+		PayID:           user.SolAddress,
+		Reference:       reference,
+		Amount:          donoStr,
+		Atomic:          atomic,
+		ContractAddress: c.Contract,
+		URI:             payments.SolanaPayURI(user.SolAddress, donoStr, c.Contract, reference),
+	}
+	return req, nil
+}
+
+// newReference returns a random public key to use as a Solana Pay
+// reference. It never signs anything, so no private key is kept.
+func newReference() (string, error) {
+	key := make([]byte, solana.PublicKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return solana.PublicKeyFromBytes(key).String(), nil
+}
+
+// Poll does nothing, the accounts are followed in the background since
+// Start.
+func (p *Provider) Poll(donos []utils.Dono) error {
+	return nil
+}
+
+// Match looks for the dono's payment among the finalized transfers to the
+// watched accounts: by its reference, or for donos from before references
+// by the exact amount. A dono with a reference that isn't there yet is
+// looked up on chain by the reference, which also finds payments that are
+// only confirmed.
+func (p *Provider) Match(dono utils.Dono) (payments.Match, error) {
+	if match, ok := p.matchWatched(dono); ok {
+		return match, nil
+	}
+	if dono.Reference != "" {
+		return p.matchReference(dono)
+	}
+	return payments.Match{}, nil
+}
+
+func (p *Provider) matchWatched(dono utils.Dono) (payments.Match, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.transfers {
+		if t.To != dono.Address || t.Currency != dono.CurrencyType {
+			continue
+		}
+		if dono.Reference != "" {
+			if !hasAccount(t.accounts, dono.Reference) || t.Amount.Cmp(dono.AtomicToSend) < 0 {
+				continue
+			}
+		} else if t.Amount.Cmp(dono.AtomicToSend) != 0 {
+			continue
+		}
+		if t.donoID != 0 && t.donoID != dono.ID {
+			continue // already paid another dono
+		}
+		p.transfers[i].donoID = dono.ID
+		log.Println("solana: dono", dono.ID, "paid in", t.Signature)
+		return payments.Match{
+			Found:         true,
+			Seen:          true,
+			AmountSent:    new(big.Int).Set(t.Amount),
+			Confirmations: 1,
+			TxHash:        t.Signature,
+			LogIndex:      -1,
+		}, true
+	}
+	return payments.Match{}, false
+}
+
+// matchReference finds a successful transaction carrying the dono's
+// reference that paid the dono's address at least the amount asked. It is
+// seen once confirmed and found once finalized. A finalized one that paid
+// less is reported as Partial if nothing paid in full.
+func (p *Provider) matchReference(dono utils.Dono) (payments.Match, error) {
+	sigs, err := p.client.SignaturesForAddress(dono.Reference, SignatureOptions{Limit: 10, Commitment: "confirmed"})
+	if err != nil {
+		return payments.Match{}, err
+	}
+	partial := payments.Match{}
+	for _, sig := range sigs {
+		if sig.Err != nil {
+			continue
+		}
+		tx, err := p.client.Transaction(sig.Signature, "confirmed")
+		if err != nil {
+			return payments.Match{}, err
+		}
+		if tx == nil || tx.Meta.Err != nil {
+			continue
+		}
+		received := received(tx, dono.Address, dono.CurrencyType)
+		finalized := sig.ConfirmationStatus == "finalized"
+		if received.Sign() == 0 {
+			continue
+		}
+		if received.Cmp(dono.AtomicToSend) < 0 {
+			if finalized && !partial.Partial {
+				partial = payments.Match{Partial: true, AmountSent: received, Confirmations: 1, TxHash: sig.Signature, LogIndex: -1}
+			}
+			continue
+		}
+		log.Println("Solana reference", dono.Reference, "paid in", sig.Signature, sig.ConfirmationStatus)
+		confirmations := 0
+		if finalized {
+			confirmations = 1
+		}
+		return payments.Match{
+			Found:         finalized,
+			Seen:          true,
+			AmountSent:    received,
+			Confirmations: confirmations,
+			TxHash:        sig.Signature,
+			LogIndex:      -1,
+		}, nil
+	}
+	return partial, nil
+}
+
+// received returns what owner got of a currency in a transaction.
+func received(tx *RPCTransaction, owner string, currency string) *big.Int {
+	if c, ok := utils.GetCurrency(currency); ok && c.Contract != "" {
+		return tx.TokenReceived(owner, c.Contract)
+	}
+	return tx.Received(owner)
+}
+
+func hasAccount(accounts []string, account string) bool {
+	for _, a := range accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package sol
+
+import (
+	"fmt"
+	"log"
+	"shadowchat/utils"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// watchedAccount is an account whose transactions are read: a user's
+// address for SOL, or one of its associated token accounts for a token.
+type watchedAccount struct {
+	Account  string
+	Owner    string
+	Currency utils.Currency
+}
+
+// backoff is when an account that failed is tried again.
+type backoff struct {
+	failures int
+	next     time.Time
+}
+
+// cursorKey is where the newest signature read for an account is saved.
+func cursorKey(account string) string {
+	return "solana:" + account
+}
+
+// run scans every watched account each PollInterval, or only each
+// ResyncInterval while the websocket is subscribed, and scans woken accounts
+// as soon as they are woken.
+func (p *Provider) run() {
+	var lastScan time.Time
+	for {
+		interval := p.cfg.PollInterval
+		if p.isLive() {
+			interval = p.cfg.ResyncInterval
+		}
+		if time.Since(lastScan) >= interval {
+			p.scanAll()
+			lastScan = time.Now()
+		}
+
+		select {
+		case <-p.wake:
+			for _, w := range p.takeWoken() {
+				p.scanAccount(w)
+			}
+		case <-time.After(p.cfg.PollInterval):
+		}
+	}
+}
+
+// scanAll reads the new transactions of every watched account. An account
+// that fails is retried after a backoff that doubles with each failure, the
+// others carry on.
+func (p *Provider) scanAll() {
+	for _, w := range p.watched() {
+		p.scanAccount(w)
+	}
+	p.prune()
+}
+
+// scanAccount scans an account unless it is waiting to be retried.
+func (p *Provider) scanAccount(w watchedAccount) {
+	p.mu.Lock()
+	b := p.retry[w.Account]
+	p.mu.Unlock()
+	if time.Now().Before(b.next) {
+		return
+	}
+
+	err := p.scan(w)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		b.failures++
+		delay := p.cfg.PollInterval << uint(b.failures)
+		if delay > p.cfg.MaxBackoff || delay <= 0 {
+			delay = p.cfg.MaxBackoff
+		}
+		b.next = time.Now().Add(delay)
+		p.retry[w.Account] = b
+		log.Println("solana: scanning", w.Currency.Code, "account", w.Account, "failed, retrying in", delay, "-", err)
+	} else {
+		delete(p.retry, w.Account)
+	}
+}
+
+// scan reads the finalized transactions of an account newer than its
+// cursor, oldest first, moving the cursor past each one read so a failure
+// picks up where it stopped.
+func (p *Provider) scan(w watchedAccount) error {
+	key := cursorKey(w.Account)
+	until, seenBefore := p.cursors.Cursor(key)
+
+	sigs := []SignatureInfo{}
+	opts := SignatureOptions{Limit: p.cfg.PageSize, Until: until, Commitment: "finalized"}
+	if !seenBefore {
+		opts.Limit = p.cfg.StartBack
+	}
+	for page := 0; page < p.cfg.MaxPages; page++ {
+		batch, err := p.client.SignaturesForAddress(w.Account, opts)
+		if err != nil {
+			return err
+		}
+		sigs = append(sigs, batch...)
+		if !seenBefore || len(batch) < opts.Limit {
+			break
+		}
+		opts.Before = batch[len(batch)-1].Signature
+		if page == p.cfg.MaxPages-1 {
+			log.Println("solana: more than", len(sigs), "new transactions on", w.Account, "reading the latest only")
+		}
+	}
+
+	for i := len(sigs) - 1; i >= 0; i-- {
+		sig := sigs[i]
+		if sig.Err == nil {
+			tx, err := p.client.Transaction(sig.Signature, "finalized")
+			if err != nil {
+				return err
+			}
+			if tx == nil {
+				return fmt.Errorf("transaction %s not found", sig.Signature)
+			}
+			if tx.Meta.Err == nil {
+				p.addTransfer(w, sig, tx)
+			}
+		}
+		if err := p.cursors.SetCursor(key, sig.Signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Provider) addTransfer(w watchedAccount, sig SignatureInfo, tx *RPCTransaction) {
+	amount := received(tx, w.Owner, w.Currency.Code)
+	if amount.Sign() == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.transfers {
+		if t.Signature == sig.Signature && t.Currency == w.Currency.Code {
+			return
+		}
+	}
+	log.Println("solana transfer found:", utils.FormatAtomic(amount, w.Currency.Code), w.Currency.Code, "to", w.Owner, "in", sig.Signature)
+	p.transfers = append(p.transfers, Transfer{
+		Currency:  w.Currency.Code,
+		To:        w.Owner,
+		Amount:    amount,
+		Signature: sig.Signature,
+		Slot:      sig.Slot,
+		accounts:  tx.AccountKeys(),
+		foundAt:   time.Now(),
+	})
+}
+
+func (p *Provider) prune() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	kept := p.transfers[:0]
+	for _, t := range p.transfers {
+		if time.Since(t.foundAt) <= p.cfg.KeepFor {
+			kept = append(kept, t)
+		}
+	}
+	p.transfers = kept
+}
+
+// watched returns every account to read: each user's address for SOL and
+// its associated token account for every SPL token in the registry. Token
+// transfers only touch the token account, not the owner's address.
+func (p *Provider) watched() []watchedAccount {
+	p.mu.Lock()
+	owners := make([]string, 0, len(p.owners))
+	seen := map[string]bool{}
+	for _, owner := range p.owners {
+		if !seen[owner] {
+			seen[owner] = true
+			owners = append(owners, owner)
+		}
+	}
+	p.mu.Unlock()
+
+	accounts := []watchedAccount{}
+	for _, owner := range owners {
+		ownerKey, err := solana.PublicKeyFromBase58(owner)
+		if err != nil {
+			continue
+		}
+		for _, c := range utils.Currencies {
+			if c.Chain != utils.ChainSolana {
+				continue
+			}
+			if c.Contract == "" {
+				accounts = append(accounts, watchedAccount{Account: owner, Owner: owner, Currency: c})
+				continue
+			}
+			mint, err := solana.PublicKeyFromBase58(c.Contract)
+			if err != nil {
+				continue
+			}
+			ata, _, err := solana.FindAssociatedTokenAddress(ownerKey, mint)
+			if err != nil {
+				continue
+			}
+			accounts = append(accounts, watchedAccount{Account: ata.String(), Owner: owner, Currency: c})
+		}
+	}
+	return accounts
+}
@@ -0,0 +1,142 @@
+package sol
+
+import (
+	"math/big"
+	"shadowchat/utils"
+	"testing"
+	"time"
+)
+
+// solTx returns a transaction in which owner gets lamports.
+func solTx(signature string, lamports uint64) *RPCTransaction {
+	tx := &RPCTransaction{}
+	tx.Transaction.Signatures = []string{signature}
+	tx.Transaction.Message.AccountKeys = []string{payer, owner}
+	tx.Meta.PreBalances = []uint64{10000000000, 1000000000}
+	tx.Meta.PostBalances = []uint64{10000000000 - lamports - 5000, 1000000000 + lamports}
+	return tx
+}
+
+// solAccount returns the watched account of owner's SOL.
+func solAccount(t *testing.T, p *Provider) watchedAccount {
+	t.Helper()
+	for _, w := range p.watched() {
+		if w.Currency.Code == "SOL" {
+			return w
+		}
+	}
+	t.Fatal("owner's SOL account is not watched")
+	return watchedAccount{}
+}
+
+func solDono(id int, lamports int64) utils.Dono {
+	return utils.Dono{ID: id, Address: owner, CurrencyType: "SOL", AtomicToSend: big.NewInt(lamports)}
+}
+
+func TestScanFollowsAccountCursor(t *testing.T) {
+	rpc, rpcURL := newFakeRPC(t)
+	rpc.add(owner, 1, solTx("sig1", 1000))
+	rpc.add(owner, 2, solTx("sig2", 2000))
+	p := newTestProvider(rpcURL, "")
+	p.cfg.PageSize = 2
+	w := solAccount(t, p)
+
+	p.scanAccount(w)
+	if until := rpc.lastUntil(); until != "" {
+		t.Errorf("first scan read until %q, want the latest StartBack", until)
+	}
+	if cursor, _ := p.cursors.Cursor(cursorKey(owner)); cursor != "sig2" {
+		t.Errorf("cursor = %q, want the newest signature sig2", cursor)
+	}
+	for i, lamports := range []int64{1000, 2000} {
+		if m, _ := p.Match(solDono(i+1, lamports)); !m.Found {
+			t.Errorf("transfer of %d lamports not matched", lamports)
+		}
+	}
+
+	// three new transactions take two pages of two
+	rpc.add(owner, 3, solTx("sig3", 3000))
+	rpc.add(owner, 4, solTx("sig4", 4000))
+	rpc.add(owner, 5, solTx("sig5", 5000))
+	calls := rpc.calls.Load()
+	p.scanAccount(w)
+	if until := rpc.lastUntil(); until != "sig2" {
+		t.Errorf("second scan read until %q, want the cursor sig2", until)
+	}
+	if n := rpc.calls.Load() - calls; n != 2 {
+		t.Errorf("second scan read %d pages, want 2", n)
+	}
+	if cursor, _ := p.cursors.Cursor(cursorKey(owner)); cursor != "sig5" {
+		t.Errorf("cursor = %q, want sig5", cursor)
+	}
+	for i, lamports := range []int64{3000, 4000, 5000} {
+		if m, _ := p.Match(solDono(i+3, lamports)); !m.Found {
+			t.Errorf("transfer of %d lamports not matched", lamports)
+		}
+	}
+
+	// the token accounts keep cursors of their own
+	for _, token := range p.watched() {
+		if token.Account == owner {
+			continue
+		}
+		p.scanAccount(token)
+		if until := rpc.lastUntil(); until != "" {
+			t.Errorf("%s account read until %q, the SOL account's cursor", token.Currency.Code, until)
+		}
+		if _, ok := p.cursors.Cursor(cursorKey(token.Account)); ok {
+			t.Errorf("%s account has a cursor without transactions", token.Currency.Code)
+		}
+	}
+}
+
+func TestFailedAccountBacksOff(t *testing.T) {
+	rpc, rpcURL := newFakeRPC(t)
+	rpc.add(owner, 1, solTx("sig1", 1000))
+	p := newTestProvider(rpcURL, "")
+	w := solAccount(t, p)
+	retry := func() backoff {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.retry[w.Account]
+	}
+	waitRetry := func() {
+		time.Sleep(time.Until(retry().next) + 5*time.Millisecond)
+	}
+
+	rpc.failing.Store(true)
+	start := time.Now()
+	p.scanAccount(w)
+	b := retry()
+	if b.failures != 1 || b.next.Sub(start) < 2*p.cfg.PollInterval {
+		t.Fatalf("after a failure: %+v, want a retry %v later", b, 2*p.cfg.PollInterval)
+	}
+	calls := rpc.calls.Load()
+	p.scanAccount(w)
+	if rpc.calls.Load() != calls {
+		t.Error("account scanned again before its backoff ran out")
+	}
+
+	waitRetry()
+	p.scanAccount(w)
+	waitRetry()
+	start = time.Now()
+	p.scanAccount(w)
+	b = retry()
+	if b.failures != 3 || b.next.Sub(start) > p.cfg.MaxBackoff+10*time.Millisecond {
+		t.Errorf("after 3 failures: %+v, want a retry at most MaxBackoff %v later", b, p.cfg.MaxBackoff)
+	}
+
+	rpc.failing.Store(false)
+	waitRetry()
+	p.scanAccount(w)
+	p.mu.Lock()
+	_, failing := p.retry[w.Account]
+	p.mu.Unlock()
+	if failing {
+		t.Error("account still backing off after a successful scan")
+	}
+	if m, _ := p.Match(solDono(1, 1000)); !m.Found {
+		t.Error("transfer not matched once the account recovered")
+	}
+}
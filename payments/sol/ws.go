@@ -0,0 +1,236 @@
+package sol
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingInterval is how often the websocket is pinged. Public endpoints close
+// sockets that stay quiet for about a minute.
+const pingInterval = 30 * time.Second
+
+// WebsocketURL returns the websocket endpoint served next to a JSON-RPC
+// endpoint, which is the same URL with the ws or wss scheme.
+func WebsocketURL(rpcURL string) (string, error) {
+	u, err := url.Parse(rpcURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("%q is not an http or websocket url", rpcURL)
+	}
+	return u.String(), nil
+}
+
+// wsMessage is anything the websocket sends: a response to a subscribe or
+// unsubscribe request, or a notification for a subscription.
+type wsMessage struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Method string `json:"method"`
+	Params struct {
+		Subscription int `json:"subscription"`
+		Result       struct {
+			Value struct {
+				Signature string      `json:"signature"`
+				Err       interface{} `json:"err"`
+			} `json:"value"`
+		} `json:"result"`
+	} `json:"params"`
+}
+
+// listen keeps a websocket subscription open to every watched account and
+// scans an account as soon as a transaction mentioning it is finalized.
+// While it is down the accounts are polled every PollInterval again, and it
+// reconnects after a backoff that doubles with each failed attempt.
+func (p *Provider) listen() {
+	failures := 0
+	for {
+		subscribed, err := p.listenOnce()
+		p.live.Store(false)
+		if subscribed {
+			failures = 0
+		}
+		failures++
+		delay := p.cfg.PollInterval << uint(failures-1)
+		if delay > p.cfg.MaxBackoff || delay <= 0 {
+			delay = p.cfg.MaxBackoff
+		}
+		log.Println("solana: websocket", p.cfg.WSURL, "down, polling and reconnecting in", delay, "-", err)
+		time.Sleep(delay)
+	}
+}
+
+// listenOnce subscribes to the watched accounts over one connection until it
+// fails. It tells whether any subscription was made.
+func (p *Provider) listenOnce() (bool, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(p.cfg.WSURL, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+
+	messages := make(chan wsMessage)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				readErr <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+			select {
+			case messages <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var id int64
+	pending := map[int64]watchedAccount{}     // subscribe requests by request ID
+	accounts := map[string]int{}              // subscription ID by account, -1 while pending
+	subscriptions := map[int]watchedAccount{} // accounts by subscription ID
+	subscribed := false
+
+	// resubscribe subscribes to accounts that started being watched and
+	// drops the ones that stopped.
+	resubscribe := func() error {
+		current := map[string]bool{}
+		for _, w := range p.watched() {
+			current[w.Account] = true
+			if _, ok := accounts[w.Account]; ok {
+				continue
+			}
+			id++
+			err := conn.WriteJSON(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      id,
+				"method":  "logsSubscribe",
+				"params":  []interface{}{map[string]interface{}{"mentions": []string{w.Account}}, map[string]string{"commitment": "finalized"}},
+			})
+			if err != nil {
+				return err
+			}
+			pending[id] = w
+			accounts[w.Account] = -1
+		}
+		for account, sub := range accounts {
+			if current[account] || sub < 0 {
+				continue
+			}
+			id++
+			err := conn.WriteJSON(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      id,
+				"method":  "logsUnsubscribe",
+				"params":  []interface{}{sub},
+			})
+			if err != nil {
+				return err
+			}
+			delete(accounts, account)
+			delete(subscriptions, sub)
+		}
+		return nil
+	}
+
+	if err := resubscribe(); err != nil {
+		return false, err
+	}
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			return subscribed, err
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return subscribed, err
+			}
+			if err := resubscribe(); err != nil {
+				return subscribed, err
+			}
+		case msg := <-messages:
+			if msg.Method == "logsNotification" {
+				w, ok := subscriptions[msg.Params.Subscription]
+				if ok && msg.Params.Result.Value.Err == nil {
+					p.wakeAccount(w)
+				}
+				continue
+			}
+			w, ok := pending[msg.ID]
+			if !ok {
+				continue // an unsubscribe answered
+			}
+			delete(pending, msg.ID)
+			if msg.Error != nil {
+				return subscribed, fmt.Errorf("logsSubscribe %s: rpc error %d: %s", w.Account, msg.Error.Code, msg.Error.Message)
+			}
+			var sub int
+			if err := json.Unmarshal(msg.Result, &sub); err != nil {
+				return subscribed, err
+			}
+			accounts[w.Account] = sub
+			subscriptions[sub] = w
+			if !subscribed {
+				subscribed = true
+				p.live.Store(true)
+				log.Println("solana: subscribed to transactions over", p.cfg.WSURL)
+			}
+			// anything that came in before the subscription is picked up now
+			p.wakeAccount(w)
+		}
+	}
+}
+
+// wakeAccount has the account scanned as soon as possible.
+func (p *Provider) wakeAccount(w watchedAccount) {
+	p.mu.Lock()
+	p.woken[w.Account] = w
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeWoken returns the accounts woken since it was last called.
+func (p *Provider) takeWoken() []watchedAccount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	woken := make([]watchedAccount, 0, len(p.woken))
+	for account, w := range p.woken {
+		woken = append(woken, w)
+		delete(p.woken, account)
+	}
+	return woken
+}
+
+// isLive tells whether the websocket is subscribed, so polling can slow down.
+func (p *Provider) isLive() bool {
+	return p.cfg.WSURL != "" && p.live.Load()
+}
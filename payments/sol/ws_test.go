@@ -0,0 +1,313 @@
+package sol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"shadowchat/payments/paymentstest"
+	"shadowchat/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gorilla/websocket"
+)
+
+// fakeWS is a websocket stand-in answering logsSubscribe and sending the
+// notifications the tests ask for.
+type fakeWS struct {
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	conns   int
+	subs    map[int]string // subscription ID to account, on the current connection
+	nextSub int
+	refuse  bool
+}
+
+func newFakeWS(t *testing.T) (*fakeWS, string) {
+	ws := &fakeWS{}
+	srv := httptest.NewServer(http.HandlerFunc(ws.serve))
+	t.Cleanup(srv.Close)
+	t.Cleanup(ws.drop)
+	return ws, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func (ws *fakeWS) serve(w http.ResponseWriter, r *http.Request) {
+	ws.mu.Lock()
+	refuse := ws.refuse
+	ws.mu.Unlock()
+	if refuse {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	ws.mu.Lock()
+	ws.conn = conn
+	ws.conns++
+	ws.subs = map[int]string{}
+	ws.mu.Unlock()
+
+	for {
+		var req struct {
+			ID     int64             `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if req.Method != "logsSubscribe" {
+			continue
+		}
+		var filter struct {
+			Mentions []string `json:"mentions"`
+		}
+		json.Unmarshal(req.Params[0], &filter)
+
+		ws.mu.Lock()
+		ws.nextSub++
+		ws.subs[ws.nextSub] = filter.Mentions[0]
+		conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": ws.nextSub})
+		ws.mu.Unlock()
+	}
+}
+
+func (ws *fakeWS) subscribed() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.subs)
+}
+
+func (ws *fakeWS) connections() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.conns
+}
+
+// notify sends a logsNotification for a transaction mentioning account,
+// failed if txErr is set.
+func (ws *fakeWS) notify(account string, txErr interface{}) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for sub, a := range ws.subs {
+		if a != account {
+			continue
+		}
+		ws.conn.WriteJSON(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "logsNotification",
+			"params": map[string]interface{}{
+				"subscription": sub,
+				"result":       map[string]interface{}{"value": map[string]interface{}{"signature": "sig", "err": txErr}},
+			},
+		})
+	}
+}
+
+// drop closes the current connection.
+func (ws *fakeWS) drop() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.conn != nil {
+		ws.conn.Close()
+		ws.conn = nil
+	}
+	ws.subs = map[int]string{}
+}
+
+func (ws *fakeWS) setRefuse(refuse bool) {
+	ws.mu.Lock()
+	ws.refuse = refuse
+	ws.mu.Unlock()
+}
+
+// fakeRPC is a JSON-RPC stand-in serving the signatures and transactions
+// tests add, newest first per account, and counting how often it is asked
+// for signatures. While failing it answers every call with an error.
+type fakeRPC struct {
+	calls   atomic.Int64
+	failing atomic.Bool
+
+	mu     sync.Mutex
+	sigs   map[string][]SignatureInfo // by account, newest first
+	txs    map[string]*RPCTransaction // by signature
+	untils []string                   // the until of each getSignaturesForAddress call
+}
+
+func newFakeRPC(t *testing.T) (*fakeRPC, string) {
+	rpc := &fakeRPC{sigs: map[string][]SignatureInfo{}, txs: map[string]*RPCTransaction{}}
+	srv := httptest.NewServer(http.HandlerFunc(rpc.serve))
+	t.Cleanup(srv.Close)
+	return rpc, srv.URL
+}
+
+func (rpc *fakeRPC) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int64             `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Method == "getSignaturesForAddress" {
+		rpc.calls.Add(1)
+	}
+	if rpc.failing.Load() {
+		http.Error(w, "down", http.StatusInternalServerError)
+		return
+	}
+
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	var result interface{}
+	switch req.Method {
+	case "getSignaturesForAddress":
+		var account string
+		var opts struct {
+			Limit  int    `json:"limit"`
+			Before string `json:"before"`
+			Until  string `json:"until"`
+		}
+		json.Unmarshal(req.Params[0], &account)
+		json.Unmarshal(req.Params[1], &opts)
+		rpc.untils = append(rpc.untils, opts.Until)
+
+		sigs := []SignatureInfo{}
+		started := opts.Before == ""
+		for _, sig := range rpc.sigs[account] {
+			if sig.Signature == opts.Until || len(sigs) == opts.Limit {
+				break
+			}
+			if started {
+				sigs = append(sigs, sig)
+			}
+			started = started || sig.Signature == opts.Before
+		}
+		result = sigs
+	case "getTransaction":
+		var signature string
+		json.Unmarshal(req.Params[0], &signature)
+		result = rpc.txs[signature]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+// add adds a transaction to the front of the signatures of an account.
+func (rpc *fakeRPC) add(account string, slot uint64, tx *RPCTransaction) {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	signature := tx.Transaction.Signatures[0]
+	tx.Slot = slot
+	rpc.txs[signature] = tx
+	sig := SignatureInfo{Signature: signature, Slot: slot, ConfirmationStatus: "finalized"}
+	rpc.sigs[account] = append([]SignatureInfo{sig}, rpc.sigs[account]...)
+}
+
+// lastUntil returns the until of the last getSignaturesForAddress call.
+func (rpc *fakeRPC) lastUntil() string {
+	rpc.mu.Lock()
+	defer rpc.mu.Unlock()
+	if len(rpc.untils) == 0 {
+		return ""
+	}
+	return rpc.untils[len(rpc.untils)-1]
+}
+
+var owner = solana.NewWallet().PublicKey().String()
+
+func newTestProvider(rpcURL, wsURL string) *Provider {
+	cfg := DefaultConfig()
+	cfg.RPCURLs = []string{rpcURL}
+	cfg.WSURL = wsURL
+	cfg.PollInterval = 20 * time.Millisecond
+	cfg.MaxBackoff = 100 * time.Millisecond
+	cfg.ResyncInterval = time.Hour
+	p := New(cfg, paymentstest.NewMemCursors(nil))
+	p.SetUser(utils.User{UserID: 1, SolAddress: owner})
+	return p
+}
+
+func TestNotificationWakesAccount(t *testing.T) {
+	ws, wsURL := newFakeWS(t)
+	_, rpcURL := newFakeRPC(t)
+	p := newTestProvider(rpcURL, wsURL)
+	watched := len(p.watched())
+
+	go p.listenOnce()
+	paymentstest.WaitFor(t, "every account is subscribed", func() bool { return ws.subscribed() == watched && p.isLive() })
+	// each subscription wakes its account once, for what came before it
+	woken := map[string]bool{}
+	paymentstest.WaitFor(t, "the subscribed accounts are woken", func() bool {
+		for _, w := range p.takeWoken() {
+			woken[w.Account] = true
+		}
+		return len(woken) == watched
+	})
+	select {
+	case <-p.wake:
+	default:
+	}
+
+	ws.notify(owner, nil)
+	select {
+	case <-p.wake:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification didn't wake the provider")
+	}
+	if got := p.takeWoken(); len(got) != 1 || got[0].Account != owner || got[0].Currency.Code != "SOL" {
+		t.Errorf("woken = %+v, want the SOL account", got)
+	}
+
+	ws.notify(owner, map[string]interface{}{"InstructionError": []interface{}{0, "Custom"}})
+	select {
+	case <-p.wake:
+		t.Errorf("a failed transaction woke %+v", p.takeWoken())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestResubscribesAfterDrop(t *testing.T) {
+	ws, wsURL := newFakeWS(t)
+	_, rpcURL := newFakeRPC(t)
+	p := newTestProvider(rpcURL, wsURL)
+	watched := len(p.watched())
+
+	go p.listen()
+	paymentstest.WaitFor(t, "every account is subscribed", func() bool { return ws.subscribed() == watched && p.isLive() })
+
+	ws.drop()
+	paymentstest.WaitFor(t, "the drop is noticed", func() bool { return !p.isLive() })
+	paymentstest.WaitFor(t, "every account is subscribed again", func() bool {
+		return ws.connections() == 2 && ws.subscribed() == watched && p.isLive()
+	})
+}
+
+func TestPollsWhileWebsocketIsDown(t *testing.T) {
+	ws, wsURL := newFakeWS(t)
+	rpc, rpcURL := newFakeRPC(t)
+	p := newTestProvider(rpcURL, wsURL)
+	watched := len(p.watched())
+
+	go p.run()
+	go p.listen()
+	paymentstest.WaitFor(t, "every account is subscribed", func() bool { return ws.subscribed() == watched && p.isLive() })
+
+	// while subscribed, accounts are only scanned when woken
+	time.Sleep(100 * time.Millisecond)
+	rpc.calls.Store(0)
+	time.Sleep(200 * time.Millisecond)
+	if n := rpc.calls.Load(); n != 0 {
+		t.Errorf("scanned %d times while subscribed and not woken", n)
+	}
+
+	ws.setRefuse(true)
+	ws.drop()
+	paymentstest.WaitFor(t, "the drop is noticed", func() bool { return !p.isLive() })
+	rpc.calls.Store(0)
+	paymentstest.WaitFor(t, "the accounts are polled", func() bool { return rpc.calls.Load() >= int64(2*watched) })
+}
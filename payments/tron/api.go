@@ -0,0 +1,160 @@
+package tron
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPI is the public TronGrid API. Without an API key it is rate
+// limited.
+const DefaultAPI = "https://api.trongrid.io"
+
+// ReadAPI reads the API to use from a file holding its URL, optionally
+// followed by an API key, on one line, e.g.
+// "https://api.trongrid.io 0123-abcd". Blank lines and lines starting with
+// # are skipped.
+func ReadAPI(path string) (*Client, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s: want an API URL and an optional key, got %q", path, line)
+		}
+		u, err := url.Parse(fields[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%s: %q is not an http or https url", path, fields[0])
+		}
+		key := ""
+		if len(fields) == 2 {
+			key = fields[1]
+		}
+		return NewClient(fields[0], key), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no API url in %s", path)
+}
+
+// Client reads TRC-20 transfers from a TronGrid compatible HTTP API.
+type Client struct {
+	URL    string
+	apiKey string
+	http   *http.Client
+}
+
+// NewClient returns a client for the API at url. The key, if any, is sent
+// as TRON-PRO-API-KEY.
+func NewClient(url string, apiKey string) *Client {
+	return &Client{URL: strings.TrimRight(url, "/"), apiKey: apiKey, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// TRC20Transfer is a TRC-20 Transfer event as the API returns it, with
+// base58 addresses.
+type TRC20Transfer struct {
+	TransactionID  string `json:"transaction_id"`
+	BlockTimestamp int64  `json:"block_timestamp"` // milliseconds
+	From           string `json:"from"`
+	To             string `json:"to"`
+	Type           string `json:"type"`
+	Value          string `json:"value"` // atomic units of the token
+	TokenInfo      struct {
+		Address string `json:"address"`
+	} `json:"token_info"`
+}
+
+// Transfers returns a page of the confirmed TRC-20 transfers to address
+// from minTimestamp (milliseconds) on, oldest first, and the fingerprint of
+// the next page, empty on the last one.
+func (c *Client) Transfers(address string, minTimestamp int64, fingerprint string, limit int) ([]TRC20Transfer, string, error) {
+	query := url.Values{}
+	query.Set("only_to", "true")
+	query.Set("only_confirmed", "true")
+	query.Set("order_by", "block_timestamp,asc")
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("min_timestamp", strconv.FormatInt(minTimestamp, 10))
+	if fingerprint != "" {
+		query.Set("fingerprint", fingerprint)
+	}
+
+	var resp struct {
+		Data    []TRC20Transfer `json:"data"`
+		Success bool            `json:"success"`
+		Error   string          `json:"error"`
+		Meta    struct {
+			Fingerprint string `json:"fingerprint"`
+		} `json:"meta"`
+	}
+	if err := c.get("/v1/accounts/"+url.PathEscape(address)+"/transactions/trc20?"+query.Encode(), &resp); err != nil {
+		return nil, "", fmt.Errorf("trc20 transfers of %s: %v", address, err)
+	}
+	if !resp.Success {
+		return nil, "", fmt.Errorf("trc20 transfers of %s: %s", address, resp.Error)
+	}
+	return resp.Data, resp.Meta.Fingerprint, nil
+}
+
+// Event is an event a contract emitted in a transaction, as the API
+// returns it. Addresses in Result are hex, e.g. a Transfer's "from" and
+// "to".
+type Event struct {
+	EventIndex      int               `json:"event_index"`
+	EventName       string            `json:"event_name"`
+	ContractAddress string            `json:"contract_address"` // base58
+	Result          map[string]string `json:"result"`
+}
+
+// Events returns the events emitted in a confirmed transaction.
+func (c *Client) Events(txID string) ([]Event, error) {
+	var resp struct {
+		Data    []Event `json:"data"`
+		Success bool    `json:"success"`
+		Error   string  `json:"error"`
+	}
+	if err := c.get("/v1/transactions/"+url.PathEscape(txID)+"/events?only_confirmed=true", &resp); err != nil {
+		return nil, fmt.Errorf("events of %s: %v", txID, err)
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("events of %s: %s", txID, resp.Error)
+	}
+	return resp.Data, nil
+}
+
+func (c *Client) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("TRON-PRO-API-KEY", c.apiKey)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("non-200 response code received: %d %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,429 @@
+package tron
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+)
+
+// Config says how the streamers' Tron addresses are watched.
+type Config struct {
+	PollInterval time.Duration // how often watched addresses are checked
+	PageSize     int           // transfers asked for per call
+	MaxPages     int           // most pages read per address and poll, so a long catch up doesn't stall
+	StartBack    time.Duration // how far back to read for an address seen for the first time
+	KeepFor      time.Duration // how long found transfers are kept for matching
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PollInterval: 20 * time.Second,
+		PageSize:     200,
+		MaxPages:     5,
+		StartBack:    time.Hour,
+		KeepFor:      48 * time.Hour,
+	}
+}
+
+// addressVersion is the first byte of every Tron mainnet address.
+const addressVersion = 0x41
+
+// ValidateAddress checks that address is a base58check Tron address
+// (T...).
+func ValidateAddress(address string) error {
+	decoded, version, err := base58.CheckDecode(address)
+	if err != nil || version != addressVersion || len(decoded) != 20 {
+		return fmt.Errorf("%q is not a Tron address", address)
+	}
+	return nil
+}
+
+// hexAddress returns the 20 byte hex form of a base58 address, as event
+// results have it, e.g. "0x1234...".
+func hexAddress(address string) string {
+	decoded, _, err := base58.CheckDecode(address)
+	if err != nil {
+		return ""
+	}
+	return "0x" + hex.EncodeToString(decoded)
+}
+
+// sameHexAddress compares hex addresses with or without the 41 version
+// byte.
+func sameHexAddress(a, b string) bool {
+	trim := func(s string) string {
+		s = strings.TrimPrefix(strings.ToLower(s), "0x")
+		if len(s) == 42 {
+			s = strings.TrimPrefix(s, "41")
+		}
+		return s
+	}
+	return a != "" && trim(a) == trim(b)
+}
+
+// Transfer is a TRC-20 token received by a watched address.
+type Transfer struct {
+	Currency  string
+	To        string
+	Value     *big.Int // atomic units of Currency
+	TxID      string
+	Index     int   // index of the Transfer event in the transaction
+	Timestamp int64 // block time, milliseconds
+	foundAt   time.Time
+	donoID    int // dono the transfer was matched to, 0 while unclaimed
+}
+
+// savedTransfer is a Transfer as kept in the cursors.
+type savedTransfer struct {
+	Transfer
+	FoundAt time.Time
+	DonoID  int
+}
+
+// Provider accepts TRC-20 tokens sent to each user's Tron address. Every
+// address is polled through the API for confirmed Transfer events, from a
+// timestamp saved per address. The donor pays a slightly fuzzed amount, as
+// on Ethereum, since all of a streamer's donos go to the same address.
+type Provider struct {
+	cfg     Config
+	client  *Client
+	cursors payments.Cursors
+	amounts *payments.Amounts
+
+	mu        sync.Mutex
+	addresses map[int]string // user ID to Tron address
+	transfers []Transfer
+}
+
+// New returns a Tron provider reading from client. The timestamp each
+// address has been read up to and the transfers found are kept in cursors.
+// Amounts are fuzzed clear of the ones pending donos wait for.
+func New(cfg Config, client *Client, cursors payments.Cursors, pending payments.PendingDonos) *Provider {
+	return &Provider{
+		cfg:       cfg,
+		client:    client,
+		cursors:   cursors,
+		amounts:   payments.NewAmounts(pending),
+		addresses: map[int]string{},
+	}
+}
+
+func (p *Provider) Name() string {
+	return "tron"
+}
+
+func (p *Provider) Currencies() []string {
+	return utils.CurrencyCodesForChain(utils.ChainTron)
+}
+
+// Start begins watching the Tron addresses of the given users, with the
+// transfers found before.
+func (p *Provider) Start(users []utils.User) {
+	for _, user := range users {
+		p.SetUser(user)
+	}
+	if err := p.loadTransfers(); err != nil {
+		log.Println("tron error loading saved transfers:", err)
+	}
+	go p.run()
+}
+
+func (p *Provider) SetUser(user utils.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if user.TronAddress == "" {
+		delete(p.addresses, user.UserID)
+		return
+	}
+	p.addresses[user.UserID] = user.TronAddress
+}
+
+func (p *Provider) CreatePaymentRequest(user utils.User, currency string, amount float64) (payments.PaymentRequest, error) {
+	c, ok := utils.GetCurrency(currency)
+	if !ok || c.Chain != utils.ChainTron {
+		return payments.PaymentRequest{}, fmt.Errorf("%s is not paid on Tron", currency)
+	}
+	if user.TronAddress == "" {
+		return payments.PaymentRequest{}, fmt.Errorf("user %d has no Tron address", user.UserID)
+	}
+
+	atomic, err := p.amounts.Fuzz(utils.AtomicFromFloat(amount, c.Code), c.Code, user.TronAddress)
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+	donoStr := utils.FormatAtomic(atomic, c.Code)
+	log.Println("tron CreatePaymentRequest() donoStr:", donoStr)
+
+	req := payments.PaymentRequest{
+		Currency:        c.Code,
+		Address:         user.TronAddress,
+		PayID:           user.TronAddress,
+		Amount:          donoStr,
+		Atomic:          atomic,
+		ContractAddress: c.Contract,
+		URI:             payments.TronURI(user.TronAddress, c.Contract, donoStr),
+	}
+	return req, nil
+}
+
+// Poll does nothing, the addresses are watched in the background since
+// Start.
+func (p *Provider) Poll(donos []utils.Dono) error {
+	return nil
+}
+
+// Match finds a transfer of exactly the dono's amount to its address. The
+// API only returns confirmed transfers, so a match is final.
+func (p *Provider) Match(dono utils.Dono) (payments.Match, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, t := range p.transfers {
+		if t.To != dono.Address || t.Currency != dono.CurrencyType {
+			continue
+		}
+		if t.Value.Cmp(dono.AtomicToSend) != 0 {
+			continue
+		}
+		if t.donoID != 0 && t.donoID != dono.ID {
+			continue // already paid another dono
+		}
+		p.transfers[i].donoID = dono.ID
+		log.Println("Matching Tron TX!", t.TxID)
+		return payments.Match{
+			Found:         true,
+			Seen:          true,
+			AmountSent:    new(big.Int).Set(t.Value),
+			Confirmations: 1,
+			TxHash:        t.TxID,
+			LogIndex:      t.Index,
+		}, nil
+	}
+	return payments.Match{}, nil
+}
+
+func (p *Provider) run() {
+	for {
+		for _, address := range p.watched() {
+			if err := p.scan(address); err != nil {
+				log.Println("tron scan error:", err)
+			}
+		}
+		time.Sleep(p.cfg.PollInterval)
+	}
+}
+
+func (p *Provider) watched() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, address := range p.addresses {
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+func cursorKey(address string) string {
+	return "tron:" + address
+}
+
+// The transfers found are saved next to the cursors, so ones found before a
+// restart, at timestamps the cursors have moved past, can still be matched
+// after it.
+const transfersKey = "tron:transfers"
+
+// scan reads the transfers to address since its saved timestamp and saves
+// the timestamp of the newest one read. Transfers at that timestamp are read
+// again next time and skipped as known.
+func (p *Provider) scan(address string) error {
+	from := time.Now().Add(-p.cfg.StartBack).UnixMilli()
+	if saved, ok := p.cursors.Cursor(cursorKey(address)); ok {
+		if ts, err := strconv.ParseInt(saved, 10, 64); err == nil {
+			from = ts
+		}
+	}
+
+	newest := from
+	found := []Transfer{}
+	fingerprint := ""
+	for page := 0; page < p.cfg.MaxPages; page++ {
+		transfers, next, err := p.client.Transfers(address, from, fingerprint, p.cfg.PageSize)
+		if err != nil {
+			return err
+		}
+		for _, t := range transfers {
+			if t.BlockTimestamp > newest {
+				newest = t.BlockTimestamp
+			}
+			if t.Type != "Transfer" || t.To != address {
+				continue
+			}
+			currency, ok := utils.CurrencyByContract(utils.ChainTron, t.TokenInfo.Address)
+			if !ok {
+				continue
+			}
+			value, ok := utils.ParseAtomicString(t.Value)
+			if !ok || value.Sign() == 0 {
+				continue
+			}
+			found = append(found, Transfer{
+				Currency:  currency.Code,
+				To:        t.To,
+				Value:     value,
+				TxID:      t.TransactionID,
+				Timestamp: t.BlockTimestamp,
+			})
+		}
+		if next == "" {
+			break
+		}
+		fingerprint = next
+	}
+
+	found = p.unknown(found)
+	if err := p.setEventIndexes(found); err != nil {
+		return err
+	}
+
+	// the transfers are saved before the cursor moves past them
+	p.addTransfers(found)
+	if err := p.saveTransfers(); err != nil {
+		return err
+	}
+	return p.cursors.SetCursor(cursorKey(address), strconv.FormatInt(newest, 10))
+}
+
+// unknown returns the transfers of transactions that paid nothing to the
+// same address before. All of a transaction's transfers share its
+// timestamp, so they are always read again together.
+func (p *Provider) unknown(found []Transfer) []Transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	known := make(map[string]bool, len(p.transfers))
+	for _, t := range p.transfers {
+		known[t.TxID+":"+t.To] = true
+	}
+	result := []Transfer{}
+	for _, t := range found {
+		if !known[t.TxID+":"+t.To] {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// setEventIndexes looks up which Transfer event of its transaction each
+// transfer is, which the transfers API doesn't say, so a transaction with
+// several transfers to the same address can pay several donos.
+func (p *Provider) setEventIndexes(found []Transfer) error {
+	byTx := map[string][]int{} // transaction ID -> positions in found
+	for i, t := range found {
+		byTx[t.TxID] = append(byTx[t.TxID], i)
+	}
+	for txID, positions := range byTx {
+		events, err := p.client.Events(txID)
+		if err != nil {
+			return err
+		}
+		used := map[int]bool{}
+		for _, i := range positions {
+			t := &found[i]
+			c, _ := utils.GetCurrency(t.Currency)
+			index := -1
+			for _, e := range events {
+				if used[e.EventIndex] || e.EventName != "Transfer" || e.ContractAddress != c.Contract {
+					continue
+				}
+				if !sameHexAddress(e.Result["to"], hexAddress(t.To)) || e.Result["value"] != t.Value.String() {
+					continue
+				}
+				index = e.EventIndex
+				break
+			}
+			if index < 0 {
+				return fmt.Errorf("no Transfer event of %s %s to %s in %s", t.Value, t.Currency, t.To, txID)
+			}
+			used[index] = true
+			t.Index = index
+		}
+	}
+	return nil
+}
+
+// saveTransfers writes the transfers kept for matching to the cursors.
+func (p *Provider) saveTransfers() error {
+	p.mu.Lock()
+	saved := make([]savedTransfer, 0, len(p.transfers))
+	for _, t := range p.transfers {
+		saved = append(saved, savedTransfer{Transfer: t, FoundAt: t.foundAt, DonoID: t.donoID})
+	}
+	p.mu.Unlock()
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return p.cursors.SetCursor(transfersKey, string(data))
+}
+
+// loadTransfers reads back the transfers saveTransfers wrote.
+func (p *Provider) loadTransfers() error {
+	data, ok := p.cursors.Cursor(transfersKey)
+	if !ok {
+		return nil
+	}
+	var saved []savedTransfer
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transfers = p.transfers[:0]
+	for _, s := range saved {
+		t := s.Transfer
+		t.foundAt = s.FoundAt
+		t.donoID = s.DonoID
+		p.transfers = append(p.transfers, t)
+	}
+	return nil
+}
+
+// addTransfers keeps newly found transfers, skipping ones already known,
+// and forgets those older than KeepFor.
+func (p *Provider) addTransfers(found []Transfer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	known := make(map[string]bool, len(p.transfers))
+	kept := p.transfers[:0]
+	for _, t := range p.transfers {
+		if time.Since(t.foundAt) > p.cfg.KeepFor {
+			continue
+		}
+		kept = append(kept, t)
+		known[t.TxID+":"+strconv.Itoa(t.Index)] = true
+	}
+	for _, t := range found {
+		key := t.TxID + ":" + strconv.Itoa(t.Index)
+		if known[key] {
+			continue
+		}
+		known[key] = true
+		t.foundAt = time.Now()
+		log.Println("tron transfer found:", utils.FormatAtomic(t.Value, t.Currency), t.Currency, "to", t.To, "in", t.TxID)
+		kept = append(kept, t)
+	}
+	p.transfers = kept
+}
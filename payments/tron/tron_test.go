@@ -0,0 +1,200 @@
+package tron
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"shadowchat/payments/paymentstest"
+	"shadowchat/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+)
+
+func testAddress(b byte) string {
+	raw := make([]byte, 20)
+	raw[19] = b
+	return base58.CheckEncode(raw, addressVersion)
+}
+
+var (
+	watchedAddress = testAddress(1)
+	otherAddress   = testAddress(2)
+	usdt, _        = utils.GetCurrency("USDT_TRON")
+)
+
+// fakeGrid is a TronGrid stand-in serving the transfers and events the
+// tests add.
+type fakeGrid struct {
+	mu          sync.Mutex
+	transfers   []TRC20Transfer
+	events      map[string][]Event
+	eventsCalls int
+}
+
+func newFakeGrid(t *testing.T) (*fakeGrid, *httptest.Server) {
+	g := &fakeGrid{events: map[string][]Event{}}
+	srv := httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(srv.Close)
+	return g, srv
+}
+
+// pay adds a transaction at timestamp ts with a USDT transfer of each of
+// the values to its address. Its Transfer events are numbered from 1, with
+// an unrelated event in front of each.
+func (g *fakeGrid) pay(txID string, ts int64, to []string, values []int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range to {
+		t := TRC20Transfer{TransactionID: txID, BlockTimestamp: ts, From: otherAddress, To: to[i], Type: "Transfer", Value: strconv.FormatInt(values[i], 10)}
+		t.TokenInfo.Address = usdt.Contract
+		g.transfers = append(g.transfers, t)
+		g.events[txID] = append(g.events[txID],
+			Event{EventIndex: 2 * i, EventName: "Approval", ContractAddress: usdt.Contract},
+			Event{EventIndex: 2*i + 1, EventName: "Transfer", ContractAddress: usdt.Contract, Result: map[string]string{
+				"from":  hexAddress(otherAddress),
+				"to":    hexAddress(to[i]),
+				"value": strconv.FormatInt(values[i], 10),
+			}})
+	}
+}
+
+func (g *fakeGrid) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 5 && parts[1] == "accounts" && parts[4] == "trc20":
+		query := r.URL.Query()
+		min, _ := strconv.ParseInt(query.Get("min_timestamp"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		offset, _ := strconv.Atoi(query.Get("fingerprint"))
+		matching := []TRC20Transfer{}
+		for _, t := range g.transfers {
+			if t.To == parts[2] && t.BlockTimestamp >= min {
+				matching = append(matching, t)
+			}
+		}
+		sort.SliceStable(matching, func(i, j int) bool { return matching[i].BlockTimestamp < matching[j].BlockTimestamp })
+		page := matching[offset:]
+		next := ""
+		if len(page) > limit {
+			page = page[:limit]
+			next = strconv.Itoa(offset + limit)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": page, "success": true, "meta": map[string]string{"fingerprint": next}})
+	case len(parts) == 4 && parts[1] == "transactions" && parts[3] == "events":
+		g.eventsCalls++
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": g.events[parts[2]], "success": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestProvider(url string, cursors *paymentstest.MemCursors) *Provider {
+	cfg := DefaultConfig()
+	cfg.PageSize = 1
+	p := New(cfg, NewClient(url, ""), cursors, nil)
+	p.SetUser(utils.User{UserID: 1, TronAddress: watchedAddress})
+	return p
+}
+
+func testDono(id int, atomic int64) utils.Dono {
+	return utils.Dono{ID: id, Address: watchedAddress, CurrencyType: "USDT_TRON", AtomicToSend: big.NewInt(atomic)}
+}
+
+func TestHexAddress(t *testing.T) {
+	raw := make([]byte, 20)
+	raw[19] = 1
+	if got, want := hexAddress(watchedAddress), "0x"+hex.EncodeToString(raw); got != want {
+		t.Errorf("hexAddress = %s, want %s", got, want)
+	}
+	if !sameHexAddress("0x41"+hex.EncodeToString(raw), hexAddress(watchedAddress)) {
+		t.Error("address with the version byte differs from the one without")
+	}
+}
+
+func TestScanResumesFromCursor(t *testing.T) {
+	grid, srv := newFakeGrid(t)
+	grid.pay("before", 1000, []string{watchedAddress}, []int64{1000000})
+	grid.pay("after", 3000, []string{watchedAddress}, []int64{2000000})
+	grid.pay("later", 4000, []string{watchedAddress}, []int64{3000000})
+
+	cursors := paymentstest.NewMemCursors(map[string]string{cursorKey(watchedAddress): "2000"})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(watchedAddress); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, _ := p.Match(testDono(1, 1000000)); m.Found {
+		t.Error("transfer before the cursor was read")
+	}
+	for _, amount := range []int64{2000000, 3000000} {
+		if m, _ := p.Match(testDono(2, amount)); !m.Found {
+			t.Errorf("transfer of %d after the cursor, over two pages, not matched", amount)
+		}
+	}
+	if cursors.Get(cursorKey(watchedAddress)) != "4000" {
+		t.Errorf("cursor = %s, want 4000", cursors.Get(cursorKey(watchedAddress)))
+	}
+
+	// the transfer at the cursor is read again, and is known
+	calls := grid.eventsCalls
+	if err := p.scan(watchedAddress); err != nil {
+		t.Fatal(err)
+	}
+	if grid.eventsCalls != calls || len(p.transfers) != 2 {
+		t.Errorf("transfer read again was taken as new: %d transfers, %d events calls", len(p.transfers), grid.eventsCalls-calls)
+	}
+}
+
+func TestTwoTransfersInOneTransaction(t *testing.T) {
+	grid, srv := newFakeGrid(t)
+	grid.pay("batch", 1000, []string{watchedAddress, otherAddress, watchedAddress}, []int64{5000000, 5000000, 5000000})
+
+	p := newTestProvider(srv.URL, paymentstest.NewMemCursors(map[string]string{cursorKey(watchedAddress): "0"}))
+	if err := p.scan(watchedAddress); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := p.Match(testDono(1, 5000000))
+	second, _ := p.Match(testDono(2, 5000000))
+	if !first.Found || !second.Found {
+		t.Fatalf("both donos should be paid: %+v, %+v", first, second)
+	}
+	if first.TxHash != "batch" || second.TxHash != "batch" || first.LogIndex != 1 || second.LogIndex != 5 {
+		t.Errorf("event indexes = %d and %d, want 1 and 5", first.LogIndex, second.LogIndex)
+	}
+	if m, _ := p.Match(testDono(3, 5000000)); m.Found {
+		t.Error("a third dono was paid by two transfers")
+	}
+}
+
+func TestRestartKeepsTransfers(t *testing.T) {
+	grid, srv := newFakeGrid(t)
+	grid.pay("tx", 1000, []string{watchedAddress}, []int64{1000000})
+
+	cursors := paymentstest.NewMemCursors(map[string]string{cursorKey(watchedAddress): "0"})
+	p := newTestProvider(srv.URL, cursors)
+	if err := p.scan(watchedAddress); err != nil {
+		t.Fatal(err)
+	}
+
+	grid.pay("newer", 2000, []string{watchedAddress}, []int64{2000000})
+	restarted := newTestProvider(srv.URL, cursors)
+	if err := restarted.loadTransfers(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.scan(watchedAddress); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := restarted.Match(testDono(1, 1000000)); !m.Found || m.TxHash != "tx" {
+		t.Errorf("transfer found before the restart not matched after it: %+v", m)
+	}
+}
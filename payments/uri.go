@@ -0,0 +1,74 @@
+package payments
+
+import (
+	"html/template"
+	"math/big"
+	"net/url"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+)
+
+// EthereumURI returns an EIP-681 payment URI on the given EVM chain. The
+// native coin is sent to the address with the value in wei; a token is paid
+// by calling transfer on its contract with the amount in the token's
+// smallest unit.
+func EthereumURI(to string, contract string, chainID uint64, atomic *big.Int) string {
+	chain := "@" + strconv.FormatUint(chainID, 10)
+	if contract == "" {
+		return "ethereum:" + to + chain + "?value=" + atomic.String()
+	}
+	return "ethereum:" + contract + chain + "/transfer?address=" + to + "&uint256=" + atomic.String()
+}
+
+// SolanaPayURI returns a Solana Pay transfer request URI. The amount is in
+// whole SOL or tokens, as the spec wants; splToken is the mint of the token
+// to pay in, empty for SOL. The reference key, if any, is added to the
+// transaction by the wallet so the payment can be found by it.
+func SolanaPayURI(recipient string, amount string, splToken string, reference string) string {
+	query := url.Values{}
+	query.Set("amount", amount)
+	if splToken != "" {
+		query.Set("spl-token", splToken)
+	}
+	if reference != "" {
+		query.Set("reference", reference)
+	}
+	return "solana:" + recipient + "?" + query.Encode()
+}
+
+// TronURI returns a payment URI for a TRC-20 token on Tron, with the token
+// contract and the amount in whole tokens, as Tron wallets read it.
+func TronURI(address string, contract string, amount string) string {
+	query := url.Values{}
+	query.Set("amount", amount)
+	query.Set("token", contract)
+	return "tron:" + address + "?" + query.Encode()
+}
+
+// BitcoinURI returns a BIP21 payment URI for an amount in BTC.
+func BitcoinURI(address string, amount string) string {
+	return "bitcoin:" + address + "?amount=" + amount
+}
+
+// LightningURI returns a payment URI for a BOLT11 invoice, which holds the
+// amount itself.
+func LightningURI(bolt11 string) string {
+	return "lightning:" + bolt11
+}
+
+// MoneroURI returns a Monero payment URI for an amount in XMR.
+func MoneroURI(address string, amount string) string {
+	return "monero:" + address + "?tx_amount=" + amount
+}
+
+// WalletLinks returns links that open a payment URI in a wallet: the URI
+// itself for whatever wallet the device has, and app links for wallets that
+// don't register the URI scheme on every platform.
+func WalletLinks(uri string) []utils.WalletLink {
+	links := []utils.WalletLink{{Name: "Wallet app", URL: template.URL(uri)}}
+	if strings.HasPrefix(uri, "ethereum:") {
+		links = append(links, utils.WalletLink{Name: "MetaMask", URL: template.URL("https://metamask.app.link/send/" + strings.TrimPrefix(uri, "ethereum:"))})
+	}
+	return links
+}
@@ -0,0 +1,87 @@
+package payments
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestEthereumURI(t *testing.T) {
+	const to = "0x1111111111111111111111111111111111111111"
+	const usdc = "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+	wei, _ := new(big.Int).SetString("1500000000000000000", 10)
+	tests := []struct {
+		name     string
+		contract string
+		chainID  uint64
+		atomic   *big.Int
+		want     string
+	}{
+		{"ETH in wei", "", 1, wei, "ethereum:" + to + "@1?value=1500000000000000000"},
+		{"ERC-20 transfer", usdc, 1, big.NewInt(12345678), "ethereum:" + usdc + "@1/transfer?address=" + to + "&uint256=12345678"},
+		{"native coin on another chain", "", 137, big.NewInt(42), "ethereum:" + to + "@137?value=42"},
+		{"token on another chain", "0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359", 137, big.NewInt(5000000), "ethereum:0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359@137/transfer?address=" + to + "&uint256=5000000"},
+	}
+	for _, tt := range tests {
+		if got := EthereumURI(to, tt.contract, tt.chainID, tt.atomic); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSolanaPayURI(t *testing.T) {
+	const recipient = "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"
+	const mint = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	const reference = "82ZJ7nbGpixjeDCmEhUcmwXYfvurzAgGdtSMuHnUgyny"
+	tests := []struct {
+		name      string
+		amount    string
+		splToken  string
+		reference string
+		want      string
+	}{
+		{"SOL", "0.0125", "", "", "solana:" + recipient + "?amount=0.0125"},
+		{"SOL with reference", "1", "", reference, "solana:" + recipient + "?amount=1&reference=" + reference},
+		{"token with reference", "12.345678", mint, reference, "solana:" + recipient + "?amount=12.345678&reference=" + reference + "&spl-token=" + mint},
+	}
+	for _, tt := range tests {
+		if got := SolanaPayURI(recipient, tt.amount, tt.splToken, tt.reference); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTronURI(t *testing.T) {
+	got := TronURI("TLsV52sRDL79HXGGm9yzwKibb6BeruhUzy", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "25.5")
+	want := "tron:TLsV52sRDL79HXGGm9yzwKibb6BeruhUzy?amount=25.5&token=TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestBitcoinURI(t *testing.T) {
+	got := BitcoinURI("bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", "0.00012345")
+	want := "bitcoin:bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu?amount=0.00012345"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWalletLinks(t *testing.T) {
+	const eth = "ethereum:0x1111111111111111111111111111111111111111@1?value=42"
+	links := WalletLinks(eth)
+	if len(links) != 2 {
+		t.Fatalf("got %d links for an Ethereum URI, want 2: %+v", len(links), links)
+	}
+	if links[0].Name != "Wallet app" || string(links[0].URL) != eth {
+		t.Errorf("first link = %+v, want the URI itself", links[0])
+	}
+	if want := "https://metamask.app.link/send/0x1111111111111111111111111111111111111111@1?value=42"; links[1].Name != "MetaMask" || string(links[1].URL) != want {
+		t.Errorf("second link = %+v, want MetaMask's %s", links[1], want)
+	}
+
+	const btc = "bitcoin:bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu?amount=0.001"
+	links = WalletLinks(btc)
+	if len(links) != 1 || string(links[0].URL) != btc {
+		t.Errorf("links for a Bitcoin URI = %+v, want only the URI", links)
+	}
+}
@@ -0,0 +1,206 @@
+package xmr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxBlocksBehind is how far a daemon may trail the highest height seen
+// across all daemons before it is treated as unhealthy.
+const MaxBlocksBehind = 5
+
+// DaemonStatus is the result of the last health check of one monerod.
+type DaemonStatus struct {
+	Address      string
+	Healthy      bool
+	Height       uint64
+	TargetHeight uint64
+	Synchronized bool
+	LastError    string
+	CheckedAt    time.Time
+}
+
+// Daemons is an ordered list of monerod nodes. The first healthy node in
+// the list is the one wallets should use.
+type Daemons struct {
+	addresses []string
+	client    *http.Client
+
+	mu       sync.Mutex
+	statuses map[string]DaemonStatus
+}
+
+// NewDaemons returns a daemon list in order of preference. Nothing is
+// checked until CheckAll or Best is called.
+func NewDaemons(addresses []string) *Daemons {
+	return &Daemons{
+		addresses: addresses,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		statuses:  make(map[string]DaemonStatus),
+	}
+}
+
+// ReadDaemonList reads daemon addresses from a file, one per line in order
+// of preference. Blank lines and lines starting with # are skipped.
+func ReadDaemonList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	addresses := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addresses = append(addresses, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no daemon addresses in %s", path)
+	}
+	return addresses, nil
+}
+
+// CheckAll asks every daemon for get_info. A daemon is healthy if it
+// answers, says it is synchronized and is no more than MaxBlocksBehind
+// behind the highest daemon.
+func (d *Daemons) CheckAll() {
+	statuses := make([]DaemonStatus, len(d.addresses))
+	var wg sync.WaitGroup
+	for i, address := range d.addresses {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			statuses[i] = d.check(address)
+		}(i, address)
+	}
+	wg.Wait()
+
+	var top uint64
+	for _, status := range statuses {
+		if status.Healthy && status.Height > top {
+			top = status.Height
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, status := range statuses {
+		if status.Healthy && status.Height+MaxBlocksBehind < top {
+			status.Healthy = false
+			status.LastError = fmt.Sprintf("%d blocks behind", top-status.Height)
+		}
+		d.statuses[status.Address] = status
+	}
+}
+
+func (d *Daemons) check(address string) DaemonStatus {
+	status := DaemonStatus{Address: address, CheckedAt: time.Now()}
+
+	body := []byte(`{"jsonrpc":"2.0","id":"0","method":"get_info"}`)
+	res, err := d.client.Post(daemonURL(address), "application/json", bytes.NewReader(body))
+	if err != nil {
+		status.LastError = err.Error()
+		return status
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		status.LastError = fmt.Sprintf("non-200 response code received: %d", res.StatusCode)
+		return status
+	}
+
+	var resp struct {
+		Result *struct {
+			Height       uint64 `json:"height"`
+			TargetHeight uint64 `json:"target_height"`
+			Synchronized bool   `json:"synchronized"`
+			Offline      bool   `json:"offline"`
+			BusySyncing  bool   `json:"busy_syncing"`
+			Status       string `json:"status"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		status.LastError = err.Error()
+		return status
+	}
+	if resp.Result == nil {
+		status.LastError = "get_info returned no result"
+		return status
+	}
+
+	info := resp.Result
+	status.Height = info.Height
+	status.TargetHeight = info.TargetHeight
+	status.Synchronized = info.Synchronized && !info.BusySyncing
+	switch {
+	case info.Status != "OK":
+		status.LastError = "status " + info.Status
+	case info.Offline:
+		status.LastError = "offline"
+	case !status.Synchronized:
+		status.LastError = fmt.Sprintf("syncing, at %d of %d", info.Height, info.TargetHeight)
+	default:
+		status.Healthy = true
+	}
+	return status
+}
+
+// daemonURL turns a --daemon-address value into its JSON-RPC URL.
+func daemonURL(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return strings.TrimSuffix(address, "/") + "/json_rpc"
+}
+
+// Best returns the first healthy daemon. If none is healthy the first one
+// is returned, so wallets still start and pick up once it recovers.
+func (d *Daemons) Best() string {
+	if len(d.addresses) == 0 {
+		return ""
+	}
+
+	d.mu.Lock()
+	checked := len(d.statuses) > 0
+	d.mu.Unlock()
+	if !checked {
+		d.CheckAll()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, address := range d.addresses {
+		if d.statuses[address].Healthy {
+			return address
+		}
+	}
+	return d.addresses[0]
+}
+
+// Statuses returns the last check of every daemon, in order of preference.
+func (d *Daemons) Statuses() []DaemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statuses := make([]DaemonStatus, 0, len(d.addresses))
+	for _, address := range d.addresses {
+		status, ok := d.statuses[address]
+		if !ok {
+			status = DaemonStatus{Address: address, LastError: "not checked yet"}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,119 @@
+package xmr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"shadowchat/payments/paymentstest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDaemon is a monerod stand-in answering get_info at a height tests
+// move, or failing while down.
+type fakeDaemon struct {
+	height atomic.Uint64
+	down   atomic.Bool
+	url    string
+}
+
+func newFakeDaemon(t *testing.T, height uint64) *fakeDaemon {
+	d := &fakeDaemon{}
+	d.height.Store(height)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.down.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		height := d.height.Load()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      "0",
+			"result": map[string]interface{}{
+				"height":        height,
+				"target_height": height,
+				"synchronized":  true,
+				"status":        "OK",
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	d.url = srv.URL
+	return d
+}
+
+func TestBestDaemonFailsOver(t *testing.T) {
+	first, second := newFakeDaemon(t, 3000000), newFakeDaemon(t, 3000000)
+	d := NewDaemons([]string{first.url, second.url})
+	if best := d.Best(); best != first.url {
+		t.Fatalf("Best = %s, want the first daemon while both are at the top", best)
+	}
+
+	// within MaxBlocksBehind the first is still preferred
+	second.height.Store(3000000 + MaxBlocksBehind)
+	d.CheckAll()
+	if best := d.Best(); best != first.url {
+		t.Errorf("Best = %s, want the first daemon %d blocks behind", best, MaxBlocksBehind)
+	}
+
+	second.height.Store(3000000 + MaxBlocksBehind + 1)
+	d.CheckAll()
+	if best := d.Best(); best != second.url {
+		t.Errorf("Best = %s, want the second daemon once the first fell behind", best)
+	}
+	if status := d.Statuses()[0]; status.Healthy || !strings.Contains(status.LastError, "6 blocks behind") {
+		t.Errorf("first daemon status = %+v, want unhealthy and 6 blocks behind", status)
+	}
+
+	first.height.Store(3000000 + MaxBlocksBehind + 1)
+	d.CheckAll()
+	if best := d.Best(); best != first.url {
+		t.Errorf("Best = %s, want the first daemon back once it caught up", best)
+	}
+
+	first.down.Store(true)
+	d.CheckAll()
+	if best := d.Best(); best != second.url {
+		t.Errorf("Best = %s, want the second daemon while the first doesn't answer", best)
+	}
+
+	second.down.Store(true)
+	d.CheckAll()
+	if best := d.Best(); best != first.url {
+		t.Errorf("Best = %s, want the first daemon when none is healthy", best)
+	}
+}
+
+func TestWalletsMoveToBestDaemon(t *testing.T) {
+	first, second := newFakeDaemon(t, 3000000), newFakeDaemon(t, 3000000)
+	s := newTestSupervisor(t, 1, 1)
+	s.daemons = NewDaemons([]string{first.url, second.url})
+	s.cfg.DaemonCheckInterval = 20 * time.Millisecond
+
+	if err := s.Start(1, "right"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitForOpen(1, 15*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	argv, _ := os.ReadFile(filepath.Join(s.WalletDir(1), "argv"))
+	if !strings.Contains(string(argv), "--daemon-address "+first.url) {
+		t.Fatalf("wallet started with %s, want the first daemon", argv)
+	}
+
+	go s.WatchDaemons()
+	second.height.Store(3000000 + MaxBlocksBehind + 1)
+	paymentstest.WaitFor(t, "the wallet moves to the second daemon", func() bool { return s.Status(1).Daemon == second.url })
+	params, _ := os.ReadFile(filepath.Join(s.WalletDir(1), "set_daemon"))
+	if !strings.Contains(string(params), second.url) {
+		t.Errorf("set_daemon params = %s, want the second daemon", params)
+	}
+
+	first.height.Store(3000000 + MaxBlocksBehind + 1)
+	second.down.Store(true)
+	paymentstest.WaitFor(t, "the wallet moves back to the first daemon", func() bool { return s.Status(1).Daemon == first.url })
+}
@@ -0,0 +1,163 @@
+package xmr
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestTransport adds HTTP digest authentication, which is what
+// monero-wallet-rpc expects when started with --rpc-login.
+type digestTransport struct {
+	username string
+	password string
+
+	mu        sync.Mutex
+	challenge map[string]string
+	nc        int
+}
+
+func newDigestTransport(username, password string) *digestTransport {
+	return &digestTransport{username: username, password: password}
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Reuse the last challenge so most requests only need one round trip.
+	t.mu.Lock()
+	challenge := t.challenge
+	t.mu.Unlock()
+	if challenge != nil {
+		resp, err := http.DefaultTransport.RoundTrip(t.authorize(req, body, challenge))
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		resp.Body.Close()
+	}
+
+	first := req.Clone(req.Context())
+	first.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := http.DefaultTransport.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge = parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if challenge == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.challenge = challenge
+	t.nc = 0
+	t.mu.Unlock()
+
+	return http.DefaultTransport.RoundTrip(t.authorize(req, body, challenge))
+}
+
+func (t *digestTransport) authorize(req *http.Request, body []byte, challenge map[string]string) *http.Request {
+	t.mu.Lock()
+	t.nc++
+	nc := fmt.Sprintf("%08x", t.nc)
+	t.mu.Unlock()
+
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(t.username + ":" + challenge["realm"] + ":" + t.password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	var response string
+	qop := ""
+	if strings.Contains(challenge["qop"], "auth") {
+		qop = "auth"
+		response = md5Hex(ha1 + ":" + challenge["nonce"] + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + challenge["nonce"] + ":" + ha2)
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		t.username, challenge["realm"], challenge["nonce"], uri, response)
+	if qop != "" {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if algorithm, ok := challenge["algorithm"]; ok {
+		header += ", algorithm=" + algorithm
+	}
+	if opaque, ok := challenge["opaque"]; ok {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+
+	authed := req.Clone(req.Context())
+	authed.Body = io.NopCloser(bytes.NewReader(body))
+	authed.Header.Set("Authorization", header)
+	return authed
+}
+
+// parseChallenge reads a WWW-Authenticate header. wallet-rpc sends one MD5
+// and one MD5-sess challenge, the first MD5 one is used.
+func parseChallenge(header string) map[string]string {
+	for _, part := range strings.Split(header, "Digest ") {
+		part = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(part), ","))
+		if part == "" {
+			continue
+		}
+		challenge := map[string]string{}
+		for _, field := range splitChallenge(part) {
+			kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			challenge[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+		if algorithm, ok := challenge["algorithm"]; ok && !strings.EqualFold(algorithm, "MD5") {
+			continue
+		}
+		if challenge["nonce"] != "" {
+			return challenge
+		}
+	}
+	return nil
+}
+
+// splitChallenge splits on commas that are not inside quotes.
+func splitChallenge(s string) []string {
+	fields := []string{}
+	quoted := false
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,159 @@
+package xmr
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ValidateViewKeys checks the shape of a primary address and private view
+// key before they are handed to wallet-rpc.
+func ValidateViewKeys(address, viewKey string) error {
+	if len(address) != 95 || (address[0] != '4' && address[0] != '9' && address[0] != 'A') {
+		return fmt.Errorf("%q is not a primary Monero address", address)
+	}
+	if key, err := hex.DecodeString(viewKey); err != nil || len(key) != 32 {
+		return fmt.Errorf("the private view key must be 64 hex characters")
+	}
+	return nil
+}
+
+// GenerateFromKeys creates the user's view-only wallet from a primary
+// address and private view key, in the place Start expects it. It runs a
+// short-lived wallet-rpc with --wallet-dir, calls generate_from_keys and
+// checks that the wallet has the address asked for before replacing any
+// existing wallet. The user's wallet must not be running.
+func (s *Supervisor) GenerateFromKeys(userID int, address, viewKey string, restoreHeight uint64, walletPassword string) error {
+	if err := ValidateViewKeys(address, viewKey); err != nil {
+		return err
+	}
+
+	dir := s.WalletDir(userID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	const tmpName = "wallet.generating"
+	removeTmp := func() {
+		os.Remove(filepath.Join(dir, tmpName))
+		os.Remove(filepath.Join(dir, tmpName+".keys"))
+	}
+	removeTmp()
+	defer removeTmp()
+
+	s.mu.Lock()
+	port, err := s.allocatePort(userID)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.ports, port)
+		s.mu.Unlock()
+	}()
+
+	username, password := "shadowchat", randomHex(16)
+	w := &supervisedWallet{
+		userID:   userID,
+		port:     port,
+		username: username,
+		password: password,
+		client: &http.Client{
+			Timeout:   2 * time.Minute,
+			Transport: newDigestTransport(username, password),
+		},
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(dir, "wallet-rpc.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	args := []string{
+		"--rpc-bind-ip", "127.0.0.1",
+		"--rpc-bind-port", strconv.Itoa(port),
+		"--rpc-login", username + ":" + password,
+		"--daemon-address", s.daemons.Best(),
+		"--wallet-dir", dir,
+		"--log-file", filepath.Join(dir, "monero-wallet-rpc.log"),
+		"--non-interactive",
+	}
+	args = append(args, s.cfg.ExtraArgs...)
+
+	output := &lastLine{}
+	cmd := exec.Command(s.cfg.Binary, args...)
+	cmd.Stdout = io.MultiWriter(logFile, output)
+	cmd.Stderr = cmd.Stdout
+
+	fmt.Fprintf(logFile, "\n--- %s generating wallet from keys on port %d\n", time.Now().UTC().Format(time.RFC3339), port)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	defer s.terminate(cmd, exited)
+
+	deadline := time.Now().Add(s.cfg.StartTimeout)
+	for !s.healthy(w) {
+		select {
+		case err := <-exited:
+			exited <- err // for terminate
+			return fmt.Errorf("wallet-rpc exited: %s", output.String())
+		case <-time.After(time.Second):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wallet-rpc did not answer within %s", s.cfg.StartTimeout)
+		}
+	}
+
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "0",
+		"method":  "generate_from_keys",
+		"params": map[string]interface{}{
+			"filename":         tmpName,
+			"address":          address,
+			"viewkey":          viewKey,
+			"restore_height":   restoreHeight,
+			"password":         walletPassword,
+			"autosave_current": true,
+		},
+	}
+	var resp struct {
+		Result struct {
+			Address string `json:"address"`
+			Info    string `json:"info"`
+		} `json:"result"`
+	}
+	if err := rpcCall(w.client, rpcURL(port), payload, &resp); err != nil {
+		return err
+	}
+	if resp.Result.Address != address {
+		return fmt.Errorf("the wallet was created for %s, not %s", resp.Result.Address, address)
+	}
+
+	closeWallet := map[string]interface{}{"jsonrpc": "2.0", "id": "0", "method": "close_wallet"}
+	if err := rpcCall(w.client, rpcURL(port), closeWallet, &struct{}{}); err != nil {
+		return err
+	}
+
+	if err := os.Rename(filepath.Join(dir, tmpName+".keys"), filepath.Join(dir, "wallet.keys")); err != nil {
+		return err
+	}
+	// The cache file is only written once the wallet has synced something,
+	// without it wallet-rpc starts from the restore height in the keys file.
+	os.Remove(filepath.Join(dir, "wallet"))
+	if err := os.Rename(filepath.Join(dir, tmpName), filepath.Join(dir, "wallet")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
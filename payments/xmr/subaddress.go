@@ -0,0 +1,81 @@
+package xmr
+
+import (
+	"fmt"
+	"html"
+)
+
+type subaddressIndex struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+}
+
+// isSubaddress tells dono addresses created in subaddress mode apart from
+// payment IDs, which are 16 hex characters.
+func isSubaddress(payID string) bool {
+	return len(payID) > 64
+}
+
+// Subaddress creates a fresh subaddress in the user's primary account.
+func (p *Provider) Subaddress(userID int) (string, error) {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "0",
+		"method":  "create_address",
+		"params": map[string]interface{}{
+			"account_index": 0,
+			"label":         "dono",
+		},
+	}
+
+	var resp struct {
+		Result struct {
+			Address      string `json:"address"`
+			AddressIndex uint64 `json:"address_index"`
+		} `json:"result"`
+	}
+	if err := p.call(userID, payload, &resp); err != nil {
+		return "", err
+	}
+	if resp.Result.Address == "" {
+		return "", fmt.Errorf("create_address returned no address")
+	}
+
+	address := html.EscapeString(resp.Result.Address)
+	p.mu.Lock()
+	p.subaddresses[address] = subaddressIndex{Major: 0, Minor: resp.Result.AddressIndex}
+	p.mu.Unlock()
+	return address, nil
+}
+
+// subaddressIndex returns the account and subaddress index of an address,
+// asking the wallet for subaddresses created before a restart.
+func (p *Provider) subaddressIndex(userID int, address string) (subaddressIndex, error) {
+	p.mu.Lock()
+	index, ok := p.subaddresses[address]
+	p.mu.Unlock()
+	if ok {
+		return index, nil
+	}
+
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "0",
+		"method":  "get_address_index",
+		"params":  map[string]string{"address": address},
+	}
+
+	var resp struct {
+		Result struct {
+			Index subaddressIndex `json:"index"`
+		} `json:"result"`
+	}
+	if err := p.call(userID, payload, &resp); err != nil {
+		return subaddressIndex{}, err
+	}
+
+	p.mu.Lock()
+	p.subaddresses[address] = resp.Result.Index
+	p.mu.Unlock()
+	return resp.Result.Index, nil
+}
@@ -0,0 +1,625 @@
+package xmr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WalletState is where a user's monero-wallet-rpc process is in its life.
+type WalletState string
+
+const (
+	StateStarting WalletState = "starting" // process launched, RPC not answering yet
+	StateRunning  WalletState = "running"  // RPC answered a health check
+	StateCrashed  WalletState = "crashed"  // process exited, waiting to be restarted
+	StateStopped  WalletState = "stopped"  // stopped on purpose, or never started
+)
+
+// WalletStatus is a snapshot of one supervised wallet.
+type WalletStatus struct {
+	State     WalletState
+	Port      int
+	Restarts  int
+	Daemon    string // monerod the wallet was last pointed at
+	LastError string
+	Since     time.Time // when State was entered
+}
+
+// SupervisorConfig says how to run monero-wallet-rpc. Binary can point at a
+// stand-in that speaks the same JSON-RPC, which is how the supervisor is
+// exercised without a real Monero install.
+type SupervisorConfig struct {
+	Binary       string   // path to monero-wallet-rpc
+	Daemons      []string // monerod addresses, in order of preference
+	UsersDir     string   // wallets live in <UsersDir>/<id>/monero/wallet
+	FirstPort    int      // ports are handed out from FirstPort..LastPort
+	LastPort     int
+	StartTimeout time.Duration // how long the RPC may take to answer after launch
+	MinBackoff   time.Duration // first restart delay, doubled after every crash
+	MaxBackoff   time.Duration
+	ExtraArgs    []string
+
+	DaemonCheckInterval time.Duration // how often WatchDaemons checks the daemons
+}
+
+// DefaultSupervisorConfig matches the layout the server has always used.
+func DefaultSupervisorConfig() SupervisorConfig {
+	return SupervisorConfig{
+		Binary:       "monero/monero-wallet-rpc",
+		Daemons:      []string{"https://xmr-node.cakewallet.com:18081"},
+		UsersDir:     "users",
+		FirstPort:    28088,
+		LastPort:     29087,
+		StartTimeout: 2 * time.Minute,
+		MinBackoff:   5 * time.Second,
+		MaxBackoff:   5 * time.Minute,
+
+		DaemonCheckInterval: time.Minute,
+	}
+}
+
+// Supervisor owns one monero-wallet-rpc child process per user. It hands
+// out ports, gives every process its own RPC login, restarts crashed
+// processes with exponential backoff and writes their output to a log file
+// next to the wallet. Wallets are pointed at the best of its daemons.
+type Supervisor struct {
+	cfg     SupervisorConfig
+	daemons *Daemons
+
+	mu      sync.Mutex
+	wallets map[int]*supervisedWallet
+	ports   map[int]int // port -> user ID
+}
+
+type supervisedWallet struct {
+	userID   int
+	port     int
+	username string
+	password string // RPC login
+	client   *http.Client
+
+	walletPassword string
+
+	status   WalletStatus
+	stopping bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewSupervisor returns a supervisor with nothing running.
+func NewSupervisor(cfg SupervisorConfig) *Supervisor {
+	return &Supervisor{
+		cfg:     cfg,
+		daemons: NewDaemons(cfg.Daemons),
+		wallets: make(map[int]*supervisedWallet),
+		ports:   make(map[int]int),
+	}
+}
+
+// WalletDir returns the directory holding a user's wallet files and logs.
+func (s *Supervisor) WalletDir(userID int) string {
+	return filepath.Join(s.cfg.UsersDir, strconv.Itoa(userID), "monero")
+}
+
+// Start launches the user's wallet-rpc, opening the wallet with the given
+// password, and keeps it running until Stop is called. Starting a wallet
+// that is already supervised does nothing.
+func (s *Supervisor) Start(userID int, walletPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.wallets[userID]; ok && w.status.State != StateStopped {
+		return nil
+	}
+
+	port, err := s.allocatePort(userID)
+	if err != nil {
+		return err
+	}
+
+	username, password := "shadowchat", randomHex(16)
+	w := &supervisedWallet{
+		userID:   userID,
+		port:     port,
+		username: username,
+		password: password,
+
+		walletPassword: walletPassword,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: newDigestTransport(username, password),
+		},
+		status: WalletStatus{State: StateStarting, Port: port, Since: time.Now()},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.wallets[userID] = w
+
+	go s.run(w)
+	return nil
+}
+
+// Stop shuts the user's wallet-rpc down, waits for it to exit and frees its
+// port.
+func (s *Supervisor) Stop(userID int) {
+	s.mu.Lock()
+	w, ok := s.wallets[userID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	if !w.stopping {
+		w.stopping = true
+		close(w.stop)
+	}
+	s.mu.Unlock()
+
+	<-w.done
+
+	s.mu.Lock()
+	if s.ports[w.port] == userID {
+		delete(s.ports, w.port)
+	}
+	s.mu.Unlock()
+}
+
+// Restart stops the user's wallet-rpc, if any, and starts it again, for
+// example after a new wallet has been uploaded.
+func (s *Supervisor) Restart(userID int, walletPassword string) error {
+	s.Stop(userID)
+	return s.Start(userID, walletPassword)
+}
+
+// WaitForOpen waits up to timeout for the user's wallet-rpc to answer. It
+// returns an error, with the last thing wallet-rpc printed, if the process
+// exits first, e.g. because the wallet password is wrong. A wallet that is
+// still starting when timeout runs out is not an error.
+func (s *Supervisor) WaitForOpen(userID int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		status := s.Status(userID)
+		switch status.State {
+		case StateRunning:
+			return nil
+		case StateCrashed, StateStopped:
+			if status.LastError == "" {
+				return fmt.Errorf("wallet-rpc is %s", status.State)
+			}
+			return fmt.Errorf("%s", status.LastError)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return nil
+}
+
+// StopAll stops every supervised wallet.
+func (s *Supervisor) StopAll() {
+	s.mu.Lock()
+	userIDs := make([]int, 0, len(s.wallets))
+	for userID := range s.wallets {
+		userIDs = append(userIDs, userID)
+	}
+	s.mu.Unlock()
+
+	for _, userID := range userIDs {
+		s.Stop(userID)
+	}
+}
+
+// Status returns the state of the user's wallet. Users without a
+// supervised wallet are reported as stopped.
+func (s *Supervisor) Status(userID int) WalletStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.wallets[userID]
+	if !ok {
+		return WalletStatus{State: StateStopped}
+	}
+	return w.status
+}
+
+// Statuses returns the state of every supervised wallet by user ID.
+func (s *Supervisor) Statuses() map[int]WalletStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make(map[int]WalletStatus, len(s.wallets))
+	for userID, w := range s.wallets {
+		statuses[userID] = w.status
+	}
+	return statuses
+}
+
+// Endpoint returns the JSON-RPC URL of the user's wallet and an HTTP client
+// that carries its login.
+func (s *Supervisor) Endpoint(userID int) (string, *http.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.wallets[userID]
+	if !ok || w.status.State == StateStopped {
+		return "", nil, fmt.Errorf("no monero wallet running for user %d", userID)
+	}
+	return rpcURL(w.port), w.client, nil
+}
+
+// Call sends a JSON-RPC request to the user's wallet and decodes the
+// response body into out. A JSON-RPC error in the response is returned as
+// an error.
+func (s *Supervisor) Call(userID int, payload interface{}, out interface{}) error {
+	url, client, err := s.Endpoint(userID)
+	if err != nil {
+		return err
+	}
+	return rpcCall(client, url, payload, out)
+}
+
+// rpcCall posts a JSON-RPC request and decodes the response into out.
+func rpcCall(client *http.Client, url string, payload interface{}, out interface{}) error {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 response code received: %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var rpcErr struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &rpcErr); err != nil {
+		return err
+	}
+	if rpcErr.Error != nil {
+		return fmt.Errorf("wallet-rpc error %d: %s", rpcErr.Error.Code, rpcErr.Error.Message)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// Daemons returns the last health check of every daemon, in order of
+// preference.
+func (s *Supervisor) Daemons() []DaemonStatus {
+	return s.daemons.Statuses()
+}
+
+// WatchDaemons checks the daemons every DaemonCheckInterval and moves
+// running wallets over to the best one, with set_daemon or, if the wallet
+// refuses, by restarting it. It never returns.
+func (s *Supervisor) WatchDaemons() {
+	for {
+		s.daemons.CheckAll()
+		best := s.daemons.Best()
+		for userID, status := range s.Statuses() {
+			if status.State != StateRunning || status.Daemon == best {
+				continue
+			}
+			log.Println("moving monero wallet for user", userID, "from", status.Daemon, "to", best)
+			if err := s.setDaemon(userID, best); err != nil {
+				log.Println("set_daemon failed for user", userID, err, "- restarting wallet")
+				go s.restart(userID)
+			}
+		}
+		time.Sleep(s.cfg.DaemonCheckInterval)
+	}
+}
+
+// restart restarts a wallet with the password it was started with.
+func (s *Supervisor) restart(userID int) {
+	s.mu.Lock()
+	w, ok := s.wallets[userID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := s.Restart(userID, w.walletPassword); err != nil {
+		log.Println("restarting monero wallet for user", userID, err)
+	}
+}
+
+// setDaemon points a running wallet at another daemon.
+func (s *Supervisor) setDaemon(userID int, daemon string) error {
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "0",
+		"method":  "set_daemon",
+		"params":  map[string]interface{}{"address": daemon},
+	}
+	var resp struct{}
+	if err := s.Call(userID, payload, &resp); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.wallets[userID]; ok {
+		w.status.Daemon = daemon
+	}
+	return nil
+}
+
+func rpcURL(port int) string {
+	return "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) + "/json_rpc"
+}
+
+// allocatePort returns the lowest free port in the pool. Ports something
+// else is already listening on are skipped. Callers hold s.mu.
+func (s *Supervisor) allocatePort(userID int) (int, error) {
+	for port := s.cfg.FirstPort; port <= s.cfg.LastPort; port++ {
+		if _, taken := s.ports[port]; taken {
+			continue
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		s.ports[port] = userID
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free wallet-rpc port between %d and %d", s.cfg.FirstPort, s.cfg.LastPort)
+}
+
+func (s *Supervisor) setStatus(w *supervisedWallet, state WalletState, lastErr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.status.State != state {
+		log.Println("monero wallet for user", w.userID, "is now", state, lastErr)
+		w.status.Since = time.Now()
+	}
+	w.status.State = state
+	if lastErr != "" {
+		w.status.LastError = lastErr
+	}
+}
+
+// run launches the process and relaunches it after every crash until the
+// wallet is stopped.
+func (s *Supervisor) run(w *supervisedWallet) {
+	defer close(w.done)
+
+	backoff := s.cfg.MinBackoff
+	for {
+		reachedRunning, err := s.runOnce(w)
+
+		select {
+		case <-w.stop:
+			s.setStatus(w, StateStopped, "")
+			return
+		default:
+		}
+
+		errStr := "wallet-rpc exited"
+		if err != nil {
+			errStr = err.Error()
+		}
+		s.setStatus(w, StateCrashed, errStr)
+
+		if reachedRunning {
+			backoff = s.cfg.MinBackoff
+		}
+
+		select {
+		case <-w.stop:
+			s.setStatus(w, StateStopped, "")
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+
+		s.mu.Lock()
+		w.status.Restarts++
+		s.mu.Unlock()
+		s.setStatus(w, StateStarting, "")
+	}
+}
+
+// runOnce runs the process until it exits or the wallet is stopped. It
+// reports whether the RPC ever became healthy.
+func (s *Supervisor) runOnce(w *supervisedWallet) (bool, error) {
+	dir := s.WalletDir(w.userID)
+	logFile, err := os.OpenFile(filepath.Join(dir, "wallet-rpc.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer logFile.Close()
+
+	// The wallet password and the RPC login go through files only
+	// wallet-rpc's user can read, never the command line where ps shows
+	// them, and are removed once the wallet is open.
+	passwordFile := filepath.Join(dir, "wallet.password")
+	if err := os.WriteFile(passwordFile, []byte(w.walletPassword), 0600); err != nil {
+		return false, err
+	}
+	defer os.Remove(passwordFile)
+	configFile := filepath.Join(dir, "wallet-rpc.conf")
+	if err := os.WriteFile(configFile, []byte("rpc-login="+w.username+":"+w.password+"\n"), 0600); err != nil {
+		return false, err
+	}
+	defer os.Remove(configFile)
+
+	daemon := s.daemons.Best()
+	s.mu.Lock()
+	w.status.Daemon = daemon
+	s.mu.Unlock()
+
+	args := []string{
+		"--rpc-bind-ip", "127.0.0.1",
+		"--rpc-bind-port", strconv.Itoa(w.port),
+		"--config-file", configFile,
+		"--daemon-address", daemon,
+		"--wallet-file", filepath.Join(dir, "wallet"),
+		"--password-file", passwordFile,
+		"--log-file", filepath.Join(dir, "monero-wallet-rpc.log"),
+		"--non-interactive",
+	}
+	args = append(args, s.cfg.ExtraArgs...)
+
+	output := &lastLine{}
+	cmd := exec.Command(s.cfg.Binary, args...)
+	cmd.Stdout = io.MultiWriter(logFile, output)
+	cmd.Stderr = cmd.Stdout
+
+	fmt.Fprintf(logFile, "\n--- %s starting wallet-rpc on port %d\n", time.Now().UTC().Format(time.RFC3339), w.port)
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	reachedRunning := false
+	deadline := time.Now().Add(s.cfg.StartTimeout)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-exited:
+			if line := output.String(); line != "" {
+				if err == nil {
+					err = fmt.Errorf("wallet-rpc exited")
+				}
+				err = fmt.Errorf("%v: %s", err, line)
+			}
+			return reachedRunning, err
+		case <-w.stop:
+			s.terminate(cmd, exited)
+			return reachedRunning, nil
+		case <-ticker.C:
+			if reachedRunning {
+				continue
+			}
+			if s.healthy(w) {
+				reachedRunning = true
+				os.Remove(passwordFile)
+				os.Remove(configFile)
+				s.setStatus(w, StateRunning, "")
+			} else if time.Now().After(deadline) {
+				s.terminate(cmd, exited)
+				return false, fmt.Errorf("wallet-rpc did not answer within %s", s.cfg.StartTimeout)
+			}
+		}
+	}
+}
+
+// terminate asks the process to exit so it can save the wallet, and kills
+// it if it hasn't after ten seconds.
+func (s *Supervisor) terminate(cmd *exec.Cmd, exited chan error) {
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// healthy reports whether the wallet answers get_version.
+func (s *Supervisor) healthy(w *supervisedWallet) bool {
+	body := []byte(`{"jsonrpc":"2.0","id":"0","method":"get_version"}`)
+	req, err := http.NewRequest("POST", rpcURL(w.port), bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 5 * time.Second, Transport: w.client.Transport}
+	res, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+
+	var resp struct {
+		Result *json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return false
+	}
+	return resp.Result != nil
+}
+
+// lastLine keeps the last non-empty line written to it, to explain why
+// wallet-rpc exited.
+type lastLine struct {
+	mu      sync.Mutex
+	partial []byte
+	line    string
+}
+
+func (l *lastLine) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(l.partial[:i])); line != "" {
+			l.line = line
+		}
+		l.partial = l.partial[i+1:]
+	}
+	if len(l.partial) > 4096 {
+		l.partial = l.partial[len(l.partial)-4096:]
+	}
+	return len(p), nil
+}
+
+func (l *lastLine) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if line := strings.TrimSpace(string(l.partial)); line != "" {
+		return line
+	}
+	return l.line
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
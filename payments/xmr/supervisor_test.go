@@ -0,0 +1,308 @@
+package xmr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary stands in for monero-wallet-rpc when started with
+// fakeWalletRPCEnv set, the way the supervisor starts it.
+const fakeWalletRPCEnv = "SHADOWCHAT_FAKE_WALLET_RPC"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeWalletRPCEnv) != "" {
+		fakeWalletRPC(os.Args[1:])
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeWalletRPC behaves like monero-wallet-rpc as far as the supervisor can
+// tell. It opens the wallet only with the password "right", answers behind
+// digest authentication with the login from its config file, and exits
+// straight away while the wallet directory holds a "crashes" file counting
+// down. Every start is noted in "starts", with the command line in "argv".
+// get_transfers answers with the transfers in a "transfers" file and notes
+// its params in "get_transfers", set_daemon notes its params in
+// "set_daemon"; every method but get_transfers gets version 1 and height
+// 1000.
+func fakeWalletRPC(args []string) {
+	flags := map[string]string{}
+	for i := 0; i+1 < len(args); i++ {
+		if strings.HasPrefix(args[i], "--") && !strings.HasPrefix(args[i+1], "--") {
+			flags[args[i]] = args[i+1]
+		}
+	}
+	dir := filepath.Dir(flags["--wallet-file"])
+	os.WriteFile(filepath.Join(dir, "argv"), []byte(strings.Join(args, " ")), 0600)
+	starts, _ := os.OpenFile(filepath.Join(dir, "starts"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	fmt.Fprintln(starts, time.Now().UnixNano())
+	starts.Close()
+
+	if crashes, err := os.ReadFile(filepath.Join(dir, "crashes")); err == nil {
+		n, _ := strconv.Atoi(strings.TrimSpace(string(crashes)))
+		if n > 0 {
+			os.WriteFile(filepath.Join(dir, "crashes"), []byte(strconv.Itoa(n-1)), 0600)
+			fmt.Println("Error: simulated crash")
+			os.Exit(1)
+		}
+	}
+
+	password, _ := os.ReadFile(flags["--password-file"])
+	if string(password) != "right" {
+		fmt.Println("Error: failed to open wallet: invalid password")
+		os.Exit(1)
+	}
+	config, _ := os.ReadFile(flags["--config-file"])
+	login := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(string(config)), "rpc-login="), ":", 2)
+	if len(login) != 2 {
+		fmt.Println("Error: no rpc-login in the config file")
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(flags["--rpc-bind-ip"], flags["--rpc-bind-port"]))
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !digestAuthorized(r, login[0], login[1]) {
+			w.Header().Set("WWW-Authenticate", `Digest qop="auth",algorithm=MD5,realm="monero-rpc",nonce="fakenonce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == "get_transfers" {
+			calls, _ := os.OpenFile(filepath.Join(dir, "get_transfers"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			fmt.Fprintf(calls, "%s\n", req.Params)
+			calls.Close()
+			transfers, _ := os.ReadFile(filepath.Join(dir, "transfers"))
+			fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": "0", "result": {"in": %s}}`, transfers)
+			return
+		}
+		if req.Method == "set_daemon" {
+			os.WriteFile(filepath.Join(dir, "set_daemon"), req.Params, 0600)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": "0", "result": map[string]int{"version": 1, "height": 1000}})
+	}))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
+
+func digestAuthorized(r *http.Request, username, password string) bool {
+	auth := parseChallenge(r.Header.Get("Authorization"))
+	if auth == nil || auth["username"] != username {
+		return false
+	}
+	ha1 := md5Hex(username + ":" + auth["realm"] + ":" + password)
+	ha2 := md5Hex(r.Method + ":" + auth["uri"])
+	want := md5Hex(ha1 + ":" + auth["nonce"] + ":" + auth["nc"] + ":" + auth["cnonce"] + ":" + auth["qop"] + ":" + ha2)
+	return auth["response"] == want
+}
+
+// freePorts returns the first of n ports in a row nothing listens on.
+func freePorts(t *testing.T, n int) int {
+	for tries := 0; tries < 20; tries++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		free := true
+		for port := first; port < first+n; port++ {
+			l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				free = false
+				break
+			}
+			l.Close()
+		}
+		if free {
+			return first
+		}
+	}
+	t.Skip("no free ports in a row")
+	return 0
+}
+
+func newTestSupervisor(t *testing.T, ports int, userIDs ...int) *Supervisor {
+	t.Setenv(fakeWalletRPCEnv, "1")
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, userID := range userIDs {
+		if err := os.MkdirAll(filepath.Join(dir, strconv.Itoa(userID), "monero"), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := freePorts(t, ports)
+	s := NewSupervisor(SupervisorConfig{
+		Binary:       binary,
+		UsersDir:     dir,
+		FirstPort:    first,
+		LastPort:     first + ports - 1,
+		StartTimeout: 10 * time.Second,
+		MinBackoff:   200 * time.Millisecond,
+		MaxBackoff:   300 * time.Millisecond,
+	})
+	t.Cleanup(s.StopAll)
+	return s
+}
+
+// waitForState waits for the user's wallet to reach a state.
+func waitForState(t *testing.T, s *Supervisor, userID int, state WalletState) WalletStatus {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := s.Status(userID); status.State == state {
+			return status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("wallet of user %d is %s, never %s", userID, s.Status(userID).State, state)
+	return WalletStatus{}
+}
+
+func TestWalletStartsWithLoginFromFile(t *testing.T) {
+	s := newTestSupervisor(t, 1, 1)
+	if err := s.Start(1, "right"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitForOpen(1, 15*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if state := s.Status(1).State; state != StateRunning {
+		t.Fatalf("state = %s, want %s", state, StateRunning)
+	}
+
+	// the login only works if it came through the config file
+	var resp struct {
+		Result struct {
+			Version int `json:"version"`
+		} `json:"result"`
+	}
+	if err := s.Call(1, map[string]string{"jsonrpc": "2.0", "id": "0", "method": "get_version"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result.Version != 1 {
+		t.Errorf("get_version = %d, want 1", resp.Result.Version)
+	}
+
+	dir := s.WalletDir(1)
+	argv, _ := os.ReadFile(filepath.Join(dir, "argv"))
+	s.mu.Lock()
+	login := s.wallets[1].password
+	s.mu.Unlock()
+	if strings.Contains(string(argv), login) || strings.Contains(string(argv), "right") {
+		t.Errorf("a secret is on the command line: %s", argv)
+	}
+	for _, name := range []string{"wallet.password", "wallet-rpc.conf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s is still there once the wallet is open", name)
+		}
+	}
+
+	s.Stop(1)
+	if state := s.Status(1).State; state != StateStopped {
+		t.Errorf("state after Stop = %s, want %s", state, StateStopped)
+	}
+}
+
+func TestWrongPasswordIsReported(t *testing.T) {
+	s := newTestSupervisor(t, 1, 1)
+	if err := s.Start(1, "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	err := s.WaitForOpen(1, 15*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "invalid password") {
+		t.Fatalf("WaitForOpen = %v, want the invalid password wallet-rpc printed", err)
+	}
+	if status := s.Status(1); status.State != StateCrashed && status.State != StateStarting {
+		t.Errorf("state = %s, want it crashed and waiting to restart", status.State)
+	}
+}
+
+func TestCrashedWalletIsRestartedWithBackoff(t *testing.T) {
+	s := newTestSupervisor(t, 1, 1)
+	dir := s.WalletDir(1)
+	if err := os.WriteFile(filepath.Join(dir, "crashes"), []byte("3"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(1, "right"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitForState(t, s, 1, StateRunning)
+	if status.Restarts != 3 {
+		t.Errorf("restarts = %d, want 3", status.Restarts)
+	}
+	if !strings.Contains(status.LastError, "simulated crash") {
+		t.Errorf("last error = %q, want the crash wallet-rpc printed", status.LastError)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "starts"))
+	var starts []time.Time
+	for _, line := range strings.Fields(string(data)) {
+		n, _ := strconv.ParseInt(line, 10, 64)
+		starts = append(starts, time.Unix(0, n))
+	}
+	if len(starts) != 4 {
+		t.Fatalf("started %d times, want 4", len(starts))
+	}
+	// 200ms, then doubled to 400ms but capped at 300ms, twice
+	for i, min := range []time.Duration{200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if gap := starts[i+1].Sub(starts[i]); gap < min {
+			t.Errorf("restart %d came after %s, want at least %s", i+1, gap, min)
+		}
+	}
+}
+
+func TestPortPool(t *testing.T) {
+	s := newTestSupervisor(t, 2, 1, 2, 3)
+	first := s.cfg.FirstPort
+
+	// something else listens on the first port of the pool
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(first)))
+	if err != nil {
+		t.Skip("port taken before the test could take it:", err)
+	}
+	defer l.Close()
+
+	if err := s.Start(1, "right"); err != nil {
+		t.Fatal(err)
+	}
+	if port := s.Status(1).Port; port != first+1 {
+		t.Errorf("user 1 got port %d, want %d", port, first+1)
+	}
+	if err := s.Start(2, "right"); err == nil {
+		t.Errorf("user 2 got port %d from a full pool", s.Status(2).Port)
+	}
+
+	s.Stop(1)
+	if err := s.Start(3, "right"); err != nil {
+		t.Fatalf("the port user 1 had was not freed: %v", err)
+	}
+	if port := s.Status(3).Port; port != first+1 {
+		t.Errorf("user 3 got port %d, want %d", port, first+1)
+	}
+}
@@ -0,0 +1,226 @@
+package xmr
+
+import (
+	"fmt"
+	"math/big"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strings"
+	"time"
+)
+
+// transfer is one entry of a get_transfers response.
+type transfer struct {
+	TxID          string          `json:"txid"`
+	PaymentID     string          `json:"payment_id"`
+	Amount        uint64          `json:"amount"`
+	Confirmations uint64          `json:"confirmations"`
+	SubaddrIndex  subaddressIndex `json:"subaddr_index"`
+	Type          string          `json:"type"` // "in" once mined, "pool" while in the mempool
+}
+
+// blockTime is Monero's target time between blocks.
+const blockTime = 2 * time.Minute
+
+// polledFor is how long the transfers read by Poll stand in for asking the
+// wallet again, long enough to last through one round of dono checks.
+const polledFor = 20 * time.Second
+
+// polledTransfers is what one get_transfers returned for all of a user's
+// pending donos.
+type polledTransfers struct {
+	at        time.Time
+	since     time.Time // when the oldest dono it covers was created
+	transfers []transfer
+}
+
+// Transfers returns the incoming transfers to every subaddress of the
+// user's primary account in blocks above minHeight, and the ones still
+// waiting in the mempool.
+func (p *Provider) Transfers(userID int, minHeight uint64) ([]transfer, error) {
+	params := map[string]interface{}{
+		"in":            true,
+		"pool":          true,
+		"account_index": 0,
+	}
+	if minHeight > 0 {
+		params["filter_by_height"] = true
+		params["min_height"] = minHeight
+	}
+	payload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "0",
+		"method":  "get_transfers",
+		"params":  params,
+	}
+
+	var resp struct {
+		Result struct {
+			In   []transfer `json:"in"`
+			Pool []transfer `json:"pool"`
+		} `json:"result"`
+	}
+	if err := p.call(userID, payload, &resp); err != nil {
+		return nil, err
+	}
+
+	transfers := []transfer{}
+	for _, t := range resp.Result.In {
+		t.Type = "in"
+		transfers = append(transfers, t)
+	}
+	for _, t := range resp.Result.Pool {
+		t.Type = "pool"
+		t.Confirmations = 0
+		transfers = append(transfers, t)
+	}
+	return transfers, nil
+}
+
+// transfersSince returns the transfers from about the height the wallet was
+// at when since was, with some blocks to spare as blocks don't come exactly
+// every blockTime.
+func (p *Provider) transfersSince(userID int, since time.Time) ([]transfer, error) {
+	payload := map[string]string{"jsonrpc": "2.0", "id": "0", "method": "get_height"}
+	var resp struct {
+		Result struct {
+			Height uint64 `json:"height"`
+		} `json:"result"`
+	}
+	if err := p.call(userID, payload, &resp); err != nil {
+		return nil, err
+	}
+	back := uint64(time.Since(since)/blockTime)*5/4 + 20
+	var minHeight uint64
+	if resp.Result.Height > back {
+		minHeight = resp.Result.Height - back
+	}
+	return p.Transfers(userID, minHeight)
+}
+
+// Poll reads each user's transfers once for all their pending donos, from
+// when the oldest was created, so Match and Seen don't each ask the wallet
+// and never read its whole history.
+func (p *Provider) Poll(donos []utils.Dono) error {
+	oldest := make(map[int]time.Time)
+	for _, dono := range donos {
+		if since, ok := oldest[dono.UserID]; !ok || dono.CreatedAt.Before(since) {
+			oldest[dono.UserID] = dono.CreatedAt
+		}
+	}
+
+	var failed error
+	for userID, since := range oldest {
+		transfers, err := p.transfersSince(userID, since)
+		if err != nil {
+			if failed == nil {
+				failed = fmt.Errorf("user %d: %v", userID, err)
+			}
+			continue
+		}
+		p.mu.Lock()
+		p.polled[userID] = polledTransfers{at: time.Now(), since: since, transfers: transfers}
+		p.mu.Unlock()
+	}
+	return failed
+}
+
+// donoTransfers returns the transfers paying a dono: the ones to its
+// subaddress, or the ones carrying its payment ID. They come from the last
+// Poll if it covered the dono, and straight from the wallet otherwise.
+func (p *Provider) donoTransfers(dono utils.Dono) ([]transfer, error) {
+	p.mu.Lock()
+	polled, ok := p.polled[dono.UserID]
+	p.mu.Unlock()
+
+	all := polled.transfers
+	if !ok || time.Since(polled.at) > polledFor || dono.CreatedAt.Before(polled.since) {
+		var err error
+		if all, err = p.transfersSince(dono.UserID, dono.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	subaddress := isSubaddress(dono.Address)
+	var index subaddressIndex
+	if subaddress {
+		var err error
+		if index, err = p.subaddressIndex(dono.UserID, dono.Address); err != nil {
+			return nil, err
+		}
+	}
+	transfers := []transfer{}
+	for _, t := range all {
+		if subaddress && t.SubaddrIndex == index || !subaddress && samePaymentID(t.PaymentID, dono.Address) {
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers, nil
+}
+
+// samePaymentID compares short payment IDs, which some wallet versions
+// report padded with zeros to 64 characters.
+func samePaymentID(got, want string) bool {
+	if want == "" {
+		return false
+	}
+	if len(got) == 64 && strings.Trim(got[16:], "0") == "" {
+		got = got[:16]
+	}
+	return strings.EqualFold(got, want)
+}
+
+// matchTransfers sums what was sent to a dono. Every transfer, mempool
+// included, counts towards Seen, only the ones with the streamer's
+// confirmations count towards Found. Split payments add up, and if they
+// fall short the confirmed part is reported as Partial.
+func (p *Provider) matchTransfers(dono utils.Dono) (payments.Match, error) {
+	transfers, err := p.donoTransfers(dono)
+	if err != nil {
+		return payments.Match{}, err
+	}
+
+	needed := uint64(p.user(dono.UserID).XMRConfirmations)
+	if needed == 0 {
+		needed = DefaultConfirmations
+	}
+
+	seen := new(big.Int)
+	confirmed := new(big.Int)
+	confirmations := -1
+	for _, t := range transfers {
+		amount := new(big.Int).SetUint64(t.Amount)
+		seen.Add(seen, amount)
+		if t.Type == "pool" || t.Confirmations < needed {
+			continue
+		}
+		confirmed.Add(confirmed, amount)
+		if confirmations < 0 || int(t.Confirmations) < confirmations {
+			confirmations = int(t.Confirmations)
+		}
+	}
+
+	if seen.Sign() == 0 {
+		return payments.Match{}, nil
+	}
+
+	// The first transfer stands for the payment. Its output goes to the
+	// dono's own subaddress or payment ID, so a transaction paying two donos
+	// still tells them apart by the subaddress index.
+	txHash, logIndex := transfers[0].TxID, 0
+	if isSubaddress(dono.Address) {
+		logIndex = int(transfers[0].SubaddrIndex.Minor)
+	}
+
+	if seen.Cmp(dono.AtomicToSend) < 0 {
+		if confirmed.Sign() == 0 {
+			return payments.Match{}, nil
+		}
+		return payments.Match{Partial: true, AmountSent: confirmed, Confirmations: confirmations, TxHash: txHash, LogIndex: logIndex}, nil
+	}
+
+	if confirmed.Cmp(dono.AtomicToSend) < 0 {
+		return payments.Match{Seen: true, AmountSent: seen, TxHash: txHash, LogIndex: logIndex}, nil
+	}
+	return payments.Match{Found: true, Seen: true, AmountSent: confirmed, Confirmations: confirmations, TxHash: txHash, LogIndex: logIndex}, nil
+}
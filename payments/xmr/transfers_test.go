@@ -0,0 +1,83 @@
+package xmr
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"shadowchat/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollReadsTransfersOncePerUser(t *testing.T) {
+	s := newTestSupervisor(t, 1, 1)
+	dir := s.WalletDir(1)
+	transfers := `[
+		{"txid": "paid", "payment_id": "00000000000000aa", "amount": 1000000000000, "confirmations": 3},
+		{"txid": "short", "payment_id": "00000000000000bb", "amount": 400000000000, "confirmations": 3}
+	]`
+	if err := os.WriteFile(filepath.Join(dir, "transfers"), []byte(transfers), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(1, "right"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitForOpen(1, 15*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(s)
+	p.SetUser(utils.User{UserID: 1})
+	now := time.Now()
+	donos := []utils.Dono{
+		{ID: 1, UserID: 1, Address: "00000000000000aa", CurrencyType: "XMR", AtomicToSend: big.NewInt(1000000000000), CreatedAt: now.Add(-10 * time.Minute)},
+		{ID: 2, UserID: 1, Address: "00000000000000bb", CurrencyType: "XMR", AtomicToSend: big.NewInt(1000000000000), CreatedAt: now.Add(-time.Hour)},
+		{ID: 3, UserID: 1, Address: "00000000000000cc", CurrencyType: "XMR", AtomicToSend: big.NewInt(1000000000000), CreatedAt: now},
+	}
+	if err := p.Poll(donos); err != nil {
+		t.Fatal(err)
+	}
+	matches := make([]bool, len(donos))
+	for i, dono := range donos {
+		m, err := p.Seen(dono)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches[i] = m.Found
+		if dono.ID == 2 && (!m.Partial || m.AmountSent.Int64() != 400000000000) {
+			t.Errorf("short payment matched %+v, want partial", m)
+		}
+	}
+	if !matches[0] || matches[1] || matches[2] {
+		t.Errorf("found = %v, want only the first dono", matches)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "get_transfers"))
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 1 {
+		t.Fatalf("get_transfers called %d times, want once for all the user's donos", len(calls))
+	}
+	var params struct {
+		FilterByHeight bool   `json:"filter_by_height"`
+		MinHeight      uint64 `json:"min_height"`
+	}
+	json.Unmarshal([]byte(calls[0]), &params)
+	// the oldest dono is an hour, 30 blocks, old; with a quarter and 20
+	// blocks to spare
+	if !params.FilterByHeight || params.MinHeight != 1000-57 {
+		t.Errorf("get_transfers params = %s, want from height %d", calls[0], 1000-57)
+	}
+
+	// a dono older than the last poll covers is read on its own
+	older := donos[0]
+	older.CreatedAt = now.Add(-2 * time.Hour)
+	if _, err := p.Seen(older); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "get_transfers"))
+	if n := len(strings.Split(strings.TrimSpace(string(data)), "\n")); n != 2 {
+		t.Errorf("get_transfers called %d times, want the older dono read on its own", n)
+	}
+}
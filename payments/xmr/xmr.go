@@ -0,0 +1,171 @@
+package xmr
+
+import (
+	"fmt"
+	"html"
+	"math/big"
+	"shadowchat/payments"
+	"shadowchat/utils"
+	"strconv"
+	"sync"
+)
+
+// Address modes a streamer can pick for XMR donos.
+const (
+	ModeIntegrated = "integrated" // integrated address, matched on its payment ID
+	ModeSubaddress = "subaddress" // fresh subaddress, matched on its index
+)
+
+// DefaultConfirmations is used for streamers that haven't set their own.
+const DefaultConfirmations = 1
+
+// Provider accepts Monero through each user's view-only monero-wallet-rpc,
+// run by the Supervisor.
+type Provider struct {
+	wallets *Supervisor
+
+	mu           sync.Mutex
+	users        map[int]utils.User
+	subaddresses map[string]subaddressIndex // subaddress -> index, filled as they are created
+	polled       map[int]polledTransfers    // by user ID
+}
+
+// New returns a Monero provider that talks to the wallets of the given
+// supervisor.
+func New(wallets *Supervisor) *Provider {
+	return &Provider{
+		wallets:      wallets,
+		users:        make(map[int]utils.User),
+		subaddresses: make(map[string]subaddressIndex),
+		polled:       make(map[int]polledTransfers),
+	}
+}
+
+func (p *Provider) Name() string {
+	return "monero"
+}
+
+func (p *Provider) Currencies() []string {
+	return utils.CurrencyCodesForChain(utils.ChainMonero)
+}
+
+func (p *Provider) Start(users []utils.User) {
+	for _, user := range users {
+		p.SetUser(user)
+	}
+}
+
+func (p *Provider) SetUser(user utils.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[user.UserID] = user
+}
+
+func (p *Provider) user(userID int) utils.User {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.users[userID]
+}
+
+// call sends a JSON-RPC request to the user's wallet and decodes the
+// response body into out.
+func (p *Provider) call(userID int, payload interface{}, out interface{}) error {
+	return p.wallets.Call(userID, payload, out)
+}
+
+func (p *Provider) CreatePaymentRequest(user utils.User, currency string, amount float64) (payments.PaymentRequest, error) {
+	var payID, address string
+	var err error
+	if user.XMRAddressMode == ModeSubaddress {
+		address, err = p.Subaddress(user.UserID)
+		payID = address
+	} else {
+		payID, address, err = p.IntegratedAddress(user.UserID)
+	}
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+
+	atomic, err := utils.ParseAtomic(strconv.FormatFloat(amount, 'f', 4, 64), "XMR")
+	if err != nil {
+		return payments.PaymentRequest{}, err
+	}
+	amountStr := utils.FormatAtomic(atomic, "XMR")
+	req := payments.PaymentRequest{
+		Currency: "XMR",
+		Address:  address,
+		PayID:    payID,
+		Amount:   amountStr,
+		Atomic:   atomic,
+		URI:      payments.MoneroURI(address, amountStr),
+	}
+	return req, nil
+}
+
+// IntegratedAddress asks the user's wallet for a new integrated address and
+// returns its payment ID and address.
+func (p *Provider) IntegratedAddress(userID int) (string, string, error) {
+	payload := map[string]string{"jsonrpc": "2.0", "id": "0", "method": "make_integrated_address"}
+
+	resp := &utils.RPCResponse{}
+	if err := p.call(userID, payload, resp); err != nil {
+		return "", "", err
+	}
+
+	payID := html.EscapeString(resp.Result.PaymentID)
+	address := html.EscapeString(resp.Result.IntegratedAddress)
+	return payID, address, nil
+}
+
+func (p *Provider) Match(dono utils.Dono) (payments.Match, error) {
+	return p.matchTransfers(dono)
+}
+
+// Seen is Match; the wallet is local and reports mempool transfers, and
+// with the transfers read once per user by Poll it is cheap enough to ask
+// every few seconds.
+func (p *Provider) Seen(dono utils.Dono) (payments.Match, error) {
+	return p.matchTransfers(dono)
+}
+
+// Balance returns the piconero received for a payment ID in the user's wallet.
+func (p *Provider) Balance(checkID string, userID int) (*big.Int, error) {
+	payload := struct {
+		Jsonrpc string `json:"jsonrpc"`
+		Id      int    `json:"id"`
+		Method  string `json:"method"`
+		Params  struct {
+			PaymentID string `json:"payment_id"`
+		} `json:"params"`
+	}{
+		Jsonrpc: "2.0",
+		Id:      0,
+		Method:  "get_payments",
+		Params: struct {
+			PaymentID string `json:"payment_id"`
+		}{
+			PaymentID: checkID,
+		},
+	}
+
+	var result struct {
+		Result *struct {
+			Payments []struct {
+				Amount uint64 `json:"amount"`
+			} `json:"payments"`
+		} `json:"result"`
+	}
+	if err := p.call(userID, payload, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Result == nil {
+		return nil, fmt.Errorf("result key not found in response")
+	}
+
+	received := new(big.Int)
+	if len(result.Result.Payments) > 0 {
+		received.SetUint64(result.Result.Payments[0].Amount)
+	}
+	return received, nil
+}
@@ -0,0 +1,236 @@
+// Package prices keeps the USD price of every accepted currency and of the
+// fiat currencies streamers can choose, as the median of what several price
+// sources report.
+package prices
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"shadowchat/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source reports USD prices.
+type Source interface {
+	// Name returns a short identifier used in logs.
+	Name() string
+	// Fetch returns the USD price of each of the currencies and fiat
+	// currencies it knows, by code. Ones it doesn't know are left out.
+	Fetch(ctx context.Context, currencies []utils.Currency, fiats []utils.Fiat) (map[string]float64, error)
+}
+
+// Config says how often prices are fetched and how long they are good for.
+type Config struct {
+	Interval time.Duration // time between fetches
+	Timeout  time.Duration // how long each source gets to answer
+	MaxAge   time.Duration // a price not updated for this long is stale and not used
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval: 80 * time.Second,
+		Timeout:  10 * time.Second,
+		MaxAge:   10 * time.Minute,
+	}
+}
+
+// Quote is the price of a currency and when it was last fetched.
+type Quote struct {
+	Price     float64
+	Sources   int // how many sources the median was taken over
+	UpdatedAt time.Time
+}
+
+// Oracle fetches prices from all its sources at once and keeps the median
+// of the prices each currency got.
+type Oracle struct {
+	cfg     Config
+	sources []Source
+
+	mu     sync.Mutex
+	quotes map[string]Quote // by currency code
+}
+
+// New returns an oracle over the given sources.
+func New(cfg Config, sources ...Source) *Oracle {
+	return &Oracle{cfg: cfg, sources: sources, quotes: map[string]Quote{}}
+}
+
+// Refresh asks every source for the prices of the registry's currencies and
+// fiat currencies, each within the timeout, and updates the ones that got at
+// least one price. The others keep their last price until it goes stale.
+func (o *Oracle) Refresh() {
+	currencies := append([]utils.Currency(nil), utils.Currencies...)
+	fiats := []utils.Fiat{}
+	codes := []string{}
+	for _, c := range currencies {
+		codes = append(codes, c.Code)
+	}
+	for _, f := range utils.Fiats {
+		if f.Code != utils.BaseFiat {
+			fiats = append(fiats, f)
+			codes = append(codes, f.Code)
+		}
+	}
+
+	results := make([]map[string]float64, len(o.sources))
+	var wg sync.WaitGroup
+	for i, source := range o.sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), o.cfg.Timeout)
+			defer cancel()
+			prices, err := source.Fetch(ctx, currencies, fiats)
+			if err != nil {
+				log.Println("prices:", source.Name(), "failed:", err)
+				return
+			}
+			results[i] = prices
+		}(i, source)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, code := range codes {
+		values := []float64{}
+		for _, prices := range results {
+			if price, ok := prices[code]; ok && price > 0 {
+				values = append(values, price)
+			}
+		}
+		if len(values) == 0 {
+			if quote, ok := o.quotes[code]; ok && now.Sub(quote.UpdatedAt) > o.cfg.MaxAge {
+				log.Println("prices: no source has a price for", code, "since", quote.UpdatedAt.Format(time.RFC3339))
+			}
+			continue
+		}
+		o.quotes[code] = Quote{Price: median(values), Sources: len(values), UpdatedAt: now}
+	}
+}
+
+// Run refreshes the prices every Interval, calling updated after each
+// refresh.
+func (o *Oracle) Run(updated func()) {
+	for {
+		o.Refresh()
+		if updated != nil {
+			updated()
+		}
+		time.Sleep(o.cfg.Interval)
+	}
+}
+
+// Price returns the USD price of a currency or fiat currency, or false if
+// there is none or it is stale. USD itself is always 1.
+func (o *Oracle) Price(code string) (float64, bool) {
+	if strings.EqualFold(code, utils.BaseFiat) {
+		return 1, true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	quote, ok := o.quotes[strings.ToUpper(code)]
+	if !ok || time.Since(quote.UpdatedAt) > o.cfg.MaxAge {
+		return 0, false
+	}
+	return quote.Price, true
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// Source kinds, as the first word of a line of the price_sources file.
+const (
+	KindCoinGecko = "coingecko"
+	KindKraken    = "kraken"
+	KindBinance   = "binance"
+	KindStatic    = "static"
+)
+
+// DefaultSources returns the public CoinGecko, Kraken and Binance APIs.
+func DefaultSources() []Source {
+	return []Source{NewCoinGecko(DefaultCoinGecko), NewKraken(DefaultKraken), NewBinance(DefaultBinance)}
+}
+
+// ReadSources reads the price sources from a file, one per line: the kind
+// and, optionally, the API URL or, for a static source, the path of its
+// file, e.g. "kraken" or "static ./static_prices". Blank lines and lines
+// starting with # are skipped.
+func ReadSources(path string) ([]Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sources := []Source{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s: want a source kind and an optional URL or path, got %q", path, line)
+		}
+		arg := ""
+		if len(fields) == 2 {
+			arg = fields[1]
+		}
+		source, err := NewSource(fields[0], arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		sources = append(sources, source)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no price sources in %s", path)
+	}
+	return sources, nil
+}
+
+// NewSource returns a source of the given kind. arg is the API URL, empty
+// for the public one, or the path of a static source's file.
+func NewSource(kind string, arg string) (Source, error) {
+	switch kind {
+	case KindCoinGecko:
+		if arg == "" {
+			arg = DefaultCoinGecko
+		}
+		return NewCoinGecko(arg), nil
+	case KindKraken:
+		if arg == "" {
+			arg = DefaultKraken
+		}
+		return NewKraken(arg), nil
+	case KindBinance:
+		if arg == "" {
+			arg = DefaultBinance
+		}
+		return NewBinance(arg), nil
+	case KindStatic:
+		if arg == "" {
+			return nil, fmt.Errorf("a static price source needs the path of its file")
+		}
+		return NewStatic(arg), nil
+	}
+	return nil, fmt.Errorf("unknown price source %q", kind)
+}
@@ -0,0 +1,209 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"shadowchat/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// binanceServer is a Binance ticker API stand-in answering with the given
+// prices by symbol, after a delay.
+func binanceServer(t *testing.T, prices map[string]string, delay time.Duration) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/ticker/price" {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		tickers := []map[string]string{}
+		for symbol, price := range prices {
+			tickers = append(tickers, map[string]string{"symbol": symbol, "price": price})
+		}
+		json.NewEncoder(w).Encode(tickers)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestOracle returns an oracle over Binance stand-ins quoting XMR at
+// each of the given prices.
+func newTestOracle(t *testing.T, cfg Config, xmrPrices ...string) *Oracle {
+	sources := []Source{}
+	for _, price := range xmrPrices {
+		srv := binanceServer(t, map[string]string{"XMRUSDT": price}, 0)
+		sources = append(sources, NewBinance(srv.URL))
+	}
+	return New(cfg, sources...)
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices []string
+		want   float64
+		count  int
+	}{
+		{"one source", []string{"150"}, 150, 1},
+		{"odd count", []string{"170", "150", "400"}, 170, 3},
+		{"even count", []string{"150", "160", "170", "400"}, 165, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOracle(t, DefaultConfig(), tt.prices...)
+			o.Refresh()
+			price, ok := o.Price("XMR")
+			if !ok || price != tt.want {
+				t.Errorf("Price(XMR) = %v, %v, want %v", price, ok, tt.want)
+			}
+			if sources := o.quotes["XMR"].Sources; sources != tt.count {
+				t.Errorf("median taken over %d sources, want %d", sources, tt.count)
+			}
+		})
+	}
+}
+
+func TestSlowSourceIsDropped(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Timeout = 100 * time.Millisecond
+	fast := binanceServer(t, map[string]string{"XMRUSDT": "150"}, 0)
+	slow := binanceServer(t, map[string]string{"XMRUSDT": "900"}, 2*time.Second)
+	o := New(cfg, NewBinance(fast.URL), NewBinance(slow.URL))
+
+	start := time.Now()
+	o.Refresh()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("refresh waited %v for the slow source", elapsed)
+	}
+	if price, ok := o.Price("XMR"); !ok || price != 150 {
+		t.Errorf("Price(XMR) = %v, %v, want the fast source's 150", price, ok)
+	}
+	if sources := o.quotes["XMR"].Sources; sources != 1 {
+		t.Errorf("median taken over %d sources, want 1", sources)
+	}
+}
+
+func TestStalePrice(t *testing.T) {
+	cfg := DefaultConfig()
+	o := newTestOracle(t, cfg, "150")
+	o.Refresh()
+	if _, ok := o.Price("xmr"); !ok {
+		t.Fatal("fresh price not returned")
+	}
+	if price, ok := o.Price("USD"); !ok || price != 1 {
+		t.Errorf("Price(USD) = %v, %v, want 1", price, ok)
+	}
+	if _, ok := o.Price("ETH"); ok {
+		t.Error("price returned for a currency no source quoted")
+	}
+
+	o.mu.Lock()
+	quote := o.quotes["XMR"]
+	quote.UpdatedAt = time.Now().Add(-cfg.MaxAge - time.Second)
+	o.quotes["XMR"] = quote
+	o.mu.Unlock()
+	if price, ok := o.Price("XMR"); ok {
+		t.Errorf("price older than MaxAge returned: %v", price)
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStatic(t *testing.T) {
+	path := writeFile(t, "static_prices", `# by hand
+XMR 160.5
+usd-coin 1
+USDC 1.01
+EUR 1.08
+`)
+	s := NewStatic(path)
+	prices, err := s.Fetch(context.Background(), utils.Currencies, utils.Fiats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		"XMR":       160.5,
+		"USDC":      1.01, // the code wins over the id
+		"USDC_SOL":  1,
+		"USDC_TRON": 1,
+		"EUR":       1.08,
+	}
+	for code, price := range want {
+		if prices[code] != price {
+			t.Errorf("%s = %v, want %v", code, prices[code], price)
+		}
+	}
+	if _, ok := prices["ETH"]; ok {
+		t.Error("ETH priced without a line for it")
+	}
+
+	for _, bad := range []string{"XMR", "XMR 160 USD", "XMR cheap", "XMR -1"} {
+		if _, err := NewStatic(writeFile(t, "bad", bad)).Fetch(context.Background(), utils.Currencies, utils.Fiats); err == nil {
+			t.Errorf("static file %q read without error", bad)
+		}
+	}
+}
+
+func TestReadSources(t *testing.T) {
+	sources, err := ReadSources(writeFile(t, "price_sources", `
+# local mirror first
+kraken http://127.0.0.1:8080/
+coingecko
+static ./static_prices
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, s := range sources {
+		names = append(names, s.Name())
+	}
+	if got := strings.Join(names, ","); got != "kraken,coingecko,static ./static_prices" {
+		t.Errorf("sources = %s", got)
+	}
+	if url := sources[0].(*Kraken).URL; url != "http://127.0.0.1:8080" {
+		t.Errorf("kraken URL = %q", url)
+	}
+	if url := sources[1].(*CoinGecko).URL; url != DefaultCoinGecko {
+		t.Errorf("coingecko URL = %q, want the public API", url)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown kind", "bloomberg", `unknown price source "bloomberg"`},
+		{"extra field", "kraken http://a http://b", "want a source kind and an optional URL or path"},
+		{"static without path", "static", "needs the path of its file"},
+		{"no sources", "# nothing\n\n", "no price sources"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadSources(writeFile(t, "price_sources", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+	if _, err := ReadSources(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file read without error")
+	}
+}
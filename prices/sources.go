@@ -0,0 +1,358 @@
+package prices
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"shadowchat/utils"
+	"strconv"
+	"strings"
+)
+
+// Public APIs of the sources.
+const (
+	DefaultCoinGecko = "https://api.coingecko.com"
+	DefaultKraken    = "https://api.kraken.com"
+	DefaultBinance   = "https://api.binance.com"
+)
+
+// byPriceID spreads prices keyed by CoinGecko id over the currencies that
+// share the id, e.g. USDC on every chain.
+func byPriceID(currencies []utils.Currency, prices map[string]float64) map[string]float64 {
+	result := map[string]float64{}
+	for _, c := range currencies {
+		if price, ok := prices[c.PriceID]; ok {
+			result[c.Code] = price
+		}
+	}
+	return result
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("non-200 response code received: %d %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// CoinGecko reads prices from CoinGecko's simple price API, which knows
+// every currency by its PriceID. Fiat currencies are priced by comparing
+// what the currencies cost in them and in USD.
+type CoinGecko struct {
+	URL  string
+	http *http.Client
+}
+
+func NewCoinGecko(url string) *CoinGecko {
+	return &CoinGecko{URL: strings.TrimRight(url, "/"), http: &http.Client{}}
+}
+
+func (s *CoinGecko) Name() string {
+	return KindCoinGecko
+}
+
+func (s *CoinGecko) Fetch(ctx context.Context, currencies []utils.Currency, fiats []utils.Fiat) (map[string]float64, error) {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, c := range currencies {
+		if c.PriceID != "" && !seen[c.PriceID] {
+			seen[c.PriceID] = true
+			ids = append(ids, c.PriceID)
+		}
+	}
+
+	vs := []string{"usd"}
+	for _, f := range fiats {
+		vs = append(vs, strings.ToLower(f.Code))
+	}
+
+	var data map[string]map[string]float64
+	u := s.URL + "/api/v3/simple/price?ids=" + url.QueryEscape(strings.Join(ids, ",")) + "&vs_currencies=" + strings.Join(vs, ",")
+	if err := getJSON(ctx, s.http, u, &data); err != nil {
+		return nil, err
+	}
+	prices := map[string]float64{}
+	for id, quote := range data {
+		if usd, ok := quote["usd"]; ok {
+			prices[id] = usd
+		}
+	}
+	result := byPriceID(currencies, prices)
+	for _, f := range fiats {
+		rates := []float64{}
+		for _, quote := range data {
+			usd, inFiat := quote["usd"], quote[strings.ToLower(f.Code)]
+			if usd > 0 && inFiat > 0 {
+				rates = append(rates, usd/inFiat)
+			}
+		}
+		if len(rates) > 0 {
+			result[f.Code] = median(rates)
+		}
+	}
+	return result, nil
+}
+
+// krakenPairs are the Kraken USD pairs of the coins it trades, by PriceID.
+var krakenPairs = map[string]string{
+	"bitcoin":         "XBTUSD",
+	"ethereum":        "ETHUSD",
+	"monero":          "XMRUSD",
+	"solana":          "SOLUSD",
+	"usd-coin":        "USDCUSD",
+	"tether":          "USDTZUSD",
+	"shiba-inu":       "SHIBUSD",
+	"matic-network":   "MATICUSD",
+	"wrapped-bitcoin": "WBTCUSD",
+	"kleros":          "PNKUSD",
+}
+
+// krakenFiatPairs are the Kraken pairs of fiat currencies against USD, and
+// whether USD is the base of the pair, so its price has to be inverted.
+var krakenFiatPairs = map[string]struct {
+	pair    string
+	inverse bool
+}{
+	"EUR": {"EURUSD", false},
+	"GBP": {"GBPUSD", false},
+	"AUD": {"AUDUSD", false},
+	"CAD": {"USDCAD", true},
+	"CHF": {"USDCHF", true},
+}
+
+// Kraken reads the last trade prices of Kraken's USD pairs. Currencies
+// without a pair are left out.
+type Kraken struct {
+	URL  string
+	http *http.Client
+}
+
+func NewKraken(url string) *Kraken {
+	return &Kraken{URL: strings.TrimRight(url, "/"), http: &http.Client{}}
+}
+
+func (s *Kraken) Name() string {
+	return KindKraken
+}
+
+// Fetch asks for one pair at a time, as Kraken fails a whole request if any
+// pair in it is unknown.
+func (s *Kraken) Fetch(ctx context.Context, currencies []utils.Currency, fiats []utils.Fiat) (map[string]float64, error) {
+	prices := map[string]float64{}
+	var lastErr error
+	for id, pair := range krakenPairs {
+		if !usedPriceID(currencies, id) {
+			continue
+		}
+		price, err := s.last(ctx, pair)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		prices[id] = price
+	}
+	result := byPriceID(currencies, prices)
+
+	for _, f := range fiats {
+		fp, ok := krakenFiatPairs[f.Code]
+		if !ok {
+			continue
+		}
+		price, err := s.last(ctx, fp.pair)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if fp.inverse {
+			price = 1 / price
+		}
+		result[f.Code] = price
+	}
+
+	if len(result) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+// last returns the last trade price of a pair.
+func (s *Kraken) last(ctx context.Context, pair string) (float64, error) {
+	var resp struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			Last []string `json:"c"` // price, lot volume
+		} `json:"result"`
+	}
+	if err := getJSON(ctx, s.http, s.URL+"/0/public/Ticker?pair="+pair, &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.Error) > 0 {
+		return 0, fmt.Errorf("%s: %s", pair, strings.Join(resp.Error, ", "))
+	}
+	for _, ticker := range resp.Result {
+		if len(ticker.Last) == 0 {
+			continue
+		}
+		price, err := strconv.ParseFloat(ticker.Last[0], 64)
+		if err != nil || price <= 0 {
+			return 0, fmt.Errorf("%s: bad price %q", pair, ticker.Last[0])
+		}
+		return price, nil
+	}
+	return 0, fmt.Errorf("%s: no ticker returned", pair)
+}
+
+// binanceSymbols are the Binance USDT pairs of the coins it trades, by
+// PriceID. Binance quotes against USDT rather than USD, which is close
+// enough once the median is taken, but it can't price USDT itself.
+var binanceSymbols = map[string]string{
+	"bitcoin":         "BTCUSDT",
+	"ethereum":        "ETHUSDT",
+	"monero":          "XMRUSDT",
+	"solana":          "SOLUSDT",
+	"usd-coin":        "USDCUSDT",
+	"shiba-inu":       "SHIBUSDT",
+	"matic-network":   "MATICUSDT",
+	"wrapped-bitcoin": "WBTCUSDT",
+}
+
+// binanceFiatSymbols are the Binance pairs of fiat currencies against USDT.
+var binanceFiatSymbols = map[string]string{
+	"EUR": "EURUSDT",
+}
+
+// Binance reads the last prices of Binance's USDT pairs. Currencies
+// without a pair are left out.
+type Binance struct {
+	URL  string
+	http *http.Client
+}
+
+func NewBinance(url string) *Binance {
+	return &Binance{URL: strings.TrimRight(url, "/"), http: &http.Client{}}
+}
+
+func (s *Binance) Name() string {
+	return KindBinance
+}
+
+func (s *Binance) Fetch(ctx context.Context, currencies []utils.Currency, fiats []utils.Fiat) (map[string]float64, error) {
+	var tickers []struct {
+		Symbol string `json:"symbol"`
+		Price  string `json:"price"`
+	}
+	if err := getJSON(ctx, s.http, s.URL+"/api/v3/ticker/price", &tickers); err != nil {
+		return nil, err
+	}
+	bySymbol := make(map[string]string, len(tickers))
+	for _, t := range tickers {
+		bySymbol[t.Symbol] = t.Price
+	}
+
+	prices := map[string]float64{}
+	for id, symbol := range binanceSymbols {
+		if price, err := strconv.ParseFloat(bySymbol[symbol], 64); err == nil {
+			prices[id] = price
+		}
+	}
+	result := byPriceID(currencies, prices)
+	for _, f := range fiats {
+		if price, err := strconv.ParseFloat(bySymbol[binanceFiatSymbols[f.Code]], 64); err == nil {
+			result[f.Code] = price
+		}
+	}
+	return result, nil
+}
+
+func usedPriceID(currencies []utils.Currency, id string) bool {
+	for _, c := range currencies {
+		if c.PriceID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Static reads prices from a file kept up to date by hand, for servers that
+// can't reach any price API. Each line holds a currency code, a CoinGecko
+// id or a fiat currency code and its USD price, e.g. "XMR 160.5",
+// "usd-coin 1" or "EUR 1.08". A code wins over an id. The file is read on
+// every fetch, so its prices are as fresh as the last fetch; keeping them
+// current is up to whoever edits it.
+type Static struct {
+	Path string
+}
+
+func NewStatic(path string) *Static {
+	return &Static{Path: path}
+}
+
+func (s *Static) Name() string {
+	return KindStatic + " " + s.Path
+}
+
+func (s *Static) Fetch(ctx context.Context, currencies []utils.Currency, fiats []utils.Fiat) (map[string]float64, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byCode := map[string]float64{}
+	byID := map[string]float64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: want a currency and its USD price, got %q", s.Path, line)
+		}
+		price, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("%s: %q is not a price", s.Path, fields[1])
+		}
+		byCode[strings.ToUpper(fields[0])] = price
+		byID[fields[0]] = price
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	prices := byPriceID(currencies, byID)
+	for _, c := range currencies {
+		if price, ok := byCode[c.Code]; ok {
+			prices[c.Code] = price
+		}
+	}
+	for _, f := range fiats {
+		if price, ok := byCode[f.Code]; ok {
+			prices[f.Code] = price
+		}
+	}
+	return prices, nil
+}
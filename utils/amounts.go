@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Amounts are carried around as *big.Int in the smallest unit of their
+// currency (piconero, lamports, wei, token base units). decimal is only used
+// to convert between those and the human readable amounts shown on pages.
+
+// AtomicFromFloat converts a whole-coin amount, such as the result of a USD
+// price conversion, to atomic units. Digits past the currency's decimals are
+// rounded away. NaN and infinite amounts, from a missing price, give zero.
+func AtomicFromFloat(amount float64, code string) *big.Int {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return new(big.Int)
+	}
+	decimals, _ := GetCryptoDecimalsByCode(code)
+	d := decimal.NewFromFloat(amount).Shift(int32(decimals)).Round(0)
+	return d.BigInt()
+}
+
+// ParseAtomic converts a whole-coin amount string like "0.0125" to atomic
+// units. It fails if the string has more digits than the currency allows.
+func ParseAtomic(amount string, code string) (*big.Int, error) {
+	c, ok := GetCurrency(code)
+	if !ok {
+		return nil, fmt.Errorf("crypto with code %s not found", code)
+	}
+	d, err := decimal.NewFromString(strings.TrimSpace(amount))
+	if err != nil {
+		return nil, err
+	}
+	shifted := d.Shift(int32(c.Decimals))
+	if !shifted.Equal(shifted.Truncate(0)) {
+		return nil, fmt.Errorf("%s has more than %d decimals", amount, c.Decimals)
+	}
+	return shifted.BigInt(), nil
+}
+
+// FormatAtomic returns atomic units as a whole-coin amount string without
+// trailing zeros, e.g. 12500000 lamports -> "0.0125".
+func FormatAtomic(atomic *big.Int, code string) string {
+	if atomic == nil {
+		return "0"
+	}
+	decimals, _ := GetCryptoDecimalsByCode(code)
+	return decimal.NewFromBigInt(atomic, -int32(decimals)).String()
+}
+
+// ParseAtomicString reads atomic units stored as a base 10 string, as they
+// are in the donos table. Empty or malformed values return false.
+func ParseAtomicString(s string) (*big.Int, bool) {
+	if s == "" {
+		return nil, false
+	}
+	return new(big.Int).SetString(s, 10)
+}
+
+// ParseHexAtomic reads a 0x prefixed quantity as returned by Ethereum nodes.
+func ParseHexAtomic(s string) (*big.Int, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if s == "" {
+		return new(big.Int), true
+	}
+	return new(big.Int).SetString(s, 16)
+}
+
+// fuzzDraws is how many times FuzzAtomic draws before giving up on finding
+// an amount that isn't taken.
+const fuzzDraws = 20
+
+// FuzzAtomic adds a small random number of atomic units to an amount so that
+// two donos of the same value to the same address can be told apart. HEX gets
+// a wider range, matching how it has always been fuzzed. SOL donos are told
+// apart by their Solana Pay reference instead. An amount taken reports as
+// already waited for by another dono is drawn again; taken may be nil.
+func FuzzAtomic(atomic *big.Int, code string, taken func(*big.Int) bool) (*big.Int, error) {
+	decimals, _ := GetCryptoDecimalsByCode(code)
+	digits := decimals - 7
+	if code == "HEX" {
+		digits = decimals - 3
+	}
+
+	span := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	if digits < 2 {
+		span = big.NewInt(100)
+	}
+
+	for i := 0; i < fuzzDraws; i++ {
+		n, err := rand.Int(rand.Reader, span)
+		if err != nil {
+			return nil, err
+		}
+		fuzzed := new(big.Int).Add(atomic, n)
+		if taken == nil || !taken(fuzzed) {
+			return fuzzed, nil
+		}
+	}
+	return nil, fmt.Errorf("no free %s amount near %s after %d draws", code, FormatAtomic(atomic, code), fuzzDraws)
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func atomic(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("bad atomic amount " + s)
+	}
+	return n
+}
+
+func TestAtomicFromFloat(t *testing.T) {
+	tests := []struct {
+		amount float64
+		code   string
+		want   string
+	}{
+		{1, "ETH", "1000000000000000000"},
+		{0.1 + 0.2, "ETH", "300000000000000000"},
+		{0.1 + 0.2, "XMR", "300000000000"},
+		{0.0125, "SOL", "12500000"},
+		{0.00000001, "BTC", "1"},
+		{0.000000015, "WBTC", "2"},
+		{1234.5678, "HEX", "123456780000"},
+		{12.345678, "USDC", "12345678"},
+		{12.3456785, "USDT", "12345679"},
+		{0, "ETH", "0"},
+		{math.NaN(), "XMR", "0"},
+		{math.Inf(1), "SOL", "0"},
+	}
+	for _, tt := range tests {
+		if got := AtomicFromFloat(tt.amount, tt.code); got.String() != tt.want {
+			t.Errorf("AtomicFromFloat(%v, %s) = %s, want %s", tt.amount, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestParseAtomic(t *testing.T) {
+	tests := []struct {
+		amount string
+		code   string
+		want   string
+	}{
+		{"1", "ETH", "1000000000000000000"},
+		{"0.000000000000000001", "ETH", "1"},
+		{" 0.3 ", "XMR", "300000000000"},
+		{"0.000000000001", "XMR", "1"},
+		{"0.0125", "SOL", "12500000"},
+		{"0.00000001", "BTC", "1"},
+		{"21000000", "BTC", "2100000000000000"},
+		{"1.5", "WBTC", "150000000"},
+		{"1234.56789", "HEX", "123456789000"},
+		{"12.345678", "USDC", "12345678"},
+		{"0", "USDT", "0"},
+	}
+	for _, tt := range tests {
+		got, err := ParseAtomic(tt.amount, tt.code)
+		if err != nil {
+			t.Errorf("ParseAtomic(%q, %s): %v", tt.amount, tt.code, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseAtomic(%q, %s) = %s, want %s", tt.amount, tt.code, got, tt.want)
+		}
+	}
+
+	bad := []struct {
+		amount string
+		code   string
+	}{
+		{"0.0000000000000000001", "ETH"},
+		{"0.0000000000001", "XMR"},
+		{"0.0000000001", "SOL"},
+		{"0.000000001", "BTC"},
+		{"0.000000001", "HEX"},
+		{"0.0000001", "USDC"},
+		{"one", "ETH"},
+		{"", "XMR"},
+		{"1", "DOGE"},
+	}
+	for _, tt := range bad {
+		if got, err := ParseAtomic(tt.amount, tt.code); err == nil {
+			t.Errorf("ParseAtomic(%q, %s) = %s, want an error", tt.amount, tt.code, got)
+		}
+	}
+}
+
+func TestFormatAtomic(t *testing.T) {
+	tests := []struct {
+		atomic string
+		code   string
+		want   string
+	}{
+		{"1000000000000000000", "ETH", "1"},
+		{"1", "ETH", "0.000000000000000001"},
+		{"300000000000", "XMR", "0.3"},
+		{"12500000", "SOL", "0.0125"},
+		{"2100000000000000", "BTC", "21000000"},
+		{"123456789000", "HEX", "1234.56789"},
+		{"150000000", "WBTC", "1.5"},
+		{"12345678", "USDC", "12.345678"},
+		{"0", "USDT", "0"},
+	}
+	for _, tt := range tests {
+		if got := FormatAtomic(atomic(tt.atomic), tt.code); got != tt.want {
+			t.Errorf("FormatAtomic(%s, %s) = %q, want %q", tt.atomic, tt.code, got, tt.want)
+		}
+	}
+	if got := FormatAtomic(nil, "ETH"); got != "0" {
+		t.Errorf("FormatAtomic(nil) = %q, want 0", got)
+	}
+}
+
+func TestAtomicRoundTrip(t *testing.T) {
+	amounts := map[string][]string{
+		"ETH":  {"1", "0.000000000000000001", "123456789.123456789123456789"},
+		"XMR":  {"0.3", "0.000000000001", "18446744.073709551616"},
+		"SOL":  {"0.0125", "0.000000001"},
+		"BTC":  {"0.00000001", "21000000"},
+		"WBTC": {"1.5", "0.12345678"},
+		"HEX":  {"1234.56789", "0.00000001"},
+		"USDC": {"12.345678", "1000000"},
+		"USDT": {"0.000001"},
+	}
+	for code, list := range amounts {
+		for _, amount := range list {
+			n, err := ParseAtomic(amount, code)
+			if err != nil {
+				t.Errorf("ParseAtomic(%q, %s): %v", amount, code, err)
+				continue
+			}
+			if got := FormatAtomic(n, code); got != amount {
+				t.Errorf("%s %s came back as %s", amount, code, got)
+			}
+		}
+	}
+}
+
+func TestParseHexAtomic(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		ok   bool
+	}{
+		{"0x0", "0", true},
+		{"0x", "0", true},
+		{"", "0", true},
+		{"0x10", "16", true},
+		{"0XFF", "255", true},
+		{"0xde0b6b3a7640000", "1000000000000000000", true},
+		{"0x00000000000000000000000000000000000000000000000000000000004c4b40", "5000000", true},
+		{"ff", "255", true},
+		{"0xzz", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseHexAtomic(tt.s)
+		if ok != tt.ok || (ok && got.String() != tt.want) {
+			t.Errorf("ParseHexAtomic(%q) = %v, %v, want %s, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
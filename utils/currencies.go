@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Currency describes one accepted cryptocurrency. Templates, minimum dono
+// calculation, icon routes, the price fetcher and the payment providers all
+// read from Currencies, so adding a coin means adding one entry here.
+type Currency struct {
+	Code     string // ticker stored on donos, e.g. "XMR"
+	Key      string // lowercase id used by the crypto settings form
+	Name     string
+	Chain    string // chain of the payment provider that accepts it
+	ChainID  uint64 // EVM chain ID, 0 off EVM chains
+	Decimals int
+	Contract string // token contract (the mint on Solana), empty for a chain's native coin
+	PriceID  string // CoinGecko id
+	Icon     string // file served from web/
+}
+
+const (
+	ChainMonero    = "monero"
+	ChainSolana    = "solana"
+	ChainEthereum  = "ethereum"
+	ChainBitcoin   = "bitcoin"
+	ChainLightning = "lightning"
+	ChainTron      = "tron"
+)
+
+var Currencies = []Currency{
+	{Code: "XMR", Key: "monero", Name: "Monero", Chain: ChainMonero, Decimals: 12, PriceID: "monero", Icon: "xmr.svg"},
+	{Code: "BTC", Key: "bitcoin", Name: "Bitcoin", Chain: ChainBitcoin, Decimals: 8, PriceID: "bitcoin", Icon: "btc.svg"},
+	{Code: "BTC_LN", Key: "lightning", Name: "Bitcoin (Lightning)", Chain: ChainLightning, Decimals: 8, PriceID: "bitcoin", Icon: "lightning.svg"},
+	{Code: "SOL", Key: "solana", Name: "Solana", Chain: ChainSolana, Decimals: 9, PriceID: "solana", Icon: "sol.svg"},
+	{Code: "ETH", Key: "ethereum", Name: "Ethereum", Chain: ChainEthereum, ChainID: 1, Decimals: 18, PriceID: "ethereum", Icon: "eth.svg"},
+	{Code: "PAINT", Key: "paint", Name: "Paint", Chain: ChainEthereum, ChainID: 1, Decimals: 18, Contract: "0x4c6ec08cf3fc987c6c4beb03184d335a2dfc4042", PriceID: "paint", Icon: "paint.svg"},
+	{Code: "HEX", Key: "hex", Name: "Hexcoin", Chain: ChainEthereum, ChainID: 1, Decimals: 8, Contract: "0x2b591e99afE9f32eAA6214f7B7629768c40Eeb39", PriceID: "hex", Icon: "hex.svg"},
+	{Code: "MATIC", Key: "matic", Name: "Polygon", Chain: ChainEthereum, ChainID: 1, Decimals: 18, Contract: "0x7D1AfA7B718fb893dB30A3aBc0Cfc608AaCfeBB0", PriceID: "matic-network", Icon: "matic.svg"},
+	{Code: "BUSD", Key: "busd", Name: "Binance USD", Chain: ChainEthereum, ChainID: 1, Decimals: 18, Contract: "0x4Fabb145d64652a948d72533023f6E7A623C7C53", PriceID: "binance-usd", Icon: "busd.svg"},
+	{Code: "SHIB", Key: "shiba_inu", Name: "Shiba Inu", Chain: ChainEthereum, ChainID: 1, Decimals: 18, Contract: "0x95aD61b0a150d79219dCF64E1E6Cc01f0B64C4cE", PriceID: "shiba-inu", Icon: "shiba_inu.svg"},
+	{Code: "PNK", Key: "pnk", Name: "Kleros", Chain: ChainEthereum, ChainID: 1, Decimals: 18, Contract: "0x93ed3fbe21207ec2e8f2d3c3de6e058cb73bc04d", PriceID: "kleros", Icon: "pnk.svg"},
+	{Code: "USDC", Key: "usdc", Name: "USD Coin", Chain: ChainEthereum, ChainID: 1, Decimals: 6, Contract: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", PriceID: "usd-coin", Icon: "usdc.svg"},
+	{Code: "USDT", Key: "tether", Name: "Tether", Chain: ChainEthereum, ChainID: 1, Decimals: 6, Contract: "0xdAC17F958D2ee523a2206206994597C13D831ec7", PriceID: "tether", Icon: "tether.svg"},
+	{Code: "WBTC", Key: "wbtc", Name: "Wrapped Bitcoin", Chain: ChainEthereum, ChainID: 1, Decimals: 8, Contract: "0x2260FAC5E5542a773Aa44fBCfeDf7C193bc2C599", PriceID: "wrapped-bitcoin", Icon: "wbtc.svg"},
+	{Code: "USDC_SOL", Key: "usdc_sol", Name: "USD Coin (Solana)", Chain: ChainSolana, Decimals: 6, Contract: "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", PriceID: "usd-coin", Icon: "usdc.svg"},
+	{Code: "USDT_SOL", Key: "tether_sol", Name: "Tether (Solana)", Chain: ChainSolana, Decimals: 6, Contract: "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB", PriceID: "tether", Icon: "tether.svg"},
+	{Code: "USDT_TRON", Key: "tether_tron", Name: "Tether (Tron)", Chain: ChainTron, Decimals: 6, Contract: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", PriceID: "tether", Icon: "tether.svg"},
+	{Code: "USDC_TRON", Key: "usdc_tron", Name: "USD Coin (Tron)", Chain: ChainTron, Decimals: 6, Contract: "TEkxiTehnzSmSe2XqrBj4w32RUN966rdz8", PriceID: "usd-coin", Icon: "usdc.svg"},
+}
+
+// AddCurrency adds a currency defined at startup, such as a token on a
+// chain listed in the configuration.
+func AddCurrency(c Currency) error {
+	if _, ok := GetCurrency(c.Code); ok {
+		return fmt.Errorf("currency %s is already defined", c.Code)
+	}
+	Currencies = append(Currencies, c)
+	return nil
+}
+
+// GetCurrency returns the registry entry for a currency code.
+func GetCurrency(code string) (Currency, bool) {
+	code = strings.ToUpper(code)
+	for _, c := range Currencies {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return Currency{}, false
+}
+
+// CurrencyCodesForChain returns the codes of every currency on a chain.
+func CurrencyCodesForChain(chain string) []string {
+	codes := []string{}
+	for _, c := range Currencies {
+		if c.Chain == chain {
+			codes = append(codes, c.Code)
+		}
+	}
+	return codes
+}
+
+// CurrencyByContract returns the token with the given contract address on
+// a chain.
+func CurrencyByContract(chain string, contract string) (Currency, bool) {
+	for _, c := range Currencies {
+		if c.Chain == chain && c.Contract != "" && strings.EqualFold(c.Contract, contract) {
+			return c, true
+		}
+	}
+	return Currency{}, false
+}
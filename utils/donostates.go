@@ -0,0 +1,68 @@
+package utils
+
+// DonoState is where a dono is in its life, from being created to being
+// paid or given up on. Every change of state is recorded in dono_events.
+type DonoState string
+
+const (
+	DonoCreated          DonoState = "created"           // stored, no payment request shown yet
+	DonoAwaitingPayment  DonoState = "awaiting_payment"  // the donor has a payment request
+	DonoSeen             DonoState = "seen"              // the payment showed up, not yet confirmed
+	DonoConfirmed        DonoState = "confirmed"         // paid and confirmed
+	DonoExpired          DonoState = "expired"           // not paid in time
+	DonoUnderpaid        DonoState = "underpaid"         // paid less than asked, or late and below the streamer's minimum at the rate then
+	DonoCancelled        DonoState = "cancelled"         // there was nothing to pay, or nowhere to pay it
+	DonoRefundedManually DonoState = "refunded_manually" // paid, and the streamer says they refunded it
+)
+
+// PendingDonoStates are the states of donos still being watched for a
+// payment.
+var PendingDonoStates = []DonoState{DonoCreated, DonoAwaitingPayment, DonoSeen}
+
+// ReceivedDonoStates are the states of donos that were paid.
+var ReceivedDonoStates = []DonoState{DonoConfirmed, DonoUnderpaid, DonoRefundedManually}
+
+// donoTransitions lists the states each state can change to. Expired,
+// cancelled and refunded donos are final.
+var donoTransitions = map[DonoState][]DonoState{
+	DonoCreated:         {DonoAwaitingPayment, DonoExpired, DonoCancelled},
+	DonoAwaitingPayment: {DonoSeen, DonoConfirmed, DonoUnderpaid, DonoExpired, DonoCancelled},
+	DonoSeen:            {DonoConfirmed, DonoUnderpaid, DonoExpired},
+	DonoConfirmed:       {DonoRefundedManually},
+	DonoUnderpaid:       {DonoRefundedManually},
+}
+
+// CanBecome reports whether a dono in state s can change to state to.
+func (s DonoState) CanBecome(to DonoState) bool {
+	for _, next := range donoTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Pending reports whether a dono in state s is still waiting for its payment.
+func (s DonoState) Pending() bool {
+	return s.in(PendingDonoStates)
+}
+
+// Received reports whether a dono in state s was paid.
+func (s DonoState) Received() bool {
+	return s.in(ReceivedDonoStates)
+}
+
+// Refundable reports whether a streamer can mark a dono in state s as
+// refunded.
+func (s DonoState) Refundable() bool {
+	return s.CanBecome(DonoRefundedManually)
+}
+
+func (s DonoState) in(states []DonoState) bool {
+	for _, state := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Fiat is a currency streamers can set their minimums and goals in. Every
+// dono stores its value in the streamer's fiat currency alongside USD.
+type Fiat struct {
+	Code   string // ISO 4217 code, e.g. "EUR"
+	Symbol string // shown before amounts
+	Name   string
+}
+
+// BaseFiat is what prices are fetched in. Other fiat currencies are priced
+// in it like cryptocurrencies are.
+const BaseFiat = "USD"
+
+var Fiats = []Fiat{
+	{Code: "USD", Symbol: "$", Name: "US Dollar"},
+	{Code: "EUR", Symbol: "€", Name: "Euro"},
+	{Code: "GBP", Symbol: "£", Name: "Pound Sterling"},
+	{Code: "CHF", Symbol: "CHF ", Name: "Swiss Franc"},
+	{Code: "CAD", Symbol: "CA$", Name: "Canadian Dollar"},
+	{Code: "AUD", Symbol: "A$", Name: "Australian Dollar"},
+}
+
+// GetFiat returns the registry entry for a fiat currency code.
+func GetFiat(code string) (Fiat, bool) {
+	code = strings.ToUpper(code)
+	for _, f := range Fiats {
+		if f.Code == code {
+			return f, true
+		}
+	}
+	return Fiat{}, false
+}
+
+// FiatSymbol returns the symbol of a fiat currency, that of USD for an
+// unknown or empty one.
+func FiatSymbol(code string) string {
+	f, ok := GetFiat(code)
+	if !ok {
+		f, _ = GetFiat(BaseFiat)
+	}
+	return f.Symbol
+}
+
+// USDToFiat converts a USD amount to a fiat currency whose USD price is
+// fiatPrice, rounded to cents.
+func USDToFiat(usd float64, fiatPrice float64) float64 {
+	return math.Round(usd/fiatPrice*100) / 100
+}
+
+// FormatFiat formats an amount of a fiat currency with its symbol, e.g.
+// "€12.50".
+func FormatFiat(amount float64, code string) string {
+	return FiatSymbol(code) + strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// FormatFiatPrice formats the price of one unit of a currency in a fiat
+// currency with its symbol. Prices under 1 keep four significant digits, so
+// that of a coin worth a fraction of a cent doesn't show as "$0.00".
+func FormatFiatPrice(price float64, code string) string {
+	decimals := 2
+	if price > 0 && price < 1 {
+		decimals = int(math.Ceil(-math.Log10(price))) + 3
+	}
+	return FiatSymbol(code) + strconv.FormatFloat(price, 'f', decimals, 64)
+}
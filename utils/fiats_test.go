@@ -0,0 +1,86 @@
+package utils
+
+import "testing"
+
+func TestGetFiat(t *testing.T) {
+	if f, ok := GetFiat("eur"); !ok || f.Code != "EUR" || f.Symbol != "€" {
+		t.Errorf("GetFiat(eur) = %+v, %v", f, ok)
+	}
+	if f, ok := GetFiat("XYZ"); ok {
+		t.Errorf("GetFiat(XYZ) = %+v, want none", f)
+	}
+}
+
+func TestFiatSymbol(t *testing.T) {
+	tests := map[string]string{
+		"USD": "$",
+		"EUR": "€",
+		"gbp": "£",
+		"CHF": "CHF ",
+		"":    "$",
+		"XYZ": "$",
+	}
+	for code, want := range tests {
+		if got := FiatSymbol(code); got != want {
+			t.Errorf("FiatSymbol(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestUSDToFiat(t *testing.T) {
+	tests := []struct {
+		usd, fiatPrice, want float64
+	}{
+		{10, 1, 10},
+		{10.8, 1.08, 10},
+		{10, 1.08, 9.26},
+		{10, 0.73, 13.7},
+		{0.004, 1, 0},
+		{0.005, 1, 0.01},
+	}
+	for _, tt := range tests {
+		if got := USDToFiat(tt.usd, tt.fiatPrice); got != tt.want {
+			t.Errorf("USDToFiat(%v, %v) = %v, want %v", tt.usd, tt.fiatPrice, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFiat(t *testing.T) {
+	tests := []struct {
+		amount float64
+		code   string
+		want   string
+	}{
+		{12.5, "EUR", "€12.50"},
+		{3, "CHF", "CHF 3.00"},
+		{0.126, "USD", "$0.13"},
+		{1234.567, "CAD", "CA$1234.57"},
+		{5, "", "$5.00"},
+	}
+	for _, tt := range tests {
+		if got := FormatFiat(tt.amount, tt.code); got != tt.want {
+			t.Errorf("FormatFiat(%v, %q) = %q, want %q", tt.amount, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestFormatFiatPrice(t *testing.T) {
+	tests := []struct {
+		price float64
+		code  string
+		want  string
+	}{
+		{160.5, "EUR", "€160.50"},
+		{64123.456, "USD", "$64123.46"},
+		{1, "GBP", "£1.00"},
+		{0.5, "AUD", "A$0.5000"},
+		{0.1, "USD", "$0.1000"},
+		{0.00001234, "EUR", "€0.00001234"},
+		{0, "USD", "$0.00"},
+	}
+	for _, tt := range tests {
+		if got := FormatFiatPrice(tt.price, tt.code); got != tt.want {
+			t.Errorf("FormatFiatPrice(%v, %q) = %q, want %q", tt.price, tt.code, got, tt.want)
+		}
+	}
+}
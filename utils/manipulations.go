@@ -3,10 +3,8 @@ package utils
 import (
 	"fmt"
 	"github.com/shopspring/decimal"
-	"math/rand"
 	"strconv"
 	"strings"
-	"time"
 	"unicode"
 )
 
@@ -94,27 +92,3 @@ func FloatToString(f float64) string {
 	d, _ := ConvertStringTo18DecimalPlaces(decimal.NewFromFloat(f).String())
 	return d
 }
-
-// TODO: Add more intelligent fuzzing so that if there is a small chance of an overlapping dono (exact same fuzzing and dono) then the dono is fuzzed again
-// this will almost never happen but it's better than leaving it up to chance
-func FuzzDono(ethAmount float64, cryptoCode string) float64 {
-	if cryptoCode == "HEX" || cryptoCode == "SOL" {
-		// generate random value between 0 and 1 millionth
-		rand.Seed(time.Now().UnixNano())
-		randVal := rand.Float64() / 1000.0
-
-		// add random value to input amount
-		newAmount := ethAmount + randVal
-
-		return newAmount
-	} else {
-		// generate random value between 0 and 1 millionth
-		rand.Seed(time.Now().UnixNano())
-		randVal := rand.Float64() / 10000000.0
-
-		// add random value to input amount
-		newAmount := ethAmount + randVal
-
-		return newAmount
-	}
-}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/ed25519"
+	"html/template"
 	"math/big"
 	"time"
 )
@@ -26,9 +27,21 @@ type CryptoSuperChat struct {
 	PayID           string
 	CheckURL        string
 	Currency        string
+	Chain           string
 	DonationID      int64
 	ContractAddress string
 	WeiAmount       *big.Int
+	WalletLinks     []WalletLink // ways to open the payment URI in a wallet
+	Reference       string       // set when only a payment from the QR code or a wallet link is recognized
+	Network         string       // EVM chain to pay on, when it isn't Ethereum mainnet
+	Rate            string       // price of one unit of Currency the dono is quoted at, in the streamer's fiat currency
+	QuoteExpiresAt  time.Time    // when the rate stops holding
+}
+
+// WalletLink is a link on the pay page that opens a payment in a wallet.
+type WalletLink struct {
+	Name string
+	URL  template.URL // payment URI schemes would be filtered out as plain strings
 }
 
 type SuperChat struct {
@@ -50,6 +63,8 @@ type Donation struct {
 	DonationMessage string `json:"donationMessage"`
 	DonationMedia   string `json:"donationMedia"`
 	USDValue        string `json:"usdValue"`
+	FiatValue       string `json:"fiatValue"` // in Fiat, empty for donations from before fiat values were stored
+	Fiat            string `json:"fiat"`
 	AmountSent      string `json:"amountSent"`
 	Crypto          string `json:"crypto"`
 }
@@ -59,7 +74,16 @@ type User struct {
 	Username             string
 	HashedPassword       []byte
 	EthAddress           string
+	EVMAddresses         map[uint64]string // chain ID -> address on EVM chains besides Ethereum, empty for EthAddress
 	SolAddress           string
+	TronAddress          string // base58 Tron address TRC-20 tokens are received at
+	Fiat                 string // fiat currency code MinDono and the progress bar are in
+	BTCXpub              string // account-level xpub, ypub or zpub dono addresses are derived from
+	BTCGapLimit          int    // unpaid addresses in a row the streamer's wallet looks through
+	LNKind               string // lnd or cln
+	LNURL                string // REST URL of the streamer's Lightning node
+	LNCredential         string // invoice macaroon (hex) or rune, sealed with the secret key
+	LNCert               string // PEM certificate of the node, if self-signed
 	HexcoinAddress       string
 	XMRWalletPassword    string
 	MinDono              int
@@ -71,22 +95,15 @@ type User struct {
 	DonoGIF              string
 	DonoSound            string
 	AlertURL             string
-	MinSol               float64
-	MinEth               float64
-	MinXmr               float64
-	MinPaint             float64
-	MinHex               float64
-	MinMatic             float64
-	MinBusd              float64
-	MinShib              float64
-	MinUsdc              float64
-	MinTusd              float64
-	MinWbtc              float64
-	MinPnk               float64
+	MinDonos             map[string]float64 // minimum dono per currency code
 	DateEnabled          time.Time
 	WalletUploaded       bool
 	WalletRunning        bool
 	WalletPending        bool
+	WalletState          string // monero-wallet-rpc state, not stored
+	XMRAddressMode       string // "integrated" or "subaddress"
+	XMRConfirmations     int    // confirmations before an XMR dono counts
+	AlertOn              string // "confirmed" or "seen", the dono state that shows the alert
 	CryptosEnabled       CryptosEnabled
 	BillingData          BillingData
 	DefaultCrypto        string
@@ -113,14 +130,6 @@ type Transfer struct {
 	RawContract     RawContract `json:"rawContract"`
 }
 
-type Response struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Id      int    `json:"id"`
-	Result  struct {
-		Transfers []Transfer `json:"transfers"`
-	} `json:"result"`
-}
-
 type PriceData struct {
 	Monero struct {
 		Usd float64 `json:"usd"`
@@ -143,30 +152,18 @@ type CryptoData struct {
 	Enabled    bool   `json:"enabled"`
 }
 
-type CryptosEnabled struct {
-	XMR   bool
-	SOL   bool
-	ETH   bool
-	PAINT bool
-	HEX   bool
-	MATIC bool
-	BUSD  bool
-	SHIB  bool
-	PNK   bool
-}
+// CryptosEnabled maps a currency code to whether the user accepts it.
+type CryptosEnabled map[string]bool
 
-type CryptoPrice struct {
-	Monero     float64 `json:"monero"`
-	Solana     float64 `json:"solana"`
-	Ethereum   float64 `json:"ethereum"`
-	Paint      float64 `json:"paint"`
-	Hexcoin    float64 `json:"hex"`
-	Polygon    float64 `json:"matic"`
-	BinanceUSD float64 `json:"binance-usd"`
-	ShibaInu   float64 `json:"shiba-inu"`
-	Kleros     float64 `json:"pnk"`
-	WBTC       float64 `json:"wbtc"`
-	TUSD       float64 `json: "tusd"`
+// DefaultCryptosEnabled returns what a new user accepts: every currency in
+// the registry, each offered once there is an address for its chain, except
+// Monero, which needs a wallet uploaded first.
+func DefaultCryptosEnabled() CryptosEnabled {
+	ce := CryptosEnabled{}
+	for _, c := range Currencies {
+		ce[c.Code] = c.Chain != ChainMonero
+	}
+	return ce
 }
 
 type UserPageData struct {
@@ -211,27 +208,19 @@ type BillingData struct {
 	UpdatedAt       time.Time
 }
 
+// CurrencyDisplay is one currency as shown on the donation page.
+type CurrencyDisplay struct {
+	Currency
+	Min     float64
+	Price   float64 // in the streamer's fiat currency, 0 if unknown or stale
+	Enabled bool
+}
+
 type IndexDisplay struct {
 	MaxChar        int
 	MinDono        int
-	MinSolana      float64
-	MinMonero      float64
-	MinEthereum    float64
-	MinPaint       float64
-	MinHex         float64
-	MinPolygon     float64
-	MinBusd        float64
-	MinShib        float64
-	MinPnk         float64
-	SolPrice       float64
-	XMRPrice       float64
-	ETHPrice       float64
-	PaintPrice     float64
-	HexPrice       float64
-	PolygonPrice   float64
-	BusdPrice      float64
-	ShibPrice      float64
-	PnkPrice       float64
+	FiatSymbol     string // of the fiat currency MinDono is in
+	Currencies     []CurrencyDisplay
 	MinAmnt        float64
 	WalletPending  bool
 	Links          string
@@ -248,6 +237,7 @@ type AlertPageData struct {
 	Currency      string
 	MediaURL      string
 	USDAmount     float64
+	FiatValue     string // the dono's value in the streamer's fiat currency, with its symbol
 	Refresh       int
 	DisplayToggle string
 	Userpath      string
@@ -255,8 +245,9 @@ type AlertPageData struct {
 
 type ProgressbarData struct {
 	Message string
-	Needed  float64
+	Needed  float64 // in the user's fiat currency
 	Sent    float64
+	Symbol  string // of the user's fiat currency
 	Refresh int
 }
 
@@ -320,19 +311,40 @@ type ViewDonosData struct {
 }
 
 type Dono struct {
-	ID           int
-	UserID       int
-	Address      string
-	Name         string
-	Message      string
-	AmountToSend string
-	AmountSent   string
-	CurrencyType string
-	AnonDono     bool
-	Fulfilled    bool
-	EncryptedIP  string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	USDAmount    float64
-	MediaURL     string
+	ID             int
+	UserID         int
+	Address        string
+	Name           string
+	Message        string
+	AmountToSend   string   // display amount, formatted from AtomicToSend
+	AmountSent     string   // display amount, formatted from AtomicSent
+	AtomicToSend   *big.Int // amount expected, in the currency's smallest unit
+	AtomicSent     *big.Int
+	CurrencyType   string
+	AnonDono       bool
+	State          DonoState
+	Seen           bool   // payment showed up, possibly still unconfirmed
+	BlockNumber    uint64 // block the payment was matched in, on chains that can reorg
+	BlockHash      string
+	TxHash         string // transaction that paid the dono, claimed by no other dono
+	LogIndex       int
+	Reference      string // Solana Pay reference key the payment carries
+	ChainID        uint64 // EVM chain the dono is paid on, 0 off EVM chains
+	EncryptedIP    string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	ExpiresAt      time.Time // when the payment request expires, zero to use the usual timeout
+	SeenAt         time.Time // when the payment showed up, zero if it hasn't or wasn't watched for
+	Rate           float64   // USD price of one unit of CurrencyType the dono was quoted at
+	QuotedAt       time.Time // when the rate was quoted
+	QuoteExpiresAt time.Time // payments after this are valued at the rate when they arrive
+	USDAmount      float64
+	FiatAmount     float64 // value in Fiat, the streamer's fiat currency when the dono was made
+	Fiat           string
+	MediaURL       string
+}
+
+// FiatValue formats the dono's fiat value with the currency's symbol.
+func (d Dono) FiatValue() string {
+	return FormatFiat(d.FiatAmount, d.Fiat)
 }